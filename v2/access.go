@@ -0,0 +1,161 @@
+package x402
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HeaderAccessToken carries an access token on HTTP requests (see AccessGrant).
+const HeaderAccessToken = "PAYMENT-ACCESS-TOKEN"
+
+// minAccessTokenKeyLength is the shortest Config.AccessTokenKey accepted.
+const minAccessTokenKeyLength = 32
+
+// AccessGrant makes a payment buy time-boxed access instead of a single
+// request. After settlement the payer receives a signed access token, a JWT
+// returned in the accessToken field of the payment response. Requests that
+// present it in the PAYMENT-ACCESS-TOKEN header (HTTP) or the
+// payment-access-token metadata key (gRPC) before it expires are admitted
+// without payment.
+type AccessGrant struct {
+	// Duration is how long the access lasts.
+	Duration time.Duration `yaml:"duration"`
+
+	// Patterns lists the endpoint or method patterns the token covers.
+	// Defaults to the pattern of the rule that was paid for.
+	Patterns []string `yaml:"patterns"`
+}
+
+// Validate checks if the access grant is valid.
+func (g *AccessGrant) Validate() error {
+	if g.Duration <= 0 {
+		return fmt.Errorf("access duration must be positive")
+	}
+	for _, pattern := range g.Patterns {
+		if _, err := compileRoute(pattern, true); err != nil {
+			return fmt.Errorf("invalid access pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// accessClaims are the JWT claims of an access token.
+type accessClaims struct {
+	Subject     string   `json:"sub"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	Patterns    []string `json:"x402_patterns"`
+	Transaction string   `json:"x402_tx,omitempty"`
+	Network     string   `json:"x402_network,omitempty"`
+	Amount      string   `json:"x402_amount,omitempty"`
+	TokenSymbol string   `json:"x402_token,omitempty"`
+}
+
+var accessTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// issueAccessToken mints the access token for a settled payment for rule,
+// and records its expiry in payment.
+func (c *Config) issueAccessToken(rule *PricingRule, payment *PaymentContext, result *SettlementResult) (string, error) {
+	patterns := rule.Access.Patterns
+	if len(patterns) == 0 && rule.pattern != "" {
+		patterns = []string{rule.pattern}
+	}
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("access grant covers no patterns")
+	}
+	if len(c.AccessTokenKey) < minAccessTokenKeyLength {
+		return "", fmt.Errorf("access token key must be at least %d bytes", minAccessTokenKeyLength)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(rule.Access.Duration)
+	claims, err := json.Marshal(accessClaims{
+		Subject:     payment.PayerAddress,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiresAt.Unix(),
+		Patterns:    patterns,
+		Transaction: result.TransactionHash,
+		Network:     payment.Network,
+		Amount:      payment.Amount,
+		TokenSymbol: payment.TokenSymbol,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal access claims: %w", err)
+	}
+
+	signingInput := accessTokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	payment.AccessExpiresAt = time.Unix(expiresAt.Unix(), 0)
+	return signingInput + "." + c.signAccessToken(signingInput), nil
+}
+
+func (c *Config) signAccessToken(signingInput string) string {
+	mac := hmac.New(sha256.New, c.AccessTokenKey)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAccessToken checks an access token for a request and returns the
+// PaymentContext of the payment that bought it. method is the HTTP method,
+// or empty for gRPC, and path is the request path or full gRPC method. It
+// fails if the token is malformed, was not signed with AccessTokenKey, has
+// expired, or does not cover the request.
+func (c *Config) VerifyAccessToken(token, method, path string) (*PaymentContext, error) {
+	if len(c.AccessTokenKey) < minAccessTokenKeyLength {
+		return nil, fmt.Errorf("access tokens are not enabled")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != accessTokenHeader {
+		return nil, fmt.Errorf("malformed access token")
+	}
+	expected := c.signAccessToken(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, fmt.Errorf("invalid access token signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed access token claims: %w", err)
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("malformed access token claims: %w", err)
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, fmt.Errorf("access token expired at %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	if !accessCovers(claims.Patterns, method, path) {
+		return nil, fmt.Errorf("access token does not cover %s", path)
+	}
+
+	return &PaymentContext{
+		Verified:        true,
+		PayerAddress:    claims.Subject,
+		Amount:          claims.Amount,
+		TokenSymbol:     claims.TokenSymbol,
+		Network:         claims.Network,
+		TransactionHash: claims.Transaction,
+		SettledAt:       time.Unix(claims.IssuedAt, 0),
+		AccessExpiresAt: expiresAt,
+		Settlement:      SettlementConfirmed,
+	}, nil
+}
+
+// accessCovers reports whether any of patterns matches the request.
+func accessCovers(patterns []string, method, path string) bool {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, pattern := range patterns {
+		r, err := compileRoute(pattern, true)
+		if err == nil && r.match(method, segments) {
+			return true
+		}
+	}
+	return false
+}
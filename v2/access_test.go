@@ -0,0 +1,199 @@
+package x402
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAccessKey = []byte("0123456789abcdef0123456789abcdef")
+
+func accessConfig(verifies *int) Config {
+	token := testConfig().EndpointPricing["/v1/paid"].AcceptedTokens[0]
+	return Config{
+		Verifier: &MockVerifier{
+			VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+				*verifies++
+				return &VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: requirements.Amount}, nil
+			},
+		},
+		AccessTokenKey: testAccessKey,
+		EndpointPricing: map[string]PricingRule{
+			"/v1/premium/*": {
+				AcceptedTokens: []TokenRequirement{token},
+				Access:         &AccessGrant{Duration: 24 * time.Hour},
+			},
+			"/v1/reports/*": {
+				AcceptedTokens: []TokenRequirement{token},
+				Access:         &AccessGrant{Duration: time.Hour},
+			},
+		},
+	}
+}
+
+func TestPaymentMiddleware_AccessToken(t *testing.T) {
+	var verifies int
+	handler := PaymentMiddleware(accessConfig(&verifies))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment, _ := GetPaymentFromContext(r.Context())
+		w.Header().Set("X-Transaction", payment.TransactionHash)
+		w.Header().Set("X-Access-Expires", payment.AccessExpiresAt.Format(time.RFC3339))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/premium/article", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	response, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil {
+		t.Fatalf("failed to decode payment response: %v", err)
+	}
+	if response.AccessToken == "" {
+		t.Fatal("expected an access token in the payment response")
+	}
+	if w.Header().Get("X-Access-Expires") == (time.Time{}).Format(time.RFC3339) {
+		t.Error("expected the paying request to see the access expiry")
+	}
+
+	// The token admits later requests under the same pattern without payment.
+	req = httptest.NewRequest("GET", "/v1/premium/other", nil)
+	req.Header.Set(HeaderAccessToken, response.AccessToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected token to admit the request, got %d", w.Code)
+	}
+	if verifies != 1 {
+		t.Errorf("expected no verification for the token request, got %d verifications", verifies)
+	}
+	if w.Header().Get("X-Transaction") != "0xtxhash" {
+		t.Errorf("expected originating transaction, got %q", w.Header().Get("X-Transaction"))
+	}
+
+	// It does not cover other patterns, and a tampered token is rejected.
+	for _, tc := range []struct{ path, token string }{
+		{"/v1/reports/q3", response.AccessToken},
+		{"/v1/premium/other", response.AccessToken + "x"},
+	} {
+		req = httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set(HeaderAccessToken, tc.token)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusPaymentRequired {
+			t.Errorf("%s: expected status 402, got %d", tc.path, w.Code)
+		}
+	}
+}
+
+func TestPaymentMiddleware_AccessTokenCoversOtherRules(t *testing.T) {
+	var verifies int
+	cfg := accessConfig(&verifies)
+	premium := cfg.EndpointPricing["/v1/premium/*"]
+	premium.Access.Patterns = []string{"/v1/premium/*", "/v1/archive/**"}
+	cfg.EndpointPricing["/v1/archive/**"] = PricingRule{AcceptedTokens: premium.AcceptedTokens}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/premium/article", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	response, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil || response.AccessToken == "" {
+		t.Fatalf("expected an access token, got %v", err)
+	}
+
+	// The archive rule has no Access of its own, but the token covers it.
+	req = httptest.NewRequest("GET", "/v1/archive/2023/q4", nil)
+	req.Header.Set(HeaderAccessToken, response.AccessToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected token to admit the archive request, got %d", w.Code)
+	}
+	if verifies != 1 {
+		t.Errorf("expected no verification for the token request, got %d verifications", verifies)
+	}
+}
+
+func TestConfig_OnAccessTokenError(t *testing.T) {
+	var reported error
+	cfg := Config{
+		Verifier:           &MockVerifier{},
+		OnAccessTokenError: func(payment *PaymentContext, err error) { reported = err },
+	}
+	rule := PricingRule{Access: &AccessGrant{Duration: time.Hour, Patterns: []string{"/v1/premium/*"}}}
+	requirements := &PaymentRequirements{Scheme: SchemeExact, Amount: "1000"}
+	payment := &PaymentContext{PayerAddress: "0xPayer", Amount: "1000"}
+
+	// Without an AccessTokenKey the payment settles but no token is issued.
+	result, err := cfg.SettlePayment(context.Background(), &rule, &PaymentPayload{X402Version: 2}, requirements, payment)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.AccessToken != "" {
+		t.Errorf("expected no access token, got %q", result.AccessToken)
+	}
+	if reported == nil || !strings.Contains(reported.Error(), "access token key") {
+		t.Errorf("expected the issue error to be reported, got %v", reported)
+	}
+}
+
+func TestVerifyAccessToken_Expired(t *testing.T) {
+	cfg := Config{AccessTokenKey: testAccessKey}
+	claims, _ := json.Marshal(accessClaims{
+		Subject:   "0xPayer",
+		IssuedAt:  time.Now().Add(-2 * time.Hour).Unix(),
+		ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+		Patterns:  []string{"/v1/premium/*"},
+	})
+	signingInput := accessTokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	token := signingInput + "." + cfg.signAccessToken(signingInput)
+
+	_, err := cfg.VerifyAccessToken(token, "GET", "/v1/premium/article")
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected expired token error, got %v", err)
+	}
+
+	other := Config{AccessTokenKey: []byte("another key that is 32 bytes long")}
+	if _, err := other.VerifyAccessToken(token, "GET", "/v1/premium/article"); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected signature error for a different key, got %v", err)
+	}
+}
+
+func TestConfigValidate_Access(t *testing.T) {
+	var verifies int
+	cfg := accessConfig(&verifies)
+	cfg.AccessTokenKey = []byte("short")
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "access token key") {
+		t.Errorf("expected short key error, got %v", err)
+	}
+
+	cfg = accessConfig(&verifies)
+	cfg.DefaultPricing = &PricingRule{
+		AcceptedTokens: cfg.EndpointPricing["/v1/premium/*"].AcceptedTokens,
+		Access:         &AccessGrant{Duration: time.Hour},
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "must list its patterns") {
+		t.Errorf("expected default pricing patterns error, got %v", err)
+	}
+
+	cfg = accessConfig(&verifies)
+	premium := cfg.EndpointPricing["/v1/premium/*"]
+	premium.Access.Patterns = []string{"/v1/premium/*", "/v1/archive/**"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `"/v1/archive/**" matches no priced`) {
+		t.Errorf("expected unreachable pattern error, got %v", err)
+	}
+}
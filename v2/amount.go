@@ -0,0 +1,81 @@
+package x402
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// usdStablecoins are the token symbols a "$" price may be written for.
+var usdStablecoins = map[string]bool{
+	"USDC":  true,
+	"USDT":  true,
+	"DAI":   true,
+	"PYUSD": true,
+	"USDS":  true,
+}
+
+// ParsePrice converts a human-readable price into atomic units of a token
+// with the given number of decimals. The price is a decimal number, optionally
+// prefixed with "$" or followed by the token symbol: "$0.01", "0.01 USDC" or
+// "0.01". "$" is only accepted for USD stablecoins, where it is shorthand for
+// whole tokens; it does not convert currencies (see PricingRule.FiatPrice).
+// The conversion is exact, and prices with more decimal places than the
+// token supports are rejected.
+func ParsePrice(price string, symbol string, decimals int) (string, error) {
+	if decimals < 0 {
+		return "", fmt.Errorf("invalid token decimals %d", decimals)
+	}
+
+	value := strings.TrimSpace(price)
+	if rest, ok := strings.CutPrefix(value, "$"); ok {
+		if !usdStablecoins[strings.ToUpper(symbol)] {
+			return "", fmt.Errorf("price %q is in dollars, but %q is not a USD stablecoin; use a fiat price to convert", price, symbol)
+		}
+		value = strings.TrimSpace(rest)
+	} else if number, unit, ok := strings.Cut(value, " "); ok {
+		unit = strings.TrimSpace(unit)
+		if symbol == "" || !strings.EqualFold(unit, symbol) {
+			return "", fmt.Errorf("price %q is in %s, not %s", price, unit, symbol)
+		}
+		value = number
+	}
+
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return "", fmt.Errorf("invalid price %q", price)
+	}
+	if len(frac) > decimals {
+		return "", fmt.Errorf("price %q has more than %d decimal places", price, decimals)
+	}
+
+	amount, ok := new(big.Int).SetString(whole+frac+strings.Repeat("0", decimals-len(frac)), 10)
+	if !ok {
+		return "", fmt.Errorf("invalid price %q", price)
+	}
+	if amount.Sign() == 0 {
+		return "", fmt.Errorf("price %q must be greater than zero", price)
+	}
+	return amount.String(), nil
+}
+
+// AtomicAmount returns the token's price in atomic units: Amount, or Price
+// converted with TokenDecimals when Amount is not set.
+func (t *TokenRequirement) AtomicAmount() (string, error) {
+	if t.Amount != "" || t.Price == "" {
+		return t.Amount, nil
+	}
+	if t.TokenDecimals == 0 {
+		return "", fmt.Errorf("token decimals are required to convert price %q", t.Price)
+	}
+	return ParsePrice(t.Price, t.Symbol, t.TokenDecimals)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package x402
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		price    string
+		symbol   string
+		decimals int
+		want     string
+		wantErr  string
+	}{
+		{price: "$0.01", symbol: "USDC", decimals: 6, want: "10000"},
+		{price: "0.01 USDC", symbol: "USDC", decimals: 6, want: "10000"},
+		{price: "1.5 usdc", symbol: "USDC", decimals: 6, want: "1500000"},
+		{price: "$ 2", symbol: "USDC", decimals: 6, want: "2000000"},
+		{price: "0.000001", symbol: "USDC", decimals: 6, want: "1"},
+		{price: ".25", symbol: "DAI", decimals: 18, want: "250000000000000000"},
+		{price: "12345678901234567890.5", symbol: "DAI", decimals: 18, want: "12345678901234567890500000000000000000"},
+		{price: "$0.0000001", symbol: "USDC", decimals: 6, wantErr: "more than 6 decimal places"},
+		{price: "0.01 EURC", symbol: "USDC", decimals: 6, wantErr: "is in EURC, not USDC"},
+		{price: "$0", symbol: "USDC", decimals: 6, wantErr: "must be greater than zero"},
+		{price: "-1", symbol: "USDC", decimals: 6, wantErr: "invalid price"},
+		{price: "1e3", symbol: "USDC", decimals: 6, wantErr: "invalid price"},
+		{price: "$", symbol: "USDC", decimals: 6, wantErr: "invalid price"},
+		{price: "$1", symbol: "dai", decimals: 18, want: "1000000000000000000"},
+		{price: "$0.01", symbol: "EURC", decimals: 6, wantErr: "not a USD stablecoin"},
+		{price: "$0.01", symbol: "WETH", decimals: 18, wantErr: "not a USD stablecoin"},
+		{price: "1.2.3", symbol: "USDC", decimals: 6, wantErr: "invalid price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.price, func(t *testing.T) {
+			got, err := ParsePrice(tt.price, tt.symbol, tt.decimals)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTokenRequirement_ValidatePrice(t *testing.T) {
+	token := TokenRequirement{
+		Network:       "eip155:84532",
+		AssetContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+		Symbol:        "USDC",
+		Recipient:     "0xRecipient",
+		Price:         "$0.01",
+		TokenDecimals: 6,
+	}
+	if err := token.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.Amount != "10000" {
+		t.Errorf("expected Amount 10000, got %q", token.Amount)
+	}
+
+	mismatched := token
+	mismatched.Amount = "1000"
+	if err := mismatched.Validate(); err == nil || !strings.Contains(err.Error(), "does not match price") {
+		t.Errorf("expected mismatch error, got %v", err)
+	}
+
+	noDecimals := token
+	noDecimals.Amount = ""
+	noDecimals.TokenDecimals = 0
+	if err := noDecimals.Validate(); err == nil || !strings.Contains(err.Error(), "token decimals are required") {
+		t.Errorf("expected missing decimals error, got %v", err)
+	}
+}
+
+func TestConfigValidate_ConvertsPrice(t *testing.T) {
+	cfg := testConfig()
+	rule := cfg.EndpointPricing["/v1/paid"]
+	rule.AcceptedTokens[0].Amount = ""
+	rule.AcceptedTokens[0].Price = "0.25 USDC"
+	rule.AcceptedTokens[0].TokenDecimals = 6
+	cfg.EndpointPricing["/v1/paid"] = rule
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.EndpointPricing["/v1/paid"].AcceptedTokens[0].Amount; got != "250000" {
+		t.Errorf("expected converted amount 250000, got %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package x402
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// bufferedResponseWriter captures a handler's response so it can be inspected
+// before anything is sent to the client. Used by SettleOnSuccess.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	b.status = statusCode
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.body.Write(p)
+}
+
+// succeeded reports whether the handler returned a 2xx status.
+func (b *bufferedResponseWriter) succeeded() bool {
+	return b.status >= 200 && b.status < 300
+}
+
+// flushTo copies the buffered headers, status, and body to w.
+func (b *bufferedResponseWriter) flushTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for key, values := range b.header {
+		dst[key] = values
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
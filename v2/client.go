@@ -0,0 +1,153 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Signer creates signed payment payloads on the client side.
+type Signer interface {
+	// Sign returns a payment payload that satisfies requirements.
+	// If the returned payload leaves Accepted empty, it is filled in from requirements.
+	Sign(ctx context.Context, requirements *PaymentRequirements) (*PaymentPayload, error)
+}
+
+// RequirementsSelector chooses which of a server's accepted payment options to pay with.
+type RequirementsSelector func(accepts []PaymentRequirements) (*PaymentRequirements, error)
+
+// SelectFirst picks the first accepted payment option.
+func SelectFirst(accepts []PaymentRequirements) (*PaymentRequirements, error) {
+	if len(accepts) == 0 {
+		return nil, fmt.Errorf("server did not offer any payment options")
+	}
+	return &accepts[0], nil
+}
+
+// Transport is an http.RoundTripper that pays for x402-protected resources.
+// On a 402 response it reads the server's payment requirements, picks one
+// with Select, signs it with Signer, and retries the request once with a
+// PAYMENT-SIGNATURE header. Use ReadPaymentResponse on the returned response
+// to get the settlement receipt.
+type Transport struct {
+	// Base is the underlying transport. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Signer creates payment payloads. Required.
+	Signer Signer
+
+	// Select chooses a payment option from the 402 response. Defaults to SelectFirst.
+	Select RequirementsSelector
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Signer == nil {
+		return nil, fmt.Errorf("x402 transport: signer is required")
+	}
+
+	// The request may need to be sent twice, so make sure the body can be replayed.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("x402 transport: failed to read request body: %w", err)
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusPaymentRequired {
+		return resp, err
+	}
+
+	paymentReq, err := ReadPaymentRequirements(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("x402 transport: %w", err)
+	}
+
+	header, err := t.pay(req.Context(), paymentReq)
+	if err != nil {
+		return nil, fmt.Errorf("x402 transport: %w", err)
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("x402 transport: failed to rewind request body: %w", err)
+		}
+		retry.Body = body
+	}
+	retry.Header.Set(HeaderPaymentSignature, header)
+
+	return t.base().RoundTrip(retry)
+}
+
+// pay selects and signs a payment option, returning the encoded PAYMENT-SIGNATURE header.
+func (t *Transport) pay(ctx context.Context, paymentReq *PaymentRequiredResponse) (string, error) {
+	selectFn := t.Select
+	if selectFn == nil {
+		selectFn = SelectFirst
+	}
+
+	requirements, err := selectFn(paymentReq.Accepts)
+	if err != nil {
+		return "", fmt.Errorf("failed to select payment option: %w", err)
+	}
+
+	payload, err := SignPayment(ctx, t.Signer, requirements)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodePaymentPayload(payload)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// SignPayment signs requirements with signer and fills in the protocol
+// fields the signer left empty.
+func SignPayment(ctx context.Context, signer Signer, requirements *PaymentRequirements) (*PaymentPayload, error) {
+	payload, err := signer.Sign(ctx, requirements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign payment: %w", err)
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("signer returned no payload")
+	}
+
+	if payload.X402Version == 0 {
+		payload.X402Version = 2
+	}
+	if payload.Accepted.Scheme == "" && payload.Accepted.Network == "" {
+		payload.Accepted = *requirements
+	}
+
+	return payload, nil
+}
+
+// ReadPaymentResponse extracts the settlement receipt from a paid response.
+// It reads PAYMENT-RESPONSE first and falls back to the V1 X-PAYMENT-RESPONSE header.
+func ReadPaymentResponse(resp *http.Response) (*PaymentResponse, error) {
+	header := resp.Header.Get(HeaderPaymentResponse)
+	if header == "" {
+		header = resp.Header.Get(HeaderLegacyPaymentResponse)
+	}
+	if header == "" {
+		return nil, fmt.Errorf("response has no payment response header")
+	}
+	return DecodePaymentResponse(header)
+}
@@ -0,0 +1,148 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mockSigner struct {
+	signed []*PaymentRequirements
+	err    error
+}
+
+func (s *mockSigner) Sign(ctx context.Context, requirements *PaymentRequirements) (*PaymentPayload, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.signed = append(s.signed, requirements)
+	return &PaymentPayload{
+		Payload: map[string]interface{}{
+			"signature": "0xsig",
+			"authorization": map[string]interface{}{
+				"from":  "0xPayer",
+				"to":    requirements.PayTo,
+				"value": requirements.Amount,
+				"nonce": "0xnonce",
+			},
+		},
+	}, nil
+}
+
+func TestTransport_PaysAndRetries(t *testing.T) {
+	var gotPayload *PaymentPayload
+	cfg := testConfig()
+	cfg.Verifier = &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			gotPayload = payload
+			return &VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: "1000000"}, nil
+		},
+	}
+
+	server := httptest.NewServer(PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("echo:" + string(body)))
+	})))
+	defer server.Close()
+
+	signer := &mockSigner{}
+	client := &http.Client{Transport: &Transport{Signer: signer}}
+
+	resp, err := client.Post(server.URL+"/v1/paid", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "echo:hello" {
+		t.Errorf("expected request body to be replayed, got %q", body)
+	}
+
+	if len(signer.signed) != 1 || signer.signed[0].Amount != "1000000" {
+		t.Fatalf("expected signer to be called once with the server's requirements, got %+v", signer.signed)
+	}
+	if gotPayload == nil || gotPayload.X402Version != 2 || gotPayload.Accepted.PayTo != "0xRecipient" {
+		t.Errorf("expected payload to carry version and accepted requirements, got %+v", gotPayload)
+	}
+
+	paymentResp, err := ReadPaymentResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to read payment response: %v", err)
+	}
+	if !paymentResp.Success || paymentResp.Transaction != "0xtxhash" {
+		t.Errorf("unexpected payment response: %+v", paymentResp)
+	}
+}
+
+func TestTransport_FreeEndpoint(t *testing.T) {
+	server := httptest.NewServer(PaymentMiddleware(testConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("free"))
+	})))
+	defer server.Close()
+
+	signer := &mockSigner{}
+	client := &http.Client{Transport: &Transport{Signer: signer}}
+
+	resp, err := client.Get(server.URL + "/v1/free")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+	if len(signer.signed) != 0 {
+		t.Error("signer should not be called for free endpoints")
+	}
+	if _, err := ReadPaymentResponse(resp); err == nil {
+		t.Error("expected error reading payment response from unpaid response")
+	}
+}
+
+func TestTransport_SignerError(t *testing.T) {
+	server := httptest.NewServer(PaymentMiddleware(testConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Signer: &mockSigner{err: errors.New("wallet locked")}}}
+
+	_, err := client.Get(server.URL + "/v1/paid")
+	if err == nil || !strings.Contains(err.Error(), "wallet locked") {
+		t.Errorf("expected signer error, got %v", err)
+	}
+}
+
+func TestTransport_CustomSelector(t *testing.T) {
+	server := httptest.NewServer(PaymentMiddleware(testConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Signer: &mockSigner{},
+		Select: func(accepts []PaymentRequirements) (*PaymentRequirements, error) {
+			return nil, errors.New("no acceptable network")
+		},
+	}}
+
+	_, err := client.Get(server.URL + "/v1/paid")
+	if err == nil || !strings.Contains(err.Error(), "no acceptable network") {
+		t.Errorf("expected selector error, got %v", err)
+	}
+}
+
+func TestSelectFirst_Empty(t *testing.T) {
+	if _, err := SelectFirst(nil); err == nil {
+		t.Error("expected error for empty accepts")
+	}
+}
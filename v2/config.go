@@ -2,7 +2,6 @@ package x402
 
 import (
 	"fmt"
-	"path"
 	"strings"
 	"time"
 )
@@ -10,78 +9,308 @@ import (
 // Config holds the middleware configuration.
 type Config struct {
 	// Verifier is the payment verification backend (e.g., EVMVerifier).
-	Verifier ChainVerifier
+	Verifier ChainVerifier `yaml:"-"`
 
 	// EndpointPricing maps URL patterns to pricing rules.
-	// Patterns support exact matches ("/v1/endpoint") and wildcards ("/v1/*").
+	// Patterns are "[METHOD ]/path": exact paths ("/v1/endpoint"), path
+	// parameters ("GET /v1/items/{id}"), globs ("/v1/*.json"), and catch-alls
+	// ("/v1/files/**"). A trailing "/*" matches the path and everything below it.
+	// See MatchRequest for precedence.
 	// Used by HTTP middleware (grpc-gateway).
-	EndpointPricing map[string]PricingRule
+	EndpointPricing map[string]PricingRule `yaml:"endpoint_pricing"`
+
+	// EndpointRoutes lists endpoint patterns in order (optional). They are
+	// matched together with EndpointPricing; declaration order breaks ties
+	// between equally specific patterns.
+	EndpointRoutes []EndpointRoute `yaml:"endpoint_routes"`
 
 	// MethodPricing maps gRPC method names to pricing rules.
 	// Methods are full names like "/package.Service/Method".
 	// Supports wildcards: "/package.Service/*" matches all methods in a service.
 	// Used by native gRPC interceptors.
-	MethodPricing map[string]PricingRule
+	MethodPricing map[string]PricingRule `yaml:"method_pricing"`
+
+	// GatewayRoutes maps grpc-gateway routes ("GET /v1/items/{id}") to the gRPC
+	// methods they invoke ("/package.Service/GetItem"), so GatewayMiddleware can
+	// price HTTP requests from MethodPricing (optional). See LoadGatewayRoutes.
+	GatewayRoutes map[string]string `yaml:"gateway_routes"`
 
 	// DefaultPricing is used when no pattern matches (optional).
 	// If nil, unmatched endpoints don't require payment.
-	DefaultPricing *PricingRule
+	DefaultPricing *PricingRule `yaml:"default_pricing"`
 
 	// ValidityDuration is how long payment requirements are valid.
 	// Defaults to 5 minutes.
-	ValidityDuration time.Duration
+	ValidityDuration time.Duration `yaml:"validity_duration"`
 
 	// SkipPaths lists paths that should bypass payment checks entirely.
-	SkipPaths []string
+	// Entries use the EndpointPricing pattern syntax.
+	SkipPaths []string `yaml:"skip_paths"`
 
 	// SkipMethods lists gRPC methods that should bypass payment checks.
-	SkipMethods []string
+	SkipMethods []string `yaml:"skip_methods"`
 
 	// CustomPaywallHTML is custom HTML to return for browser requests (optional).
-	CustomPaywallHTML string
+	CustomPaywallHTML string `yaml:"custom_paywall_html"`
+
+	// SettlementMode controls when verified payments are settled.
+	// Defaults to SettleBeforeHandler.
+	SettlementMode SettlementMode `yaml:"settlement_mode"`
+
+	// NonceStore enables replay protection. When set, each payment's nonce
+	// is reserved before verification and consumed after settlement, so
+	// duplicate submissions are rejected with 409 Conflict (HTTP) or
+	// ALREADY_EXISTS (gRPC) without reaching the verifier. It is required
+	// in SettleAsync mode and if any rule has Credits.
+	NonceStore NonceStore `yaml:"-"`
+
+	// StrictPaymentMatching rejects V2 payments whose accepted requirements do
+	// not exactly match a configured token. When false (the default), payments
+	// for an unknown asset/network fall back to the first accepted token, which
+	// keeps lenient legacy clients working. V1 payments are always lenient.
+	StrictPaymentMatching bool `yaml:"strict_payment_matching"`
+
+	// LegacyPaymentRequiredMessage makes the gRPC interceptors put base64 JSON
+	// requirements in the RESOURCE_EXHAUSTED status message, as older releases did,
+	// for clients that don't read status details. The requirements are always
+	// attached as a status detail and a payment-required trailer as well.
+	LegacyPaymentRequiredMessage bool `yaml:"legacy_payment_required_message"`
+
+	// FacilitatorURL is the facilitator endpoint for building a Verifier
+	// (e.g., evm.NewEVMVerifier(cfg.FacilitatorURL)) after loading a config
+	// file. The middleware does not use it directly.
+	FacilitatorURL string `yaml:"facilitator_url"`
+
+	// AccessTokenKey is the HMAC-SHA256 key that signs access tokens. It is
+	// required, with at least 32 bytes, if any rule has Access.
+	AccessTokenKey []byte `yaml:"-"`
+
+	// IdempotencyStore lets clients retry a settled request with the same
+	// Idempotency-Key header (or idempotency-key metadata) without paying
+	// again (optional). A retry for the same resource that resends the
+	// settled payment, or brings a verified payment from the same payer, is
+	// not settled and gets the original payment context. Keys are reserved
+	// while their first request is in flight.
+	IdempotencyStore IdempotencyStore `yaml:"-"`
+
+	// IdempotencyWindow is how long idempotency keys are remembered.
+	// Defaults to 24 hours.
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+
+	// CacheIdempotentResponses also stores the original response, so a
+	// retry replays it instead of running the handler again.
+	CacheIdempotentResponses bool `yaml:"cache_idempotent_responses"`
+
+	// Settler settles payments in the background. It is required in
+	// SettleAsync mode.
+	Settler *Settler `yaml:"-"`
+
+	// Ledger records every settled payment (optional).
+	Ledger Ledger `yaml:"-"`
+
+	// OnLedgerError is called when a settlement cannot be recorded in the
+	// Ledger (optional). The payment itself has succeeded.
+	OnLedgerError func(LedgerRecord, error) `yaml:"-"`
+
+	// OnAccessTokenError is called when the access token bought by a payment
+	// cannot be issued (optional). The payment itself has succeeded.
+	OnAccessTokenError func(*PaymentContext, error) `yaml:"-"`
+
+	// BalanceStore holds prepaid credit for rules with Credits. It is
+	// required if any rule has Credits.
+	BalanceStore BalanceStore `yaml:"-"`
+
+	// RateProvider converts PricingRule.FiatPrice into token amounts. It is
+	// required if any rule has a FiatPrice.
+	RateProvider RateProvider `yaml:"-"`
+
+	// quotes records the fiat quotes issued in payment challenges.
+	quotes *quoteBook
+
+	// matchers holds the pricing tables compiled by Validate.
+	matchers *configMatchers
+}
+
+// SettlementMode controls when a verified payment is settled relative to the handler.
+type SettlementMode int
+
+const (
+	// SettleBeforeHandler settles the payment before the handler runs.
+	SettleBeforeHandler SettlementMode = iota
+
+	// SettleOnSuccess verifies the payment, runs the handler, and settles only
+	// if the handler succeeds (HTTP 2xx or a nil gRPC error). HTTP responses are
+	// buffered until settlement completes, and a settlement failure replaces
+	// the buffered response with a payment error.
+	SettleOnSuccess
+
+	// SettleAsync admits the request once the payment is verified and queues
+	// the payment on Config.Settler, which settles it in the background.
+	// "upto" payments, rules with Credits or Access, and payments that don't
+	// fit in the queue are settled as in SettleBeforeHandler.
+	SettleAsync
+)
+
+// String returns the name of the settlement mode.
+func (m SettlementMode) String() string {
+	switch m {
+	case SettleBeforeHandler:
+		return "settle-before-handler"
+	case SettleOnSuccess:
+		return "settle-on-success"
+	case SettleAsync:
+		return "settle-async"
+	default:
+		return fmt.Sprintf("SettlementMode(%d)", int(m))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m SettlementMode) MarshalText() ([]byte, error) {
+	switch m {
+	case SettleBeforeHandler, SettleOnSuccess, SettleAsync:
+		return []byte(m.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown settlement mode %v", m)
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the names
+// returned by String.
+func (m *SettlementMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "", SettleBeforeHandler.String():
+		*m = SettleBeforeHandler
+	case SettleOnSuccess.String():
+		*m = SettleOnSuccess
+	case SettleAsync.String():
+		*m = SettleAsync
+	default:
+		return fmt.Errorf("unknown settlement mode %q", text)
+	}
+	return nil
 }
 
 // PricingRule defines payment requirements for an endpoint.
 type PricingRule struct {
+	// Scheme is the x402 payment scheme: SchemeExact (the default) charges
+	// the token's Amount, SchemeUpto charges up to it (see SchemeUpto).
+	Scheme string `yaml:"scheme"`
+
 	// AcceptedTokens lists the currencies/tokens accepted for this endpoint.
 	// Each token specifies its own Amount in atomic units.
-	AcceptedTokens []TokenRequirement
+	AcceptedTokens []TokenRequirement `yaml:"accepted_tokens"`
+
+	// FiatPrice is the price in US dollars, such as "0.05" or "$0.05"
+	// (optional). Each accepted token without its own Amount or Price is
+	// quoted at this price through Config.RateProvider, rounding up to the
+	// token's smallest unit, and needs TokenDecimals. See Config.Quote.
+	FiatPrice string `yaml:"fiat_price"`
+
+	// PriceFunc computes AcceptedTokens per request (optional). When set, its
+	// result replaces AcceptedTokens, which may then be empty, in both the
+	// payment challenge and verification. See Price.
+	PriceFunc PriceFunc `yaml:"-"`
 
 	// Description explains what this payment is for.
-	Description string
+	Description string `yaml:"description"`
 
 	// MimeType of the resource being sold (optional).
-	MimeType string
+	MimeType string `yaml:"mime_type"`
 
 	// OutputSchema is a JSON schema describing the response format (optional).
-	OutputSchema map[string]interface{}
+	OutputSchema map[string]interface{} `yaml:"output_schema"`
+
+	// Credits lets payers prepay for this rule (optional). A payment is
+	// settled only when the payer's balance in Config.BalanceStore can't
+	// cover the price; it may then be for more than the price, and the
+	// surplus becomes credit for later requests. Not supported with the
+	// upto scheme or Metering.
+	Credits bool `yaml:"credits"`
+
+	// Access makes a payment buy time-boxed access to this rule's pattern or
+	// the grant's patterns (optional). Not supported with Metering.
+	Access *AccessGrant `yaml:"access"`
+
+	// Metering charges a gRPC streaming method for what it sends instead of
+	// once per call (optional). Ignored for HTTP endpoints and unary methods.
+	Metering *StreamMetering `yaml:"metering"`
+
+	// pattern is the endpoint or method pattern the rule was matched by.
+	pattern string
+
+	// fullMethod is the gRPC method a gateway route was priced as, if any.
+	fullMethod string
+}
+
+// Payment schemes.
+const (
+	// SchemeExact charges the advertised amount.
+	SchemeExact = "exact"
+
+	// SchemeUpto lets the client authorize the advertised amount as a maximum.
+	// The handler reports what it used with ReportUsage and only that is
+	// settled, after the handler succeeds, whatever the SettlementMode. A
+	// handler that reports nothing is charged the maximum. The Verifier must
+	// implement AmountSettler.
+	SchemeUpto = "upto"
+)
+
+// MeteringUnit is what a metered stream counts against its allowance.
+type MeteringUnit string
+
+const (
+	// MeterMessages counts messages sent by the server.
+	MeterMessages MeteringUnit = "messages"
+
+	// MeterBytes counts the encoded size of messages sent by the server.
+	MeterBytes MeteringUnit = "bytes"
+)
+
+// StreamMetering configures a metered stream. Each payment accepted on the
+// stream, the initial one and any top-ups, is settled immediately and buys
+// Allowance units. Once the allowance is used up the stream ends with a
+// payment challenge.
+type StreamMetering struct {
+	// Unit counted against the allowance. Defaults to MeterMessages.
+	Unit MeteringUnit `yaml:"unit"`
+
+	// Allowance is the number of units each payment buys.
+	Allowance int64 `yaml:"allowance"`
 }
 
 // TokenRequirement specifies a payment option (network + token).
 type TokenRequirement struct {
 	// Network is the blockchain network in CAIP-2 format (e.g., "eip155:8453").
-	Network string
+	Network string `yaml:"network"`
 
 	// AssetContract is the token contract address.
-	AssetContract string
+	AssetContract string `yaml:"asset_contract"`
 
 	// Symbol is the token symbol (e.g., "USDC").
-	Symbol string
+	Symbol string `yaml:"symbol"`
 
 	// Recipient is the address that will receive payment.
-	Recipient string
+	Recipient string `yaml:"recipient"`
 
 	// Amount is the payment amount required in atomic units for this token.
-	Amount string
+	Amount string `yaml:"amount"`
+
+	// Price is the payment amount in whole tokens, such as "0.01 USDC" or,
+	// for USD stablecoins, "$0.01" (see ParsePrice). Validate converts it
+	// into Amount using TokenDecimals; if both are set they must agree.
+	Price string `yaml:"price"`
 
 	// TokenName is the human-readable token name (optional).
-	TokenName string
+	TokenName string `yaml:"token_name"`
 
-	// TokenDecimals is the number of decimals for this token (optional).
-	TokenDecimals int
+	// TokenDecimals is the number of decimals for this token (optional,
+	// required with Price).
+	TokenDecimals int `yaml:"token_decimals"`
 }
 
-// Validate checks if the configuration is valid.
+// Validate checks if the configuration is valid and compiles the pricing
+// tables for matching. Call it again after modifying the pricing tables.
 func (c *Config) Validate() error {
 	if c.Verifier == nil {
 		return fmt.Errorf("verifier is required")
@@ -91,6 +320,21 @@ func (c *Config) Validate() error {
 		c.ValidityDuration = 5 * time.Minute
 	}
 
+	switch c.SettlementMode {
+	case SettleBeforeHandler, SettleOnSuccess:
+	case SettleAsync:
+		if c.Settler == nil {
+			return fmt.Errorf("%v requires a Settler", c.SettlementMode)
+		}
+		// Requests are admitted before their payment settles, so only the
+		// NonceStore stops the same payment from being admitted again.
+		if c.NonceStore == nil {
+			return fmt.Errorf("%v requires a nonce store", c.SettlementMode)
+		}
+	default:
+		return fmt.Errorf("unknown settlement mode %v", c.SettlementMode)
+	}
+
 	for pattern, rule := range c.EndpointPricing {
 		if err := rule.Validate(); err != nil {
 			return fmt.Errorf("invalid pricing rule for pattern %q: %w", pattern, err)
@@ -103,31 +347,269 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for _, route := range c.EndpointRoutes {
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("invalid pricing rule for route %q: %w", route.Pattern, err)
+		}
+	}
+
 	if c.DefaultPricing != nil {
 		if err := c.DefaultPricing.Validate(); err != nil {
 			return fmt.Errorf("invalid default pricing rule: %w", err)
 		}
 	}
 
+	if _, ok := c.Verifier.(AmountSettler); !ok && c.anyRule(func(r *PricingRule) bool { return r.PaymentScheme() == SchemeUpto }) {
+		return fmt.Errorf("the %s scheme requires a verifier implementing AmountSettler", SchemeUpto)
+	}
+
+	if len(c.AccessTokenKey) < minAccessTokenKeyLength && c.anyRule(func(r *PricingRule) bool { return r.Access != nil }) {
+		return fmt.Errorf("access grants require an access token key of at least %d bytes", minAccessTokenKeyLength)
+	}
+	if c.DefaultPricing != nil && c.DefaultPricing.Access != nil && len(c.DefaultPricing.Access.Patterns) == 0 {
+		return fmt.Errorf("access grant of the default pricing rule must list its patterns")
+	}
+
+	if c.anyRule(func(r *PricingRule) bool { return r.Credits }) {
+		if c.BalanceStore == nil {
+			return fmt.Errorf("credits require a balance store")
+		}
+		// Debited payments are never settled, so only the NonceStore stops
+		// them from being replayed.
+		if c.NonceStore == nil {
+			return fmt.Errorf("credits require a nonce store")
+		}
+	}
+
+	if c.RateProvider == nil && c.anyRule(func(r *PricingRule) bool { return r.FiatPrice != "" }) {
+		return fmt.Errorf("fiat prices require a rate provider")
+	}
+	if c.quotes == nil {
+		c.quotes = newQuoteBook()
+	}
+
+	matchers, err := compileMatchers(c, true)
+	if err != nil {
+		return err
+	}
+	c.matchers = matchers
+
+	// Without a default rule, a token only reaches priced endpoints and
+	// methods, so a grant pattern matching none of them is a mistake.
+	if c.DefaultPricing == nil {
+		var unreachable string
+		c.anyRule(func(r *PricingRule) bool {
+			if r.Access == nil {
+				return false
+			}
+			for _, pattern := range r.Access.Patterns {
+				if !matchers.priced(pattern) {
+					unreachable = pattern
+					return true
+				}
+			}
+			return false
+		})
+		if unreachable != "" {
+			return fmt.Errorf("access pattern %q matches no priced endpoint or method", unreachable)
+		}
+	}
+
 	return nil
 }
 
+// anyRule reports whether f holds for any pricing rule.
+func (c *Config) anyRule(f func(*PricingRule) bool) bool {
+	for _, rule := range c.EndpointPricing {
+		if f(&rule) {
+			return true
+		}
+	}
+	for _, rule := range c.MethodPricing {
+		if f(&rule) {
+			return true
+		}
+	}
+	for i := range c.EndpointRoutes {
+		if f(&c.EndpointRoutes[i].PricingRule) {
+			return true
+		}
+	}
+	return c.DefaultPricing != nil && f(c.DefaultPricing)
+}
+
+// configMatchers are the compiled forms of a Config's pricing tables and skip lists.
+type configMatchers struct {
+	endpoints   *routeMatcher
+	methods     *routeMatcher
+	skipPaths   *routeMatcher
+	skipMethods *routeMatcher
+
+	// endpointRoutes and gatewayMethods are keyed by gatewayRouteKey, for
+	// looking up a grpc-gateway route template directly.
+	endpointRoutes map[string]*PricingRule
+	gatewayMethods map[string]string
+}
+
+// compileMatchers compiles the pricing tables. When strict is false, invalid
+// patterns are ignored instead of reported.
+func compileMatchers(c *Config, strict bool) (*configMatchers, error) {
+	var m configMatchers
+	var err error
+
+	if m.endpoints, err = compilePricing(c.EndpointRoutes, c.EndpointPricing, true, strict); err != nil {
+		return nil, fmt.Errorf("invalid endpoint pattern: %w", err)
+	}
+	if m.methods, err = compilePricing(nil, c.MethodPricing, false, strict); err != nil {
+		return nil, fmt.Errorf("invalid method pattern: %w", err)
+	}
+	if m.skipPaths, err = compileSkips(c.SkipPaths, true, strict); err != nil {
+		return nil, fmt.Errorf("invalid skip path: %w", err)
+	}
+	if m.skipMethods, err = compileSkips(c.SkipMethods, false, strict); err != nil {
+		return nil, fmt.Errorf("invalid skip method: %w", err)
+	}
+
+	m.endpointRoutes = make(map[string]*PricingRule, len(m.endpoints.routes))
+	for _, r := range m.endpoints.routes {
+		key := gatewayRouteKey(r.pattern)
+		if _, ok := m.endpointRoutes[key]; !ok {
+			m.endpointRoutes[key] = r.rule
+		}
+	}
+
+	m.gatewayMethods = make(map[string]string, len(c.GatewayRoutes))
+	for pattern, fullMethod := range c.GatewayRoutes {
+		if _, err := compileRoute(pattern, true); err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid gateway route: %w", err)
+			}
+			continue
+		}
+		if strict && !strings.HasPrefix(fullMethod, "/") {
+			return nil, fmt.Errorf("invalid gateway route %q: method %q must be a full gRPC method name", pattern, fullMethod)
+		}
+		m.gatewayMethods[gatewayRouteKey(pattern)] = fullMethod
+	}
+
+	return &m, nil
+}
+
+// priced reports whether some request matching pattern is priced.
+func (m *configMatchers) priced(pattern string) bool {
+	r, err := compileRoute(pattern, true)
+	if err != nil {
+		return false
+	}
+	// gRPC methods are matched without an HTTP method.
+	return m.endpoints.overlaps(r) || (r.method == "" && m.methods.overlaps(r))
+}
+
+// compiled returns the matchers built by Validate, compiling them on the fly
+// for configs that were never validated.
+func (c *Config) compiled() *configMatchers {
+	if c.matchers != nil {
+		return c.matchers
+	}
+	m, _ := compileMatchers(c, false)
+	return m
+}
+
+// PaymentScheme returns the rule's payment scheme, defaulting to SchemeExact.
+func (p *PricingRule) PaymentScheme() string {
+	if p.Scheme == "" {
+		return SchemeExact
+	}
+	return p.Scheme
+}
+
+// Requirements builds the x402 payment requirements advertised for one of the
+// rule's accepted tokens.
+func (p *PricingRule) Requirements(token TokenRequirement, validityDuration time.Duration) PaymentRequirements {
+	requirements := token.PaymentRequirements(validityDuration)
+	requirements.Scheme = p.PaymentScheme()
+	return requirements
+}
+
 // Validate checks if the pricing rule is valid.
 func (p *PricingRule) Validate() error {
-	if len(p.AcceptedTokens) == 0 {
+	switch p.Scheme {
+	case "", SchemeExact, SchemeUpto:
+	default:
+		return fmt.Errorf("unknown scheme %q", p.Scheme)
+	}
+
+	if len(p.AcceptedTokens) == 0 && p.PriceFunc == nil {
 		return fmt.Errorf("at least one accepted token is required")
 	}
 
-	for i, token := range p.AcceptedTokens {
-		if err := token.Validate(); err != nil {
+	if p.FiatPrice != "" {
+		if _, err := parseFiatPrice(p.FiatPrice); err != nil {
+			return err
+		}
+	}
+
+	for i := range p.AcceptedTokens {
+		if err := p.validateToken(&p.AcceptedTokens[i]); err != nil {
 			return fmt.Errorf("invalid token requirement at index %d: %w", i, err)
 		}
 	}
 
+	if p.Credits && (p.Scheme == SchemeUpto || p.Metering != nil) {
+		return fmt.Errorf("credits cannot be combined with the %s scheme or metering", SchemeUpto)
+	}
+
+	if p.Access != nil {
+		if p.Metering != nil {
+			return fmt.Errorf("access cannot be combined with metering")
+		}
+		if err := p.Access.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if p.Metering != nil {
+		if p.Scheme == SchemeUpto {
+			return fmt.Errorf("metering cannot be combined with the %s scheme", SchemeUpto)
+		}
+		if err := p.Metering.Validate(); err != nil {
+			return fmt.Errorf("invalid metering: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks if the stream metering is valid.
+func (m *StreamMetering) Validate() error {
+	switch m.Unit {
+	case "", MeterMessages, MeterBytes:
+	default:
+		return fmt.Errorf("unknown unit %q", m.Unit)
+	}
+
+	if m.Allowance <= 0 {
+		return fmt.Errorf("allowance must be positive")
+	}
+
 	return nil
 }
 
-// Validate checks if the token requirement is valid.
+// validateToken validates one of the rule's tokens. Tokens of a rule with a
+// FiatPrice may leave Amount and Price unset but then need TokenDecimals.
+func (p *PricingRule) validateToken(t *TokenRequirement) error {
+	if p.FiatPrice == "" || t.Amount != "" || t.Price != "" {
+		return t.Validate()
+	}
+	if t.TokenDecimals <= 0 {
+		return fmt.Errorf("token decimals are required to quote fiat price %q", p.FiatPrice)
+	}
+	quoted := *t
+	quoted.Amount = "1"
+	return quoted.Validate()
+}
+
+// Validate checks if the token requirement is valid and converts Price into Amount.
 func (t *TokenRequirement) Validate() error {
 	if t.Network == "" {
 		return fmt.Errorf("network is required")
@@ -145,6 +627,20 @@ func (t *TokenRequirement) Validate() error {
 		return fmt.Errorf("asset contract is required")
 	}
 
+	if t.Price != "" {
+		if t.TokenDecimals == 0 {
+			return fmt.Errorf("token decimals are required to convert price %q", t.Price)
+		}
+		amount, err := ParsePrice(t.Price, t.Symbol, t.TokenDecimals)
+		if err != nil {
+			return err
+		}
+		if t.Amount != "" && t.Amount != amount {
+			return fmt.Errorf("amount %s does not match price %q (%s atomic units)", t.Amount, t.Price, amount)
+		}
+		t.Amount = amount
+	}
+
 	if t.Amount == "" {
 		return fmt.Errorf("amount is required")
 	}
@@ -152,33 +648,50 @@ func (t *TokenRequirement) Validate() error {
 	return nil
 }
 
-// MatchEndpoint finds the pricing rule for a given path.
-func (c *Config) MatchEndpoint(requestPath string) (*PricingRule, bool) {
-	for _, skipPath := range c.SkipPaths {
-		if matchPath(requestPath, skipPath) {
-			return nil, false
-		}
-	}
-
-	if rule, ok := c.EndpointPricing[requestPath]; ok {
-		return &rule, true
+// PaymentRequirements builds the x402 "exact" payment requirements advertised for
+// this token (see PricingRule.Requirements for the rule's scheme). Extra carries
+// the EIP-712 domain name and version clients need to sign EIP-3009
+// authorizations.
+func (t TokenRequirement) PaymentRequirements(validityDuration time.Duration) PaymentRequirements {
+	// Configs that were never validated may still carry only a Price.
+	amount, _ := t.AtomicAmount()
+
+	return PaymentRequirements{
+		Scheme:            SchemeExact,
+		Network:           t.Network,
+		Amount:            amount,
+		Asset:             t.AssetContract,
+		PayTo:             t.Recipient,
+		MaxTimeoutSeconds: int(validityDuration.Seconds()),
+		Extra: map[string]interface{}{
+			"name":    t.TokenName,
+			"version": "2",
+		},
 	}
+}
 
-	var bestMatch string
-	var bestRule *PricingRule
+// MatchEndpoint finds the pricing rule for a given path, ignoring patterns
+// that name an HTTP method. Use MatchRequest when the method is known.
+func (c *Config) MatchEndpoint(requestPath string) (*PricingRule, bool) {
+	return c.MatchRequest("", requestPath)
+}
 
-	for pattern, rule := range c.EndpointPricing {
-		if matchPath(requestPath, pattern) {
-			if len(pattern) > len(bestMatch) {
-				bestMatch = pattern
-				ruleCopy := rule
-				bestRule = &ruleCopy
-			}
-		}
+// MatchRequest finds the pricing rule for an HTTP request. When several
+// patterns match, patterns naming the request method win over patterns
+// without one, then the more specific pattern wins (compared segment by
+// segment: literals, then globs, then "*" or "{name}", then catch-alls),
+// then the pattern declared first (EndpointRoutes in order, followed by
+// EndpointPricing in lexical order).
+func (c *Config) MatchRequest(method, requestPath string) (*PricingRule, bool) {
+	m := c.compiled()
+
+	if m.skipPaths.match(method, requestPath) != nil {
+		return nil, false
 	}
 
-	if bestRule != nil {
-		return bestRule, true
+	if r := m.endpoints.match(method, requestPath); r != nil {
+		rule := *r.rule
+		return &rule, true
 	}
 
 	if c.DefaultPricing != nil {
@@ -188,52 +701,71 @@ func (c *Config) MatchEndpoint(requestPath string) (*PricingRule, bool) {
 	return nil, false
 }
 
-// MatchMethod finds the pricing rule for a given gRPC method.
-func (c *Config) MatchMethod(fullMethod string) (*PricingRule, bool) {
-	for _, skipMethod := range c.SkipMethods {
-		if matchPath(fullMethod, skipMethod) {
-			return nil, false
-		}
+// MatchGatewayRoute finds the pricing rule for a request served by a grpc-gateway
+// route. pattern is the route's path template (e.g. "/v1/items/{id}"), and may
+// be empty if unknown. If GatewayRoutes maps the route to a gRPC method, the
+// method is priced with MatchMethod so HTTP and native gRPC share MethodPricing.
+// Otherwise an endpoint pattern declared with the same template is used, and
+// finally the request path is matched with MatchRequest.
+func (c *Config) MatchGatewayRoute(method, pattern, requestPath string) (*PricingRule, bool) {
+	if pattern == "" {
+		return c.MatchRequest(method, requestPath)
 	}
 
-	if rule, ok := c.MethodPricing[fullMethod]; ok {
-		return &rule, true
-	}
-
-	var bestMatch string
-	var bestRule *PricingRule
-
-	for pattern, rule := range c.MethodPricing {
-		if matchPath(fullMethod, pattern) {
-			if len(pattern) > len(bestMatch) {
-				bestMatch = pattern
-				ruleCopy := rule
-				bestRule = &ruleCopy
+	m := c.compiled()
+	for _, key := range []string{gatewayRouteKey(method + " " + pattern), gatewayRouteKey(pattern)} {
+		if fullMethod, ok := m.gatewayMethods[key]; ok {
+			rule, ok := c.MatchMethod(fullMethod)
+			if !ok {
+				return nil, false
 			}
+			ruleCopy := *rule
+			ruleCopy.fullMethod = fullMethod
+			return &ruleCopy, true
 		}
 	}
 
-	if bestRule != nil {
-		return bestRule, true
+	if m.skipPaths.match(method, requestPath) != nil {
+		return nil, false
 	}
-
-	if c.DefaultPricing != nil {
-		return c.DefaultPricing, true
+	for _, key := range []string{gatewayRouteKey(method + " " + pattern), gatewayRouteKey(pattern)} {
+		if rule, ok := m.endpointRoutes[key]; ok {
+			ruleCopy := *rule
+			return &ruleCopy, true
+		}
 	}
 
-	return nil, false
+	return c.MatchRequest(method, requestPath)
+}
+
+// gatewayRouteKey normalizes a "[METHOD ]/path/{template}" route so templates
+// differing only in variable names or syntax ("{id}" and "{id=*}") are equal.
+func gatewayRouteKey(route string) string {
+	method, p, ok := strings.Cut(route, " ")
+	if !ok {
+		return pathTemplateToPattern(route)
+	}
+	return method + " " + pathTemplateToPattern(strings.TrimSpace(p))
 }
 
-func matchPath(requestPath, pattern string) bool {
-	if requestPath == pattern {
-		return true
+// MatchMethod finds the pricing rule for a given gRPC method.
+// Supports exact names and wildcards ("/package.Service/*"), with the same
+// precedence as MatchRequest.
+func (c *Config) MatchMethod(fullMethod string) (*PricingRule, bool) {
+	m := c.compiled()
+
+	if m.skipMethods.match("", fullMethod) != nil {
+		return nil, false
+	}
+
+	if r := m.methods.match("", fullMethod); r != nil {
+		rule := *r.rule
+		return &rule, true
 	}
 
-	if strings.HasSuffix(pattern, "/*") {
-		prefix := strings.TrimSuffix(pattern, "/*")
-		return strings.HasPrefix(requestPath, prefix+"/") || requestPath == prefix
+	if c.DefaultPricing != nil {
+		return c.DefaultPricing, true
 	}
 
-	matched, _ := path.Match(pattern, requestPath)
-	return matched
+	return nil, false
 }
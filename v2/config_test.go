@@ -260,6 +260,36 @@ func TestPricingRuleValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "metered",
+			rule: PricingRule{
+				AcceptedTokens: []TokenRequirement{
+					{Network: "eip155:8453", Symbol: "USDC", AssetContract: "0x123", Recipient: "0xabc", Amount: "1000000"},
+				},
+				Metering: &StreamMetering{Unit: MeterBytes, Allowance: 1 << 20},
+			},
+			wantErr: false,
+		},
+		{
+			name: "metering without allowance",
+			rule: PricingRule{
+				AcceptedTokens: []TokenRequirement{
+					{Network: "eip155:8453", Symbol: "USDC", AssetContract: "0x123", Recipient: "0xabc", Amount: "1000000"},
+				},
+				Metering: &StreamMetering{Unit: MeterMessages},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metering with unknown unit",
+			rule: PricingRule{
+				AcceptedTokens: []TokenRequirement{
+					{Network: "eip155:8453", Symbol: "USDC", AssetContract: "0x123", Recipient: "0xabc", Amount: "1000000"},
+				},
+				Metering: &StreamMetering{Unit: "requests", Allowance: 10},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
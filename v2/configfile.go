@@ -0,0 +1,354 @@
+package x402
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig reads a configuration file. See ParseConfig for the format.
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses a YAML (or JSON) configuration. Keys are the snake_case
+// yaml tags on Config, PricingRule and TokenRequirement:
+//
+//	facilitator_url: ${FACILITATOR_URL}
+//	validity_duration: 5m
+//	settlement_mode: settle-on-success
+//	skip_paths: [/health]
+//	endpoint_pricing:
+//	  /v1/premium/*:
+//	    description: Premium content
+//	    accepted_tokens:
+//	      - network: eip155:8453
+//	        asset_contract: "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"
+//	        symbol: USDC
+//	        recipient: ${RECIPIENT_ADDRESS}
+//	        amount: "10000"
+//
+// String values may reference environment variables as ${NAME} or
+// ${NAME:-default}; referencing an unset variable without a default is an
+// error. Pricing rules are validated, and errors carry the line of the
+// offending entry. The Verifier is not part of the file format and must be
+// set before the config is used.
+func ParseConfig(r io.Reader) (Config, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("config is empty")
+		}
+		return Config{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return Config{}, lineError(root, fmt.Errorf("config must be a mapping"))
+	}
+
+	if err := interpolateEnv(root); err != nil {
+		return Config{}, err
+	}
+	if err := checkConfigNode(root); err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	if err := validateConfigNode(root, &cfg); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func lineError(node *yaml.Node, err error) error {
+	return fmt.Errorf("line %d: %w", node.Line, err)
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv expands ${NAME} and ${NAME:-default} in scalar values.
+func interpolateEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+
+		var missing string
+		node.Value = envPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			m := envPattern.FindStringSubmatch(ref)
+			if value, ok := os.LookupEnv(m[1]); ok {
+				return value
+			}
+			if m[2] != "" {
+				return m[3]
+			}
+			if missing == "" {
+				missing = m[1]
+			}
+			return ""
+		})
+		if missing != "" {
+			return lineError(node, fmt.Errorf("environment variable %s is not set", missing))
+		}
+
+		// Let plain scalars resolve to their new type (e.g., token_decimals: ${DECIMALS}).
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := interpolateEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	configKeys      = yamlKeys(reflect.TypeOf(Config{}))
+	pricingRuleKeys = yamlKeys(reflect.TypeOf(PricingRule{}))
+	tokenKeys       = yamlKeys(reflect.TypeOf(TokenRequirement{}))
+	meteringKeys    = yamlKeys(reflect.TypeOf(StreamMetering{}))
+	accessKeys      = yamlKeys(reflect.TypeOf(AccessGrant{}))
+)
+
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// checkConfigNode rejects unknown keys so typos don't silently drop pricing,
+// and checks values the YAML decoder reports without a line.
+func checkConfigNode(root *yaml.Node) error {
+	if err := checkKeys(root, configKeys); err != nil {
+		return err
+	}
+
+	if node := mappingValue(root, "settlement_mode"); node != nil {
+		var mode SettlementMode
+		if err := mode.UnmarshalText([]byte(node.Value)); err != nil {
+			return lineError(node, err)
+		}
+	}
+
+	for _, key := range []string{"endpoint_pricing", "method_pricing"} {
+		node := mappingValue(root, key)
+		if node == nil || node.ShortTag() == "!!null" {
+			continue
+		}
+		if node.Kind != yaml.MappingNode {
+			return lineError(node, fmt.Errorf("%s must be a mapping", key))
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := checkPricingRuleNode(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if node := mappingValue(root, "endpoint_routes"); node != nil && node.ShortTag() != "!!null" {
+		if node.Kind != yaml.SequenceNode {
+			return lineError(node, fmt.Errorf("endpoint_routes must be a list"))
+		}
+		for _, item := range node.Content {
+			if err := checkPricingRuleNode(item, "pattern"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if node := mappingValue(root, "default_pricing"); node != nil && node.ShortTag() != "!!null" {
+		return checkPricingRuleNode(node)
+	}
+
+	return nil
+}
+
+func checkPricingRuleNode(node *yaml.Node, extraKeys ...string) error {
+	if node.Kind != yaml.MappingNode {
+		return lineError(node, fmt.Errorf("pricing rule must be a mapping"))
+	}
+	if err := checkKeys(node, pricingRuleKeys, extraKeys...); err != nil {
+		return err
+	}
+
+	if metering := mappingValue(node, "metering"); metering != nil && metering.Kind == yaml.MappingNode {
+		if err := checkKeys(metering, meteringKeys); err != nil {
+			return err
+		}
+	}
+	if access := mappingValue(node, "access"); access != nil && access.Kind == yaml.MappingNode {
+		if err := checkKeys(access, accessKeys); err != nil {
+			return err
+		}
+	}
+
+	tokens := mappingValue(node, "accepted_tokens")
+	if tokens == nil || tokens.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, token := range tokens.Content {
+		if token.Kind != yaml.MappingNode {
+			return lineError(token, fmt.Errorf("accepted token must be a mapping"))
+		}
+		if err := checkKeys(token, tokenKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkKeys(node *yaml.Node, known map[string]bool, extraKeys ...string) error {
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !known[key.Value] && !containsString(extraKeys, key.Value) {
+			return lineError(key, fmt.Errorf("unknown field %q", key.Value))
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfigNode runs the pricing Validate methods and reports failures
+// at the line of the rule or token that caused them.
+func validateConfigNode(root *yaml.Node, cfg *Config) error {
+	if cfg.ValidityDuration < 0 {
+		return lineError(mappingValue(root, "validity_duration"), fmt.Errorf("validity duration must not be negative"))
+	}
+
+	if node := mappingValue(root, "endpoint_pricing"); node != nil {
+		for i := 0; i < len(node.Content); i += 2 {
+			pattern := node.Content[i].Value
+			if _, err := compileRoute(pattern, true); err != nil {
+				return lineError(node.Content[i], fmt.Errorf("invalid endpoint pattern: %w", err))
+			}
+			rule := cfg.EndpointPricing[pattern]
+			if err := validateRuleNode(node.Content[i+1], &rule, fmt.Sprintf("invalid pricing rule for pattern %q", pattern)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if node := mappingValue(root, "endpoint_routes"); node != nil {
+		for i, item := range node.Content {
+			route := cfg.EndpointRoutes[i]
+			if _, err := compileRoute(route.Pattern, true); err != nil {
+				return lineError(item, fmt.Errorf("invalid endpoint pattern: %w", err))
+			}
+			if err := validateRuleNode(item, &route.PricingRule, fmt.Sprintf("invalid pricing rule for route %q", route.Pattern)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if node := mappingValue(root, "method_pricing"); node != nil {
+		for i := 0; i < len(node.Content); i += 2 {
+			method := node.Content[i].Value
+			if _, err := compileRoute(method, false); err != nil {
+				return lineError(node.Content[i], fmt.Errorf("invalid method pattern: %w", err))
+			}
+			rule := cfg.MethodPricing[method]
+			if err := validateRuleNode(node.Content[i+1], &rule, fmt.Sprintf("invalid pricing rule for method %q", method)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, skip := range []struct {
+		key         string
+		allowMethod bool
+	}{{"skip_paths", true}, {"skip_methods", false}} {
+		node := mappingValue(root, skip.key)
+		if node == nil {
+			continue
+		}
+		for _, item := range node.Content {
+			if _, err := compileRoute(item.Value, skip.allowMethod); err != nil {
+				return lineError(item, fmt.Errorf("invalid %s entry: %w", skip.key, err))
+			}
+		}
+	}
+
+	if node := mappingValue(root, "default_pricing"); node != nil && cfg.DefaultPricing != nil {
+		if err := validateRuleNode(node, cfg.DefaultPricing, "invalid default pricing rule"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateRuleNode(node *yaml.Node, rule *PricingRule, context string) error {
+	err := rule.Validate()
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%s: %w", context, err)
+
+	// Point at the first invalid token or the metering block when there is one.
+	if tokens := mappingValue(node, "accepted_tokens"); tokens != nil {
+		for i, token := range rule.AcceptedTokens {
+			if token.Validate() != nil && i < len(tokens.Content) {
+				return lineError(tokens.Content[i], err)
+			}
+		}
+	}
+	if metering := mappingValue(node, "metering"); metering != nil && rule.Metering != nil && rule.Metering.Validate() != nil {
+		return lineError(metering, err)
+	}
+	return lineError(node, err)
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package x402
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigYAML = `
+facilitator_url: ${TEST_FACILITATOR_URL}
+validity_duration: 2m
+settlement_mode: settle-on-success
+strict_payment_matching: true
+skip_paths: [/health]
+skip_methods: [/grpc.health.v1.Health/*]
+endpoint_pricing:
+  /v1/premium/*:
+    description: Premium content
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: ${TEST_RECIPIENT}
+        amount: "10000"
+        token_name: USDC
+        token_decimals: ${TEST_DECIMALS:-6}
+method_pricing:
+  /test.v1.Service/*:
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: ${TEST_RECIPIENT}
+        amount: "5000"
+default_pricing:
+  accepted_tokens:
+    - network: eip155:84532
+      asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+      symbol: USDC
+      recipient: "0xDefault"
+      amount: "1"
+`
+
+func TestParseConfig(t *testing.T) {
+	t.Setenv("TEST_FACILITATOR_URL", "https://facilitator.example")
+	t.Setenv("TEST_RECIPIENT", "0xRecipient")
+
+	cfg, err := ParseConfig(strings.NewReader(testConfigYAML))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.FacilitatorURL != "https://facilitator.example" {
+		t.Errorf("expected interpolated facilitator URL, got %q", cfg.FacilitatorURL)
+	}
+	if cfg.ValidityDuration != 2*time.Minute {
+		t.Errorf("expected validity duration 2m, got %v", cfg.ValidityDuration)
+	}
+	if cfg.SettlementMode != SettleOnSuccess {
+		t.Errorf("expected settle-on-success, got %v", cfg.SettlementMode)
+	}
+	if !cfg.StrictPaymentMatching {
+		t.Error("expected strict payment matching")
+	}
+	if len(cfg.SkipPaths) != 1 || cfg.SkipPaths[0] != "/health" {
+		t.Errorf("unexpected skip paths: %v", cfg.SkipPaths)
+	}
+	if len(cfg.SkipMethods) != 1 {
+		t.Errorf("unexpected skip methods: %v", cfg.SkipMethods)
+	}
+
+	token := cfg.EndpointPricing["/v1/premium/*"].AcceptedTokens[0]
+	if token.Recipient != "0xRecipient" {
+		t.Errorf("expected interpolated recipient, got %q", token.Recipient)
+	}
+	if token.TokenDecimals != 6 {
+		t.Errorf("expected default token decimals 6, got %d", token.TokenDecimals)
+	}
+	if got := cfg.MethodPricing["/test.v1.Service/*"].AcceptedTokens[0].Amount; got != "5000" {
+		t.Errorf("expected method amount 5000, got %s", got)
+	}
+	if cfg.DefaultPricing == nil || cfg.DefaultPricing.AcceptedTokens[0].Recipient != "0xDefault" {
+		t.Errorf("unexpected default pricing: %+v", cfg.DefaultPricing)
+	}
+
+	cfg.Verifier = &MockVerifier{}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("loaded config should validate: %v", err)
+	}
+}
+
+func TestParseConfig_Price(t *testing.T) {
+	input := `endpoint_pricing:
+  /v1/paid:
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: "0xabc"
+        price: "$0.01"
+        token_decimals: 6
+`
+
+	cfg, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.EndpointPricing["/v1/paid"].AcceptedTokens[0].Amount; got != "10000" {
+		t.Errorf("expected price converted to 10000 atomic units, got %q", got)
+	}
+}
+
+func TestParseConfig_Access(t *testing.T) {
+	input := `endpoint_pricing:
+  /v1/premium/*:
+    access:
+      duration: 24h
+      patterns: [/v1/premium/*, /v1/archive/**]
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: "0xabc"
+        amount: "1000000"
+`
+
+	cfg, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	access := cfg.EndpointPricing["/v1/premium/*"].Access
+	if access == nil || access.Duration != 24*time.Hour || len(access.Patterns) != 2 {
+		t.Errorf("unexpected access grant: %+v", access)
+	}
+}
+
+func TestParseConfig_JSON(t *testing.T) {
+	input := `{
+  "endpoint_pricing": {
+    "/v1/paid": {
+      "accepted_tokens": [
+        {"network": "eip155:84532", "asset_contract": "0xabc", "symbol": "USDC", "recipient": "0xdef", "amount": "1000"}
+      ]
+    }
+  }
+}`
+
+	cfg, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.EndpointPricing["/v1/paid"].AcceptedTokens[0].Amount; got != "1000" {
+		t.Errorf("expected amount 1000, got %s", got)
+	}
+}
+
+func TestParseConfig_EndpointRoutes(t *testing.T) {
+	input := `endpoint_routes:
+  - pattern: DELETE /v1/items/{id}
+    description: delete
+    accepted_tokens:
+      - {network: "eip155:84532", asset_contract: "0xabc", symbol: USDC, recipient: "0xdef", amount: "2"}
+  - pattern: /v1/items/{id}
+    accepted_tokens:
+      - {network: "eip155:84532", asset_contract: "0xabc", symbol: USDC, recipient: "0xdef", amount: "1"}
+`
+
+	cfg, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.EndpointRoutes) != 2 || cfg.EndpointRoutes[0].Pattern != "DELETE /v1/items/{id}" || cfg.EndpointRoutes[0].Description != "delete" {
+		t.Fatalf("unexpected routes: %+v", cfg.EndpointRoutes)
+	}
+
+	cfg.Verifier = &MockVerifier{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rule, _ := cfg.MatchRequest("DELETE", "/v1/items/7"); rule.AcceptedTokens[0].Amount != "2" {
+		t.Errorf("expected DELETE route, got %+v", rule)
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  "config is empty",
+		},
+		{
+			name:  "unset env var",
+			input: "facilitator_url: ${X402_TEST_UNSET}\n",
+			want:  "line 1: environment variable X402_TEST_UNSET is not set",
+		},
+		{
+			name:  "unknown top-level field",
+			input: "skip_path: [/health]\n",
+			want:  `line 1: unknown field "skip_path"`,
+		},
+		{
+			name: "unknown token field",
+			input: `endpoint_pricing:
+  /v1/paid:
+    accepted_tokens:
+      - network: eip155:84532
+        reciepient: "0xdef"
+`,
+			want: `line 5: unknown field "reciepient"`,
+		},
+		{
+			name:  "bad settlement mode",
+			input: "settlement_mode: later\n",
+			want:  `line 1: unknown settlement mode "later"`,
+		},
+		{
+			name:  "bad duration",
+			input: "validity_duration: soon\n",
+			want:  "line 1:",
+		},
+		{
+			name: "invalid token",
+			input: `endpoint_pricing:
+  /v1/paid:
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0xabc"
+        symbol: USDC
+        recipient: "0xdef"
+        amount: "1"
+      - network: eip155:8453
+        asset_contract: "0xabc"
+        symbol: USDC
+        amount: "1"
+`,
+			want: `line 9: invalid pricing rule for pattern "/v1/paid": invalid token requirement at index 1: recipient is required`,
+		},
+		{
+			name: "invalid endpoint pattern",
+			input: `skip_paths: [/health]
+endpoint_pricing:
+  /v1/**/items:
+    accepted_tokens: []
+`,
+			want: `line 3: invalid endpoint pattern: pattern "/v1/**/items": "**" must be the last segment`,
+		},
+		{
+			name: "invalid route",
+			input: `endpoint_routes:
+  - pattern: GET /v1/items/{id}
+    accepted_tokens: []
+`,
+			want: `line 2: invalid pricing rule for route "GET /v1/items/{id}": at least one accepted token is required`,
+		},
+		{
+			name: "invalid metering",
+			input: `method_pricing:
+  /test.v1.Service/Watch:
+    metering:
+      unit: messages
+      allowance: 0
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: "0xRecipient"
+        amount: "100"
+`,
+			want: `line 4: invalid pricing rule for method "/test.v1.Service/Watch": invalid metering: allowance must be positive`,
+		},
+		{
+			name: "unknown metering field",
+			input: `method_pricing:
+  /test.v1.Service/Watch:
+    metering:
+      per: message
+`,
+			want: `line 4: unknown field "per"`,
+		},
+		{
+			name: "price finer than token decimals",
+			input: `endpoint_pricing:
+  /v1/paid:
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: "0xabc"
+        price: "$0.0000001"
+        token_decimals: 6
+`,
+			want: `line 4: invalid pricing rule for pattern "/v1/paid": invalid token requirement at index 0: price "$0.0000001" has more than 6 decimal places`,
+		},
+		{
+			name: "rule without tokens",
+			input: `method_pricing:
+  /test.v1.Service/Paid:
+    description: nothing accepted
+`,
+			want: `line 3: invalid pricing rule for method "/test.v1.Service/Paid": at least one accepted token is required`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %q", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x402.yaml")
+	if err := os.WriteFile(path, []byte("skip_paths: [/health]\nvalidity_duration: -1s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfig(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+": line 2:") {
+		t.Errorf("expected error prefixed with path and line, got %v", err)
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,174 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settlementStatusCredit is the SettlementResult status of a payment debited
+// from a prepaid balance.
+const settlementStatusCredit = "credit"
+
+// ErrInsufficientBalance is returned by BalanceStore.Debit when an account's
+// balance is lower than the amount to debit.
+var ErrInsufficientBalance = errors.New("insufficient credit balance")
+
+// CreditAccount identifies a payer's credit in one token. Payer and Asset are
+// lowercase.
+type CreditAccount struct {
+	Payer   string
+	Network string // CAIP-2
+	Asset   string
+}
+
+// BalanceStore holds prepaid credit for pricing rules with Credits set.
+// Amounts are in atomic units of the account's token.
+type BalanceStore interface {
+	// Credit adds amount to the account and returns the new balance.
+	Credit(ctx context.Context, account CreditAccount, amount string) (string, error)
+
+	// Debit subtracts amount from the account and returns the new balance.
+	// It returns ErrInsufficientBalance, leaving the balance unchanged, if
+	// the balance is lower than amount.
+	Debit(ctx context.Context, account CreditAccount, amount string) (string, error)
+
+	// Balance returns the account's balance, "0" for unknown accounts.
+	Balance(ctx context.Context, account CreditAccount) (string, error)
+}
+
+// MemoryBalanceStore is an in-memory BalanceStore. It is safe for concurrent
+// use but is not shared across processes, and balances are lost on restart.
+type MemoryBalanceStore struct {
+	mu       sync.Mutex
+	balances map[CreditAccount]*big.Int
+}
+
+// NewMemoryBalanceStore creates an empty in-memory balance store.
+func NewMemoryBalanceStore() *MemoryBalanceStore {
+	return &MemoryBalanceStore{balances: make(map[CreditAccount]*big.Int)}
+}
+
+// Credit adds amount to the account.
+func (s *MemoryBalanceStore) Credit(ctx context.Context, account CreditAccount, amount string) (string, error) {
+	value, err := parseCreditAmount(amount)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance := s.balances[account]
+	if balance == nil {
+		balance = new(big.Int)
+		s.balances[account] = balance
+	}
+	balance.Add(balance, value)
+	return balance.String(), nil
+}
+
+// Debit subtracts amount from the account if the balance covers it.
+func (s *MemoryBalanceStore) Debit(ctx context.Context, account CreditAccount, amount string) (string, error) {
+	value, err := parseCreditAmount(amount)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance := s.balances[account]
+	if balance == nil || balance.Cmp(value) < 0 {
+		return "", ErrInsufficientBalance
+	}
+	balance.Sub(balance, value)
+	return balance.String(), nil
+}
+
+// Balance returns the account's balance.
+func (s *MemoryBalanceStore) Balance(ctx context.Context, account CreditAccount) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if balance := s.balances[account]; balance != nil {
+		return balance.String(), nil
+	}
+	return "0", nil
+}
+
+func parseCreditAmount(amount string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, fmt.Errorf("invalid credit amount %q", amount)
+	}
+	return value, nil
+}
+
+// creditAccount returns the account a verified payment draws on.
+func creditAccount(payment *PaymentContext, requirements *PaymentRequirements) CreditAccount {
+	return CreditAccount{
+		Payer:   strings.ToLower(payment.PayerAddress),
+		Network: requirements.Network,
+		Asset:   strings.ToLower(requirements.Asset),
+	}
+}
+
+// settleWithCredits charges a payment for a rule with Credits. The price is
+// debited from the payer's balance when it covers it, and the payment itself
+// is not settled. Otherwise the payment is settled in full and whatever it
+// paid above the price is credited to the balance.
+func (c *Config) settleWithCredits(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements, payment *PaymentContext) (*SettlementResult, error) {
+	account := creditAccount(payment, requirements)
+
+	balance, err := c.BalanceStore.Debit(ctx, account, requirements.Amount)
+	if err == nil {
+		payment.Balance = balance
+		return &SettlementResult{
+			Status:           settlementStatusCredit,
+			SettledAt:        time.Now(),
+			Amount:           requirements.Amount,
+			PayerAddress:     payment.PayerAddress,
+			RecipientAddress: requirements.PayTo,
+			Network:          requirements.Network,
+			Balance:          balance,
+		}, nil
+	}
+	if !errors.Is(err, ErrInsufficientBalance) {
+		return nil, NewPaymentError(ErrCodeSettlementFailed, "failed to debit credit balance", err)
+	}
+
+	result, err := c.Verifier.Settle(ctx, payload, requirements)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := result.Amount
+	if paid == "" {
+		paid = payment.Amount
+	}
+	surplus, price := new(big.Int), new(big.Int)
+	if _, ok := surplus.SetString(paid, 10); ok {
+		if _, ok := price.SetString(requirements.Amount, 10); ok {
+			surplus.Sub(surplus, price)
+		}
+	}
+
+	if surplus.Sign() > 0 {
+		balance, err = c.BalanceStore.Credit(ctx, account, surplus.String())
+	} else {
+		balance, err = c.BalanceStore.Balance(ctx, account)
+	}
+	if err != nil {
+		return nil, NewPaymentError(ErrCodeSettlementFailed,
+			fmt.Sprintf("payment settled in %s but updating the credit balance failed", result.TransactionHash), err)
+	}
+
+	result.Balance = balance
+	payment.Balance = balance
+	return result, nil
+}
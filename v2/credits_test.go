@@ -0,0 +1,146 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBalanceStore(t *testing.T) {
+	store := NewMemoryBalanceStore()
+	ctx := context.Background()
+	account := CreditAccount{Payer: "0xpayer", Network: "eip155:84532", Asset: "0xtoken"}
+
+	if balance, _ := store.Balance(ctx, account); balance != "0" {
+		t.Errorf("expected empty balance, got %s", balance)
+	}
+	if _, err := store.Debit(ctx, account, "1"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected ErrInsufficientBalance, got %v", err)
+	}
+
+	if balance, err := store.Credit(ctx, account, "1000"); err != nil || balance != "1000" {
+		t.Fatalf("expected balance 1000, got %s (%v)", balance, err)
+	}
+	if balance, err := store.Debit(ctx, account, "400"); err != nil || balance != "600" {
+		t.Fatalf("expected balance 600, got %s (%v)", balance, err)
+	}
+	if _, err := store.Debit(ctx, account, "601"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected ErrInsufficientBalance, got %v", err)
+	}
+	if balance, _ := store.Balance(ctx, account); balance != "600" {
+		t.Errorf("failed debit must not change the balance, got %s", balance)
+	}
+
+	other := account
+	other.Asset = "0xother"
+	if balance, _ := store.Balance(ctx, other); balance != "0" {
+		t.Errorf("balances must be per token, got %s", balance)
+	}
+
+	if _, err := store.Credit(ctx, account, "-5"); err == nil {
+		t.Error("expected error for a negative amount")
+	}
+}
+
+func creditConfig(settles *int) Config {
+	cfg := testConfig()
+	cfg.StrictPaymentMatching = true
+	cfg.BalanceStore = NewMemoryBalanceStore()
+	cfg.NonceStore = NewMemoryNonceStore()
+	cfg.Verifier = &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			*settles++
+			return &SettlementResult{TransactionHash: "0xtxhash", Network: requirements.Network, Amount: payload.Accepted.Amount}, nil
+		},
+	}
+
+	rule := cfg.EndpointPricing["/v1/paid"]
+	rule.Credits = true
+	rule.AcceptedTokens[0].Amount = "1000"
+	cfg.EndpointPricing["/v1/paid"] = rule
+	return cfg
+}
+
+func TestPaymentMiddleware_Credits(t *testing.T) {
+	var settles int
+	handler := PaymentMiddleware(creditConfig(&settles))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment, _ := GetPaymentFromContext(r.Context())
+		w.Header().Set("X-Balance", payment.Balance)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var nonces int
+	pay := func() *PaymentResponse {
+		t.Helper()
+		// The payment authorizes 1000000 for a price of 1000.
+		nonces++
+		req := httptest.NewRequest("GET", "/v1/paid", nil)
+		req.Header.Set(HeaderPaymentSignature, resignedPaymentHeader(t, fmt.Sprintf("0xnonce%d", nonces), "0xsig123"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		response, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+		if err != nil {
+			t.Fatalf("failed to decode payment response: %v", err)
+		}
+		if w.Header().Get("X-Balance") != response.Balance {
+			t.Errorf("payment context balance %s differs from header %s", w.Header().Get("X-Balance"), response.Balance)
+		}
+		return response
+	}
+
+	first := pay()
+	if settles != 1 || first.Transaction != "0xtxhash" {
+		t.Errorf("expected first payment to settle, got %d settlements (%+v)", settles, first)
+	}
+	if first.Balance != "999000" {
+		t.Errorf("expected surplus 999000 credited, got %s", first.Balance)
+	}
+
+	second := pay()
+	if settles != 1 {
+		t.Errorf("expected second request to use credit, got %d settlements", settles)
+	}
+	if !second.Success || second.Transaction != "" || second.Balance != "998000" {
+		t.Errorf("expected credit debit to 998000, got %+v", second)
+	}
+
+	// The debited payment was never settled, but it cannot be replayed.
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, resignedPaymentHeader(t, "0xnonce2", "0xsig123"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected replayed debit to get 409, got %d", w.Code)
+	}
+}
+
+func TestConfigValidate_Credits(t *testing.T) {
+	var settles int
+	cfg := creditConfig(&settles)
+	cfg.BalanceStore = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "balance store") {
+		t.Errorf("expected missing balance store error, got %v", err)
+	}
+
+	cfg = creditConfig(&settles)
+	cfg.NonceStore = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "nonce store") {
+		t.Errorf("expected missing nonce store error, got %v", err)
+	}
+
+	cfg = creditConfig(&settles)
+	rule := cfg.EndpointPricing["/v1/paid"]
+	rule.Metering = &StreamMetering{Allowance: 10}
+	cfg.EndpointPricing["/v1/paid"] = rule
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "credits cannot be combined") {
+		t.Errorf("expected credits and metering to be rejected, got %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package x402
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // PaymentError represents an error related to payment processing.
 type PaymentError struct {
@@ -29,6 +32,14 @@ const (
 	ErrCodeNetworkNotSupported = "NETWORK_NOT_SUPPORTED"
 	ErrCodeInsufficientAmount = "INSUFFICIENT_AMOUNT"
 	ErrCodeExpiredPayment     = "EXPIRED_PAYMENT"
+	ErrCodeDuplicatePayment   = "DUPLICATE_PAYMENT"
+	ErrCodeTokenNotAccepted   = "TOKEN_NOT_ACCEPTED"
+	ErrCodeAmountMismatch     = "AMOUNT_MISMATCH"
+	ErrCodeRecipientMismatch  = "RECIPIENT_MISMATCH"
+	ErrCodeSchemeMismatch     = "SCHEME_MISMATCH"
+	ErrCodePaymentRequired    = "PAYMENT_REQUIRED"
+	ErrCodePaymentRejected    = "PAYMENT_REJECTED"
+	ErrCodeIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
 )
 
 // NewPaymentError creates a new PaymentError.
@@ -40,15 +51,16 @@ func NewPaymentError(code, message string, cause error) *PaymentError {
 	}
 }
 
-// IsPaymentError checks if an error is a PaymentError.
+// IsPaymentError checks if an error is, or wraps, a PaymentError.
 func IsPaymentError(err error) bool {
-	_, ok := err.(*PaymentError)
-	return ok
+	var pe *PaymentError
+	return errors.As(err, &pe)
 }
 
-// GetPaymentErrorCode extracts the error code from a PaymentError.
+// GetPaymentErrorCode extracts the error code from a PaymentError in err's chain.
 func GetPaymentErrorCode(err error) string {
-	if pe, ok := err.(*PaymentError); ok {
+	var pe *PaymentError
+	if errors.As(err, &pe) {
 		return pe.Code
 	}
 	return ""
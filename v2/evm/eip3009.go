@@ -0,0 +1,246 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	eip712DomainTypeHash = keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+
+	transferWithAuthorizationTypeHash = keccak256([]byte("TransferWithAuthorization(address from,address to,uint256 value,uint256 validAfter,uint256 validBefore,bytes32 nonce)"))
+
+	// secp256k1HalfOrder is n/2. Signatures with a larger s are malleable and
+	// rejected by EIP-3009 token contracts.
+	secp256k1HalfOrder = new(big.Int).Rsh(secp256k1.S256().N, 1)
+)
+
+// checkAuthorization verifies an EIP-3009 TransferWithAuthorization offline.
+// It rebuilds the EIP-712 digest from the requirements, recovers the signer,
+// and checks the authorization against the requirements and the current time.
+// It returns a non-empty reason if the payment is invalid.
+func checkAuthorization(payload *EVMPayload, requirements *x402.PaymentRequirements, accepted *x402.PaymentRequirements, now time.Time) string {
+	auth := payload.Authorization
+
+	if !strings.EqualFold(auth.To, requirements.PayTo) {
+		return fmt.Sprintf("authorization recipient %s does not match payTo %s", auth.To, requirements.PayTo)
+	}
+
+	value, ok := new(big.Int).SetString(auth.Value, 10)
+	if !ok || value.Sign() < 0 || value.BitLen() > 256 {
+		return fmt.Sprintf("invalid authorization value %q", auth.Value)
+	}
+	if auth.ValidAfter < 0 || auth.ValidBefore < 0 {
+		return "invalid authorization validity window"
+	}
+	required, ok := new(big.Int).SetString(requirements.Amount, 10)
+	if !ok {
+		return fmt.Sprintf("invalid required amount %q", requirements.Amount)
+	}
+	if value.Cmp(required) < 0 {
+		return fmt.Sprintf("authorization value %s is less than required amount %s", auth.Value, requirements.Amount)
+	}
+
+	// The token contract requires validAfter < block.timestamp < validBefore.
+	if unix := now.Unix(); unix >= auth.ValidBefore {
+		return "authorization has expired"
+	} else if unix <= auth.ValidAfter {
+		return "authorization is not yet valid"
+	}
+
+	digest, err := transferWithAuthorizationDigest(auth, requirements, accepted)
+	if err != nil {
+		return err.Error()
+	}
+
+	signer, err := recoverAddress(digest, payload.Signature)
+	if err != nil {
+		return fmt.Sprintf("invalid signature: %v", err)
+	}
+	if !strings.EqualFold(signer, auth.From) {
+		return fmt.Sprintf("signature was produced by %s, not %s", signer, auth.From)
+	}
+
+	return ""
+}
+
+// transferWithAuthorizationDigest computes the EIP-712 digest the payer signed.
+// The domain name and version come from requirements.Extra, falling back to
+// the client's accepted requirements.
+func transferWithAuthorizationDigest(auth *Authorization, requirements, accepted *x402.PaymentRequirements) ([]byte, error) {
+	chainID, err := chainIDFromNetwork(requirements.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	name := extraString(requirements, accepted, "name")
+	version := extraString(requirements, accepted, "version")
+	if name == "" || version == "" {
+		return nil, fmt.Errorf("requirements are missing the EIP-712 domain name or version")
+	}
+
+	verifyingContract, err := decodeHex(requirements.Asset, 20)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asset address: %w", err)
+	}
+	from, err := decodeHex(auth.From, 20)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	to, err := decodeHex(auth.To, 20)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %w", err)
+	}
+	nonce, err := decodeHex(auth.Nonce, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	value, ok := new(big.Int).SetString(auth.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q", auth.Value)
+	}
+
+	chainIDWord, err := uint256Word(chainID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain ID: %w", err)
+	}
+	valueWord, err := uint256Word(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	validAfterWord, err := uint256Word(big.NewInt(auth.ValidAfter))
+	if err != nil {
+		return nil, fmt.Errorf("invalid validAfter: %w", err)
+	}
+	validBeforeWord, err := uint256Word(big.NewInt(auth.ValidBefore))
+	if err != nil {
+		return nil, fmt.Errorf("invalid validBefore: %w", err)
+	}
+
+	domainSeparator := keccak256(
+		eip712DomainTypeHash,
+		keccak256([]byte(name)),
+		keccak256([]byte(version)),
+		chainIDWord,
+		padWord(verifyingContract),
+	)
+
+	structHash := keccak256(
+		transferWithAuthorizationTypeHash,
+		padWord(from),
+		padWord(to),
+		valueWord,
+		validAfterWord,
+		validBeforeWord,
+		nonce,
+	)
+
+	return keccak256([]byte{0x19, 0x01}, domainSeparator, structHash), nil
+}
+
+// recoverAddress recovers the Ethereum address that produced a 65-byte
+// r || s || v signature over digest.
+func recoverAddress(digest []byte, signature string) (string, error) {
+	sig, err := decodeHex(signature, 65)
+	if err != nil {
+		return "", err
+	}
+
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", fmt.Errorf("invalid recovery id %d", sig[64])
+	}
+
+	if new(big.Int).SetBytes(sig[32:64]).Cmp(secp256k1HalfOrder) > 0 {
+		return "", fmt.Errorf("signature s value is too high")
+	}
+
+	// RecoverCompact expects [27 + recovery id] || r || s.
+	compact := make([]byte, 65)
+	compact[0] = 27 + v
+	copy(compact[1:], sig[:64])
+
+	pubKey, _, err := ecdsa.RecoverCompact(compact, digest)
+	if err != nil {
+		return "", err
+	}
+
+	return publicKeyToAddress(pubKey), nil
+}
+
+// publicKeyToAddress returns the checksum-free 0x address for pubKey.
+func publicKeyToAddress(pubKey *secp256k1.PublicKey) string {
+	hash := keccak256(pubKey.SerializeUncompressed()[1:])
+	return "0x" + hex.EncodeToString(hash[12:])
+}
+
+// chainIDFromNetwork parses the chain ID from a CAIP-2 "eip155:<id>" network.
+func chainIDFromNetwork(network string) (*big.Int, error) {
+	reference, ok := strings.CutPrefix(network, "eip155:")
+	if !ok {
+		return nil, fmt.Errorf("network %q is not an EVM (eip155) network", network)
+	}
+	chainID, ok := new(big.Int).SetString(reference, 10)
+	if !ok || chainID.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid chain ID in network %q", network)
+	}
+	return chainID, nil
+}
+
+func extraString(requirements, accepted *x402.PaymentRequirements, key string) string {
+	for _, r := range []*x402.PaymentRequirements{requirements, accepted} {
+		if r == nil {
+			continue
+		}
+		if s, ok := r.Extra[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// decodeHex decodes a 0x-prefixed hex string of exactly size bytes.
+func decodeHex(s string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
+
+// padWord left-pads a 20-byte address to a 32-byte ABI word.
+func padWord(b []byte) []byte {
+	return append(bytes.Repeat([]byte{0}, 32-len(b)), b...)
+}
+
+// uint256Word encodes n as a 32-byte ABI uint256 word. Negative numbers and
+// numbers wider than 256 bits are rejected.
+func uint256Word(n *big.Int) ([]byte, error) {
+	if n.Sign() < 0 || n.BitLen() > 256 {
+		return nil, fmt.Errorf("%s does not fit in a uint256", n)
+	}
+	return n.FillBytes(make([]byte, 32)), nil
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,288 @@
+package evm
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+const (
+	testAsset     = "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+	testRecipient = "0x209693Bc6afc0C5328bA36FaF03C514EF312287C"
+	testNonce     = "0xf3746613c2d920b5fdabc0856f2aeb2d4f88ee6037b8cc5d04a71a4462f13480"
+)
+
+var testNow = time.Unix(1740672100, 0)
+
+func TestTypeHashes(t *testing.T) {
+	// Constants from the EIP-712 spec and the FiatToken (USDC) EIP-3009 implementation.
+	if got := hex.EncodeToString(eip712DomainTypeHash); got != "8b73c3c69bb8fe3d512ecc4cf759cc79239f7b179b0ffacaa9a75d522b39400f" {
+		t.Errorf("unexpected EIP712Domain type hash %s", got)
+	}
+	if got := hex.EncodeToString(transferWithAuthorizationTypeHash); got != "7c7c6cdb67a18743f49ec6fa9b35f50d52ed05cbed4cc592e13b44501c1a2267" {
+		t.Errorf("unexpected TransferWithAuthorization type hash %s", got)
+	}
+}
+
+func TestPublicKeyToAddress(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes([]byte{1})
+	if got := publicKeyToAddress(key.PubKey()); !strings.EqualFold(got, "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf") {
+		t.Errorf("unexpected address for private key 1: %s", got)
+	}
+}
+
+func testRequirements() *x402.PaymentRequirements {
+	return &x402.PaymentRequirements{
+		Scheme:  "exact",
+		Network: "eip155:84532",
+		Amount:  "1000000",
+		Asset:   testAsset,
+		PayTo:   testRecipient,
+		Extra:   map[string]interface{}{"name": "USDC", "version": "2"},
+	}
+}
+
+// signAuthorization returns an EVMPayload signed by key.
+func signAuthorization(t *testing.T, key *secp256k1.PrivateKey, auth Authorization) *EVMPayload {
+	t.Helper()
+	digest, err := transferWithAuthorizationDigest(&auth, testRequirements(), nil)
+	if err != nil {
+		t.Fatalf("failed to build digest: %v", err)
+	}
+
+	compact := ecdsa.SignCompact(key, digest, false)
+	// SignCompact returns [v] || r || s; Ethereum wants r || s || v.
+	sig := append(append([]byte{}, compact[1:]...), compact[0])
+	return &EVMPayload{
+		Signature:     "0x" + hex.EncodeToString(sig),
+		Authorization: &auth,
+	}
+}
+
+func validAuthorization(from string) Authorization {
+	return Authorization{
+		From:        from,
+		To:          testRecipient,
+		Value:       "1000000",
+		ValidAfter:  1740672089,
+		ValidBefore: 1740672154,
+		Nonce:       testNonce,
+	}
+}
+
+func TestCheckAuthorization(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes([]byte{1})
+	payer := publicKeyToAddress(key.PubKey())
+	other := secp256k1.PrivKeyFromBytes([]byte{2})
+
+	tests := []struct {
+		name    string
+		payload func() *EVMPayload
+		req     func() *x402.PaymentRequirements
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			payload: func() *EVMPayload { return signAuthorization(t, key, validAuthorization(payer)) },
+		},
+		{
+			name: "signer is not from",
+			payload: func() *EVMPayload {
+				return signAuthorization(t, other, validAuthorization(payer))
+			},
+			wantErr: "signature was produced by",
+		},
+		{
+			name: "tampered value",
+			payload: func() *EVMPayload {
+				p := signAuthorization(t, key, validAuthorization(payer))
+				p.Authorization.Value = "2000000"
+				return p
+			},
+			wantErr: "signature was produced by",
+		},
+		{
+			name: "wrong recipient",
+			payload: func() *EVMPayload {
+				auth := validAuthorization(payer)
+				auth.To = "0x0000000000000000000000000000000000000001"
+				return signAuthorization(t, key, auth)
+			},
+			wantErr: "does not match payTo",
+		},
+		{
+			name: "insufficient value",
+			payload: func() *EVMPayload {
+				auth := validAuthorization(payer)
+				auth.Value = "999999"
+				return signAuthorization(t, key, auth)
+			},
+			wantErr: "less than required amount",
+		},
+		{
+			name: "expired",
+			payload: func() *EVMPayload {
+				auth := validAuthorization(payer)
+				auth.ValidBefore = testNow.Unix()
+				return signAuthorization(t, key, auth)
+			},
+			wantErr: "expired",
+		},
+		{
+			name: "not yet valid",
+			payload: func() *EVMPayload {
+				auth := validAuthorization(payer)
+				auth.ValidAfter = testNow.Unix() + 1
+				return signAuthorization(t, key, auth)
+			},
+			wantErr: "not yet valid",
+		},
+		{
+			name: "valid after now",
+			payload: func() *EVMPayload {
+				auth := validAuthorization(payer)
+				auth.ValidAfter = testNow.Unix()
+				return signAuthorization(t, key, auth)
+			},
+			wantErr: "not yet valid",
+		},
+		{
+			name:    "missing domain",
+			payload: func() *EVMPayload { return signAuthorization(t, key, validAuthorization(payer)) },
+			req: func() *x402.PaymentRequirements {
+				r := testRequirements()
+				r.Extra = nil
+				return r
+			},
+			wantErr: "EIP-712 domain",
+		},
+		{
+			name:    "non-EVM network",
+			payload: func() *EVMPayload { return signAuthorization(t, key, validAuthorization(payer)) },
+			req: func() *x402.PaymentRequirements {
+				r := testRequirements()
+				r.Network = "solana:mainnet"
+				return r
+			},
+			wantErr: "not an EVM",
+		},
+		{
+			name:    "oversized chain ID",
+			payload: func() *EVMPayload { return signAuthorization(t, key, validAuthorization(payer)) },
+			req: func() *x402.PaymentRequirements {
+				r := testRequirements()
+				r.Network = "eip155:" + strings.Repeat("9", 80)
+				return r
+			},
+			wantErr: "invalid chain ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequirements()
+			if tt.req != nil {
+				req = tt.req()
+			}
+			reason := checkAuthorization(tt.payload(), req, nil, testNow)
+			if tt.wantErr == "" {
+				if reason != "" {
+					t.Errorf("expected valid authorization, got %q", reason)
+				}
+				return
+			}
+			if !strings.Contains(reason, tt.wantErr) {
+				t.Errorf("expected reason containing %q, got %q", tt.wantErr, reason)
+			}
+		})
+	}
+}
+
+func TestCheckAuthorization_DomainFromAccepted(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes([]byte{1})
+	payload := signAuthorization(t, key, validAuthorization(publicKeyToAddress(key.PubKey())))
+
+	req := testRequirements()
+	accepted := *req
+	req.Extra = nil
+
+	if reason := checkAuthorization(payload, req, &accepted, testNow); reason != "" {
+		t.Errorf("expected domain to fall back to accepted requirements, got %q", reason)
+	}
+}
+
+func TestEVMVerifier_LocalVerificationSkipsFacilitator(t *testing.T) {
+	facilitatorCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		facilitatorCalls++
+		json.NewEncoder(w).Encode(FacilitatorVerifyResponse{IsValid: true})
+	}))
+	defer server.Close()
+
+	v := &EVMVerifier{facilitator: NewFacilitatorClient(server.URL), now: func() time.Time { return testNow }}
+	WithLocalVerification()(v)
+
+	key := secp256k1.PrivKeyFromBytes([]byte{1})
+	payer := publicKeyToAddress(key.PubKey())
+
+	// Garbage signature is rejected locally.
+	bad := signAuthorization(t, key, validAuthorization(payer))
+	bad.Authorization.Value = "5000000"
+	result, err := v.Verify(context.Background(), &x402.PaymentPayload{Payload: bad}, testRequirements())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Valid || facilitatorCalls != 0 {
+		t.Errorf("expected local rejection without facilitator call, got valid=%v calls=%d", result.Valid, facilitatorCalls)
+	}
+
+	// A valid payload still goes to the facilitator.
+	good := signAuthorization(t, key, validAuthorization(payer))
+	result, err = v.Verify(context.Background(), &x402.PaymentPayload{Payload: good}, testRequirements())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Valid || facilitatorCalls != 1 {
+		t.Errorf("expected facilitator verification, got valid=%v calls=%d", result.Valid, facilitatorCalls)
+	}
+}
+
+func TestEVMVerifier_SettleAmount(t *testing.T) {
+	var settledAmount string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Requirements x402.PaymentRequirements `json:"requirements"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		settledAmount = req.Requirements.Amount
+		json.NewEncoder(w).Encode(FacilitatorSettleResponse{Success: true, Transaction: "0xtx", Network: "eip155:84532"})
+	}))
+	defer server.Close()
+
+	v := &EVMVerifier{facilitator: NewFacilitatorClient(server.URL), now: func() time.Time { return testNow }}
+
+	key := secp256k1.PrivKeyFromBytes([]byte{1})
+	payload := signAuthorization(t, key, validAuthorization(publicKeyToAddress(key.PubKey())))
+	requirements := testRequirements()
+	requirements.Scheme = x402.SchemeUpto
+
+	result, err := v.SettleAmount(context.Background(), &x402.PaymentPayload{Payload: payload}, requirements, "250")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settledAmount != "250" || result.Amount != "250" {
+		t.Errorf("expected to settle 250, facilitator got %q and result has %q", settledAmount, result.Amount)
+	}
+	if requirements.Amount == "250" {
+		t.Error("requirements must not be modified")
+	}
+}
@@ -13,10 +13,28 @@ import (
 type EVMVerifier struct {
 	facilitator *FacilitatorClient
 	kinds       []x402.SupportedKind
+
+	localVerification bool
+	now               func() time.Time
+}
+
+// Option configures an EVMVerifier.
+type Option func(*EVMVerifier)
+
+// WithLocalVerification enables an offline EIP-3009 pre-check before calling
+// the facilitator. The verifier rebuilds the EIP-712 TransferWithAuthorization
+// digest from the requirement's asset, chain ID, and Extra name/version,
+// recovers the signer, and rejects payloads whose signer is not From, whose
+// To is not PayTo, whose Value is below Amount, or whose validity window does
+// not include the current time. Rejected payloads never reach the facilitator.
+func WithLocalVerification() Option {
+	return func(v *EVMVerifier) {
+		v.localVerification = true
+	}
 }
 
 // NewEVMVerifier creates a new EVM verifier that delegates to a facilitator service.
-func NewEVMVerifier(facilitatorURL string) (*EVMVerifier, error) {
+func NewEVMVerifier(facilitatorURL string, opts ...Option) (*EVMVerifier, error) {
 	client := NewFacilitatorClient(facilitatorURL)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -35,10 +53,16 @@ func NewEVMVerifier(facilitatorURL string) (*EVMVerifier, error) {
 		})
 	}
 
-	return &EVMVerifier{
+	v := &EVMVerifier{
 		facilitator: client,
 		kinds:       kinds,
-	}, nil
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v, nil
 }
 
 // Verify checks if a payment is valid without settling it.
@@ -51,6 +75,17 @@ func (v *EVMVerifier) Verify(ctx context.Context, payload *x402.PaymentPayload,
 		}, nil
 	}
 
+	if v.localVerification {
+		if reason := checkAuthorization(evmPayload, requirements, &payload.Accepted, v.now()); reason != "" {
+			return &x402.VerificationResult{
+				Valid:        false,
+				Reason:       reason,
+				PayerAddress: evmPayload.Authorization.From,
+				Amount:       evmPayload.Authorization.Value,
+			}, nil
+		}
+	}
+
 	verifyReq := &FacilitatorVerifyRequest{
 		Payload:      payload,
 		Requirements: requirements,
@@ -71,10 +106,27 @@ func (v *EVMVerifier) Verify(ctx context.Context, payload *x402.PaymentPayload,
 
 // Settle executes the payment on-chain and returns settlement details.
 func (v *EVMVerifier) Settle(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+	return v.settle(ctx, payload, requirements, "")
+}
+
+// SettleAmount settles an "upto" payment for amount, which the facilitator
+// receives as the requirements' amount. The facilitator must support the
+// upto scheme on the payment's network.
+func (v *EVMVerifier) SettleAmount(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements, amount string) (*x402.SettlementResult, error) {
+	settled := *requirements
+	settled.Amount = amount
+	return v.settle(ctx, payload, &settled, amount)
+}
+
+// settle settles the payment for amount, or the authorized value if amount is empty.
+func (v *EVMVerifier) settle(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements, amount string) (*x402.SettlementResult, error) {
 	evmPayload, err := parseEVMPayload(payload.Payload)
 	if err != nil {
 		return nil, fmt.Errorf("invalid payload: %w", err)
 	}
+	if amount == "" {
+		amount = evmPayload.Authorization.Value
+	}
 
 	settleReq := &FacilitatorSettleRequest{
 		Payload:      payload,
@@ -94,7 +146,7 @@ func (v *EVMVerifier) Settle(ctx context.Context, payload *x402.PaymentPayload,
 		TransactionHash:  settleResp.Transaction,
 		Status:           "success",
 		SettledAt:        time.Now(),
-		Amount:           evmPayload.Authorization.Value,
+		Amount:           amount,
 		PayerAddress:     evmPayload.Authorization.From,
 		RecipientAddress: evmPayload.Authorization.To,
 		Network:          settleResp.Network,
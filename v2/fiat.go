@@ -0,0 +1,275 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateProvider supplies the exchange rates used to quote fiat prices (see
+// PricingRule.FiatPrice).
+type RateProvider interface {
+	// Rate returns the price in US dollars of one whole token.
+	Rate(ctx context.Context, token TokenRequirement) (*big.Rat, error)
+}
+
+// StaticRates is a RateProvider with fixed rates in US dollars, keyed by token
+// symbol and written as decimals: StaticRates{"USDC": "1", "EURC": "1.08"}.
+type StaticRates map[string]string
+
+// Rate returns the rate configured for the token's symbol.
+func (s StaticRates) Rate(ctx context.Context, token TokenRequirement) (*big.Rat, error) {
+	value, ok := s[token.Symbol]
+	if !ok {
+		return nil, fmt.Errorf("no rate for %s", token.Symbol)
+	}
+	rate, ok := parseDecimal(value)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %q for %s", value, token.Symbol)
+	}
+	return rate, nil
+}
+
+// CachedRates wraps a RateProvider and reuses each token's rate for TTL.
+// Run refreshes the cached rates in the background so requests rarely wait
+// for the provider.
+type CachedRates struct {
+	// TTL is how long a rate is used before it is fetched again. Defaults to
+	// one minute.
+	TTL time.Duration
+
+	// OnError is called when a background refresh fails (optional).
+	OnError func(error)
+
+	provider RateProvider
+
+	mu    sync.Mutex
+	rates map[string]*cachedRate
+}
+
+type cachedRate struct {
+	token   TokenRequirement
+	rate    *big.Rat
+	fetched time.Time
+}
+
+// NewCachedRates returns a caching RateProvider backed by provider.
+func NewCachedRates(provider RateProvider) *CachedRates {
+	return &CachedRates{
+		provider: provider,
+		rates:    make(map[string]*cachedRate),
+	}
+}
+
+func (c *CachedRates) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return time.Minute
+	}
+	return c.TTL
+}
+
+// Rate returns the cached rate for token, fetching it if it is missing or
+// older than TTL.
+func (c *CachedRates) Rate(ctx context.Context, token TokenRequirement) (*big.Rat, error) {
+	key := tokenKey(token.Network, token.AssetContract, "")
+
+	c.mu.Lock()
+	cached, ok := c.rates[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < c.ttl() {
+		return cached.rate, nil
+	}
+
+	return c.fetch(ctx, key, token)
+}
+
+func (c *CachedRates) fetch(ctx context.Context, key string, token TokenRequirement) (*big.Rat, error) {
+	rate, err := c.provider.Rate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.rates[key] = &cachedRate{token: token, rate: rate, fetched: time.Now()}
+	c.mu.Unlock()
+	return rate, nil
+}
+
+// Refresh fetches every cached rate again. A rate that fails to refresh
+// keeps its previous value until it expires.
+func (c *CachedRates) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	tokens := make(map[string]TokenRequirement, len(c.rates))
+	for key, cached := range c.rates {
+		tokens[key] = cached.token
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for key, token := range tokens {
+		if _, err := c.fetch(ctx, key, token); err != nil {
+			errs = append(errs, fmt.Errorf("failed to refresh rate for %s: %w", token.Symbol, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run refreshes the cached rates every half TTL until ctx is done.
+func (c *CachedRates) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl() / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil && c.OnError != nil {
+				c.OnError(err)
+			}
+		}
+	}
+}
+
+// Quote returns the rule to enforce for a request. The rule is first priced
+// with its PriceFunc (see PricingRule.Price). If it has a FiatPrice, each
+// token without its own Amount is then quoted through RateProvider. Quotes
+// are remembered for ValidityDuration, and ResolveRequirements accepts a
+// payment for a quoted amount even if the rate has moved since.
+func (c *Config) Quote(ctx context.Context, rule *PricingRule, req *PriceRequest) (*PricingRule, error) {
+	priced, err := rule.Price(ctx, req)
+	if err != nil || priced.FiatPrice == "" {
+		return priced, err
+	}
+
+	if c.RateProvider == nil {
+		return nil, NewPaymentError(ErrCodeInvalidConfig, "fiat prices require a rate provider", nil)
+	}
+	price, err := parseFiatPrice(priced.FiatPrice)
+	if err != nil {
+		return nil, NewPaymentError(ErrCodeInvalidConfig, "pricing failed", err)
+	}
+
+	quoted := *priced
+	quoted.AcceptedTokens = make([]TokenRequirement, 0, len(priced.AcceptedTokens))
+	for _, token := range priced.AcceptedTokens {
+		if token.Amount == "" {
+			rate, err := c.RateProvider.Rate(ctx, token)
+			if err != nil {
+				return nil, NewPaymentError(ErrCodeInvalidConfig, fmt.Sprintf("no exchange rate for %s", token.Symbol), err)
+			}
+			token.Amount = quoteAmount(price, rate, token.TokenDecimals)
+			c.quotes.add(priced.FiatPrice, token, c.ValidityDuration)
+		}
+		quoted.AcceptedTokens = append(quoted.AcceptedTokens, token)
+	}
+	return &quoted, nil
+}
+
+// lockedQuote returns the requirements with the payment's amount if it is a
+// quote issued for the rule's fiat price that has not expired.
+func (c *Config) lockedQuote(rule *PricingRule, payload *PaymentPayload, requirements *PaymentRequirements) *PaymentRequirements {
+	if rule.FiatPrice == "" || payload.Accepted.Amount == requirements.Amount {
+		return requirements
+	}
+	if !c.quotes.valid(rule.FiatPrice, requirements, payload.Accepted.Amount) {
+		return requirements
+	}
+
+	locked := *requirements
+	locked.Amount = payload.Accepted.Amount
+	return &locked
+}
+
+// quoteBook records the fiat quotes issued in payment challenges.
+type quoteBook struct {
+	mu     sync.Mutex
+	quotes map[string]map[string]time.Time // fiat price and token -> amount -> expiry
+}
+
+func newQuoteBook() *quoteBook {
+	return &quoteBook{quotes: make(map[string]map[string]time.Time)}
+}
+
+func (b *quoteBook) add(fiatPrice string, token TokenRequirement, validity time.Duration) {
+	if b == nil {
+		return
+	}
+	key := fiatPrice + "|" + tokenKey(token.Network, token.AssetContract, token.Recipient)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	amounts := b.quotes[key]
+	if amounts == nil {
+		amounts = make(map[string]time.Time)
+		b.quotes[key] = amounts
+	}
+	for amount, expiry := range amounts {
+		if now.After(expiry) {
+			delete(amounts, amount)
+		}
+	}
+	amounts[token.Amount] = now.Add(validity)
+}
+
+func (b *quoteBook) valid(fiatPrice string, requirements *PaymentRequirements, amount string) bool {
+	if b == nil {
+		return false
+	}
+	key := fiatPrice + "|" + tokenKey(requirements.Network, requirements.Asset, requirements.PayTo)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	expiry, ok := b.quotes[key][amount]
+	return ok && time.Now().Before(expiry)
+}
+
+func tokenKey(network, asset, recipient string) string {
+	return network + "|" + strings.ToLower(asset) + "|" + strings.ToLower(recipient)
+}
+
+// quoteAmount converts a fiat price into atomic units of a token at rate,
+// rounding up so a quote is never below the price.
+func quoteAmount(price, rate *big.Rat, decimals int) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	units := new(big.Rat).Quo(price, rate)
+	units.Mul(units, new(big.Rat).SetInt(scale))
+
+	amount, remainder := new(big.Int).QuoRem(units.Num(), units.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		amount.Add(amount, big.NewInt(1))
+	}
+	return amount.String()
+}
+
+// parseFiatPrice parses a US dollar price such as "0.05", "$0.05" or "0.05 USD".
+func parseFiatPrice(price string) (*big.Rat, error) {
+	value := strings.TrimSpace(price)
+	value = strings.TrimPrefix(value, "$")
+	value = strings.TrimSpace(strings.TrimSuffix(value, "USD"))
+
+	amount, ok := parseDecimal(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid fiat price %q", price)
+	}
+	if amount.Sign() <= 0 {
+		return nil, fmt.Errorf("fiat price %q must be greater than zero", price)
+	}
+	return amount, nil
+}
+
+// parseDecimal parses a non-negative decimal number without exponent.
+func parseDecimal(s string) (*big.Rat, bool) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(whole + "." + frac + "0")
+}
@@ -0,0 +1,256 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type rateFunc func(ctx context.Context, token TokenRequirement) (*big.Rat, error)
+
+func (f rateFunc) Rate(ctx context.Context, token TokenRequirement) (*big.Rat, error) {
+	return f(ctx, token)
+}
+
+func TestQuoteAmount(t *testing.T) {
+	tests := []struct {
+		price, rate string
+		decimals    int
+		want        string
+	}{
+		{"0.05", "1", 6, "50000"},
+		{"0.05", "1.08", 6, "46297"}, // 46296.29..., rounded up
+		{"0.05", "1", 18, "50000000000000000"},
+		{"1", "3000", 18, "333333333333334"},
+	}
+
+	for _, tt := range tests {
+		price, _ := parseFiatPrice(tt.price)
+		rate, _ := parseDecimal(tt.rate)
+		if got := quoteAmount(price, rate, tt.decimals); got != tt.want {
+			t.Errorf("quoteAmount(%s, %s, %d) = %s, want %s", tt.price, tt.rate, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestParseFiatPrice(t *testing.T) {
+	for _, price := range []string{"0.05", "$0.05", "0.05 USD", "$.05"} {
+		got, err := parseFiatPrice(price)
+		if err != nil {
+			t.Errorf("parseFiatPrice(%q): unexpected error: %v", price, err)
+			continue
+		}
+		if got.Cmp(big.NewRat(1, 20)) != 0 {
+			t.Errorf("parseFiatPrice(%q) = %s, want 1/20", price, got)
+		}
+	}
+	for _, price := range []string{"", "$0", "five", "1/20", "1e-2", "0.05 EUR"} {
+		if _, err := parseFiatPrice(price); err == nil {
+			t.Errorf("parseFiatPrice(%q): expected error", price)
+		}
+	}
+}
+
+func TestStaticRates(t *testing.T) {
+	rates := StaticRates{"USDC": "1", "EURC": "1.08", "BAD": "-1"}
+
+	rate, err := rates.Rate(context.Background(), TokenRequirement{Symbol: "EURC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate.Cmp(big.NewRat(108, 100)) != 0 {
+		t.Errorf("expected 1.08, got %s", rate)
+	}
+
+	if _, err := rates.Rate(context.Background(), TokenRequirement{Symbol: "DAI"}); err == nil {
+		t.Error("expected error for a missing rate")
+	}
+	if _, err := rates.Rate(context.Background(), TokenRequirement{Symbol: "BAD"}); err == nil {
+		t.Error("expected error for an invalid rate")
+	}
+}
+
+func TestCachedRates(t *testing.T) {
+	var calls int32
+	fail := false
+	cached := NewCachedRates(rateFunc(func(ctx context.Context, token TokenRequirement) (*big.Rat, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if fail {
+			return nil, errors.New("oracle down")
+		}
+		return big.NewRat(int64(n), 1), nil
+	}))
+	token := TokenRequirement{Network: "eip155:84532", AssetContract: "0xToken", Symbol: "USDC"}
+
+	for i := 0; i < 3; i++ {
+		rate, err := cached.Rate(context.Background(), token)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rate.Cmp(big.NewRat(1, 1)) != 0 {
+			t.Errorf("expected cached rate 1, got %s", rate)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 provider call, got %d", calls)
+	}
+
+	if err := cached.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected refresh error: %v", err)
+	}
+	if rate, _ := cached.Rate(context.Background(), token); rate.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("expected refreshed rate 2, got %s", rate)
+	}
+
+	fail = true
+	if err := cached.Refresh(context.Background()); err == nil {
+		t.Error("expected refresh error")
+	}
+	if rate, _ := cached.Rate(context.Background(), token); rate.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("expected previous rate to be kept, got %s", rate)
+	}
+}
+
+func fiatConfig(rate *atomic.Value, verifier ChainVerifier) Config {
+	return Config{
+		Verifier:              verifier,
+		StrictPaymentMatching: true,
+		RateProvider: rateFunc(func(ctx context.Context, token TokenRequirement) (*big.Rat, error) {
+			return rate.Load().(*big.Rat), nil
+		}),
+		EndpointPricing: map[string]PricingRule{
+			"/v1/paid": {
+				FiatPrice: "$1",
+				AcceptedTokens: []TokenRequirement{{
+					Network:       "eip155:84532",
+					AssetContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+					Symbol:        "USDC",
+					Recipient:     "0xRecipient",
+					TokenDecimals: 6,
+				}},
+			},
+		},
+	}
+}
+
+func TestPaymentMiddleware_FiatQuoteLocked(t *testing.T) {
+	var rate atomic.Value
+	rate.Store(big.NewRat(1, 1))
+
+	var verifiedAmount string
+	verifier := &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			verifiedAmount = requirements.Amount
+			return &VerificationResult{Valid: true, PayerAddress: "0xtest", Amount: requirements.Amount}, nil
+		},
+	}
+	handler := PaymentMiddleware(fiatConfig(&rate, verifier))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/paid", nil))
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	var body PaymentRequiredResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode 402 body: %v", err)
+	}
+	if body.Accepts[0].Amount != "1000000" {
+		t.Fatalf("expected quoted amount 1000000, got %s", body.Accepts[0].Amount)
+	}
+
+	// The token appreciates after the quote; the quoted amount still pays.
+	rate.Store(big.NewRat(5, 4))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected locked quote to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if verifiedAmount != "1000000" {
+		t.Errorf("expected verification against the quoted amount, got %s", verifiedAmount)
+	}
+}
+
+func TestAtomicConfig_KeepsFiatQuotes(t *testing.T) {
+	var rate atomic.Value
+	rate.Store(big.NewRat(1, 1))
+
+	source, err := NewAtomicConfig(fiatConfig(&rate, &MockVerifier{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := PaymentMiddlewareFromSource(source)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/paid", nil))
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+
+	// A reload between the quote and the payment keeps the quote.
+	rate.Store(big.NewRat(5, 4))
+	if err := source.Store(fiatConfig(&rate, &MockVerifier{})); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected quote to survive the reload, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPaymentMiddleware_FiatRequiresQuote(t *testing.T) {
+	var rate atomic.Value
+	rate.Store(big.NewRat(5, 4))
+
+	handler := PaymentMiddleware(fiatConfig(&rate, &MockVerifier{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	// 1000000 was never quoted: at 1.25 the price is 800000.
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "800000") {
+		t.Errorf("expected a fresh quote of 800000, got %s", w.Body.String())
+	}
+}
+
+func TestConfigValidate_FiatPrice(t *testing.T) {
+	var rate atomic.Value
+	rate.Store(big.NewRat(1, 1))
+
+	cfg := fiatConfig(&rate, &MockVerifier{})
+	cfg.RateProvider = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "rate provider") {
+		t.Errorf("expected missing rate provider error, got %v", err)
+	}
+
+	cfg = fiatConfig(&rate, &MockVerifier{})
+	cfg.EndpointPricing["/v1/paid"].AcceptedTokens[0].TokenDecimals = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "token decimals are required") {
+		t.Errorf("expected missing decimals error, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientConfig configures the x402 client interceptors.
+type ClientConfig struct {
+	// Signer creates payment payloads. Required.
+	Signer x402.Signer
+
+	// Select chooses a payment option from the server's challenge.
+	// Defaults to x402.SelectFirst.
+	Select x402.RequirementsSelector
+}
+
+// PaymentResponseCallOption is a grpc.CallOption that captures the decoded
+// payment-response trailer of a paid call.
+type PaymentResponseCallOption struct {
+	grpc.EmptyCallOption
+	Response **x402.PaymentResponse
+}
+
+// WithPaymentResponse returns a CallOption that stores the settlement receipt
+// of a paid call in resp. resp is left unchanged if the call was not paid.
+// Requires UnaryClientInterceptor or StreamClientInterceptor.
+func WithPaymentResponse(resp **x402.PaymentResponse) grpc.CallOption {
+	return PaymentResponseCallOption{Response: resp}
+}
+
+// UnaryClientInterceptor creates a gRPC unary client interceptor that pays for
+// x402-protected methods. When a call fails with a RESOURCE_EXHAUSTED payment
+// challenge, it signs one of the offered requirements and retries once with
+// payment-signature metadata.
+func UnaryClientInterceptor(cfg ClientConfig) grpc.UnaryClientInterceptor {
+	if cfg.Signer == nil {
+		panic("invalid x402 client config: signer is required")
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var trailer metadata.MD
+		opts = append(opts, grpc.Trailer(&trailer))
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		paymentReq, ok := PaymentRequiredFromError(err)
+		if !ok {
+			return err
+		}
+
+		paidCtx, payErr := cfg.pay(ctx, paymentReq)
+		if payErr != nil {
+			return payErr
+		}
+
+		if err := invoker(paidCtx, method, req, reply, cc, opts...); err != nil {
+			return err
+		}
+
+		capturePaymentResponse(trailer, opts)
+		return nil
+	}
+}
+
+// StreamClientInterceptor creates a gRPC stream client interceptor that pays
+// for x402-protected streaming methods. The payment challenge arrives on the
+// first RecvMsg; the interceptor then opens a new paid stream and replays the
+// messages sent so far. This is safe because the server rejects unpaid
+// streams before the handler runs.
+func StreamClientInterceptor(cfg ClientConfig) grpc.StreamClientInterceptor {
+	if cfg.Signer == nil {
+		panic("invalid x402 client config: signer is required")
+	}
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		unpaidCtx, cancel := context.WithCancel(ctx)
+		stream, err := streamer(unpaidCtx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		return &paymentClientStream{
+			ClientStream: stream,
+			cancelUnpaid: cancel,
+			cfg:          cfg,
+			ctx:          ctx,
+			desc:         desc,
+			cc:           cc,
+			method:       method,
+			streamer:     streamer,
+			opts:         opts,
+		}, nil
+	}
+}
+
+// paymentClientStream retries a stream with payment when the server answers
+// the first RecvMsg with a payment challenge.
+type paymentClientStream struct {
+	grpc.ClientStream
+
+	// cancelUnpaid cancels the first, unpaid stream.
+	cancelUnpaid context.CancelFunc
+
+	cfg      ClientConfig
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+
+	mu         sync.Mutex
+	sent       []interface{}
+	closedSend bool
+	received   bool
+	paid       bool
+}
+
+func (s *paymentClientStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	if !s.received {
+		s.sent = append(s.sent, m)
+	}
+	stream := s.ClientStream
+	s.mu.Unlock()
+	return stream.SendMsg(m)
+}
+
+func (s *paymentClientStream) CloseSend() error {
+	s.mu.Lock()
+	s.closedSend = true
+	stream := s.ClientStream
+	s.mu.Unlock()
+	return stream.CloseSend()
+}
+
+func (s *paymentClientStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	stream := s.ClientStream
+	s.mu.Unlock()
+
+	err := stream.RecvMsg(m)
+
+	s.mu.Lock()
+	if err == io.EOF && s.paid {
+		capturePaymentResponse(stream.Trailer(), s.opts)
+	}
+
+	if s.received || s.paid {
+		s.mu.Unlock()
+		return err
+	}
+
+	paymentReq, ok := PaymentRequiredFromError(err)
+	if !ok {
+		if err == nil {
+			// The server accepted the stream, so there is nothing to replay.
+			s.received = true
+			s.sent = nil
+		} else {
+			s.cancelUnpaid()
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	// The unpaid stream is over; retry it with payment. The lock is held
+	// while the sent messages are replayed, so concurrent sends follow them
+	// on the paid stream, but not while waiting for its reply: on a
+	// bidirectional stream the server may first wait for another message.
+	s.cancelUnpaid()
+	paid, err := s.payStream(paymentReq)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = paid.RecvMsg(m)
+	if err == io.EOF {
+		capturePaymentResponse(paid.Trailer(), s.opts)
+	}
+	return err
+}
+
+// payStream opens a paid stream for the challenge, replays the messages sent
+// so far and makes it the current stream. s.mu must be held.
+func (s *paymentClientStream) payStream(paymentReq *x402.PaymentRequiredResponse) (grpc.ClientStream, error) {
+	paidCtx, err := s.cfg.pay(s.ctx, paymentReq)
+	if err != nil {
+		return nil, err
+	}
+
+	paid, err := s.streamer(paidCtx, s.desc, s.cc, s.method, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range s.sent {
+		if err := paid.SendMsg(msg); err != nil {
+			return nil, err
+		}
+	}
+	if s.closedSend {
+		if err := paid.CloseSend(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.ClientStream = paid
+	s.paid = true
+	s.received = true
+	s.sent = nil
+	return paid, nil
+}
+
+// pay signs a payment for the challenge and returns ctx with payment-signature metadata.
+func (cfg ClientConfig) pay(ctx context.Context, paymentReq *x402.PaymentRequiredResponse) (context.Context, error) {
+	selectFn := cfg.Select
+	if selectFn == nil {
+		selectFn = x402.SelectFirst
+	}
+
+	requirements, err := selectFn(paymentReq.Accepts)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, fmt.Sprintf("failed to select payment option: %v", err))
+	}
+
+	payload, err := x402.SignPayment(ctx, cfg.Signer, requirements)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	encoded, err := EncodePaymentPayload(payload)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, MetadataKeyPaymentSignature, encoded), nil
+}
+
+// PaymentRequiredFromError extracts payment requirements from a RESOURCE_EXHAUSTED
+// payment challenge returned by UnaryServerInterceptor or StreamServerInterceptor.
+func PaymentRequiredFromError(err error) (*x402.PaymentRequiredResponse, bool) {
+	if err == nil {
+		return nil, false
+	}
+
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return nil, false
+	}
+
+	return x402.PaymentRequiredFromStatus(st)
+}
+
+// capturePaymentResponse decodes the payment-response trailer into any
+// PaymentResponseCallOption in opts.
+func capturePaymentResponse(trailer metadata.MD, opts []grpc.CallOption) {
+	values := trailer.Get(MetadataKeyPaymentResponse)
+	if len(values) == 0 {
+		values = trailer.Get(MetadataKeyLegacyPaymentResponse)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	response, err := DecodePaymentResponse(values[0])
+	if err != nil {
+		return
+	}
+
+	for _, opt := range opts {
+		if o, ok := opt.(PaymentResponseCallOption); ok && o.Response != nil {
+			*o.Response = response
+		}
+	}
+}
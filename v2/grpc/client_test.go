@@ -0,0 +1,252 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mockSigner struct {
+	calls int
+	err   error
+}
+
+func (s *mockSigner) Sign(ctx context.Context, requirements *x402.PaymentRequirements) (*x402.PaymentPayload, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &x402.PaymentPayload{
+		Payload: map[string]interface{}{"signature": "0xsig"},
+	}, nil
+}
+
+// challenge returns the payment-required error the server interceptors produce.
+func challenge(t *testing.T) error {
+	t.Helper()
+	cfg := testConfig(&mockVerifier{})
+	rule, _ := cfg.MatchMethod(testMethod)
+	return sendPaymentRequired(context.Background(), rule, testMethod, &cfg)
+}
+
+func paymentTrailer(t *testing.T) metadata.MD {
+	t.Helper()
+	encoded, err := EncodePaymentResponse(&x402.PaymentResponse{Success: true, Transaction: "0xtxhash"})
+	if err != nil {
+		t.Fatalf("failed to encode payment response: %v", err)
+	}
+	return metadata.Pairs(MetadataKeyPaymentResponse, encoded)
+}
+
+func hasPaymentSignature(ctx context.Context) bool {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(MetadataKeyPaymentSignature)) > 0
+}
+
+func TestUnaryClientInterceptor_PaysChallenge(t *testing.T) {
+	signer := &mockSigner{}
+	interceptor := UnaryClientInterceptor(ClientConfig{Signer: signer})
+
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if !hasPaymentSignature(ctx) {
+			return challenge(t)
+		}
+
+		md, _ := metadata.FromOutgoingContext(ctx)
+		payload, err := DecodePaymentPayload(md.Get(MetadataKeyPaymentSignature)[0])
+		if err != nil {
+			t.Fatalf("invalid payment-signature metadata: %v", err)
+		}
+		if payload.Accepted.Amount != "1000000" || payload.X402Version != 2 {
+			t.Errorf("expected payload for the challenged requirements, got %+v", payload)
+		}
+
+		for _, opt := range opts {
+			if o, ok := opt.(grpc.TrailerCallOption); ok {
+				*o.TrailerAddr = paymentTrailer(t)
+			}
+		}
+		return nil
+	}
+
+	var receipt *x402.PaymentResponse
+	err := interceptor(context.Background(), testMethod, "req", nil, nil, invoker, WithPaymentResponse(&receipt))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 || signer.calls != 1 {
+		t.Errorf("expected one retry and one signature, got %d calls and %d signatures", calls, signer.calls)
+	}
+	if receipt == nil || receipt.Transaction != "0xtxhash" {
+		t.Errorf("expected payment response to be captured, got %+v", receipt)
+	}
+}
+
+func TestUnaryClientInterceptor_PassesThroughOtherErrors(t *testing.T) {
+	signer := &mockSigner{}
+	interceptor := UnaryClientInterceptor(ClientConfig{Signer: signer})
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.ResourceExhausted, "rate limited")
+	}
+
+	err := interceptor(context.Background(), testMethod, "req", nil, nil, invoker)
+	if status.Code(err) != codes.ResourceExhausted || signer.calls != 0 {
+		t.Errorf("expected plain quota error to pass through unpaid, got %v", err)
+	}
+}
+
+func TestUnaryClientInterceptor_SignerError(t *testing.T) {
+	interceptor := UnaryClientInterceptor(ClientConfig{Signer: &mockSigner{err: errors.New("wallet locked")}})
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return challenge(t)
+	}
+
+	err := interceptor(context.Background(), testMethod, "req", nil, nil, invoker)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+// fakeClientStream is a ClientStream that rejects unpaid streams on RecvMsg.
+type fakeClientStream struct {
+	grpc.ClientStream
+	t      *testing.T
+	paid   bool
+	sent   []interface{}
+	closed bool
+	recvd  int
+
+	// next, if set, makes the paid stream's RecvMsg wait for a "next"
+	// message, like a bidirectional server that reads before it replies.
+	next chan struct{}
+}
+
+func (s *fakeClientStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	if s.next != nil && m == "next" {
+		close(s.next)
+	}
+	return nil
+}
+
+func (s *fakeClientStream) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	if !s.paid {
+		return challenge(s.t)
+	}
+	if s.next != nil {
+		<-s.next
+	}
+	if s.recvd > 0 {
+		return io.EOF
+	}
+	s.recvd++
+	return nil
+}
+
+func (s *fakeClientStream) Trailer() metadata.MD {
+	return paymentTrailer(s.t)
+}
+
+func TestStreamClientInterceptor_ReplaysOnPaidStream(t *testing.T) {
+	signer := &mockSigner{}
+	interceptor := StreamClientInterceptor(ClientConfig{Signer: signer})
+
+	var streams []*fakeClientStream
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		s := &fakeClientStream{t: t, paid: hasPaymentSignature(ctx)}
+		streams = append(streams, s)
+		return s, nil
+	}
+
+	var receipt *x402.PaymentResponse
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, testMethod, streamer, WithPaymentResponse(&receipt))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stream.SendMsg("req")
+	stream.CloseSend()
+
+	if err := stream.RecvMsg(nil); err != nil {
+		t.Fatalf("expected first message after payment, got %v", err)
+	}
+	if err := stream.RecvMsg(nil); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	if len(streams) != 2 {
+		t.Fatalf("expected a retried stream, got %d streams", len(streams))
+	}
+	paid := streams[1]
+	if len(paid.sent) != 1 || paid.sent[0] != "req" || !paid.closed {
+		t.Errorf("expected sent messages and CloseSend to be replayed, got %v closed=%v", paid.sent, paid.closed)
+	}
+	if signer.calls != 1 {
+		t.Errorf("expected one signature, got %d", signer.calls)
+	}
+	if receipt == nil || receipt.Transaction != "0xtxhash" {
+		t.Errorf("expected payment response to be captured, got %+v", receipt)
+	}
+}
+
+func TestStreamClientInterceptor_BidiSendWhilePaidRecv(t *testing.T) {
+	interceptor := StreamClientInterceptor(ClientConfig{Signer: &mockSigner{}})
+
+	var unpaidCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !hasPaymentSignature(ctx) {
+			unpaidCtx = ctx
+		}
+		return &fakeClientStream{t: t, paid: hasPaymentSignature(ctx), next: make(chan struct{})}, nil
+	}
+
+	desc := &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}
+	stream, err := interceptor(context.Background(), desc, nil, testMethod, streamer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stream.SendMsg("first")
+
+	received := make(chan error, 1)
+	go func() { received <- stream.RecvMsg(nil) }()
+
+	// The unpaid stream is cancelled once the challenge arrives. "next" then
+	// goes to the paid stream, which only replies once it gets it, so
+	// sending must not wait for RecvMsg.
+	select {
+	case <-unpaidCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the unpaid stream to be cancelled")
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- stream.SendMsg("next") }()
+
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Fatalf("expected a reply on the paid stream, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RecvMsg and SendMsg deadlocked on the paid stream")
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("unexpected send error: %v", err)
+	}
+}
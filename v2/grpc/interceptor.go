@@ -13,26 +13,55 @@ import (
 
 // UnaryServerInterceptor creates a gRPC unary server interceptor that enforces x402 payments.
 // Detects V2 metadata (payment-signature) first, falls back to V1 (x402-payment).
+// In SettleOnSuccess mode settlement happens only after the handler returns without error.
 func UnaryServerInterceptor(cfg x402.Config) grpc.UnaryServerInterceptor {
-	if err := cfg.Validate(); err != nil {
+	source, err := x402.NewAtomicConfig(cfg)
+	if err != nil {
 		panic(fmt.Sprintf("invalid x402 config: %v", err))
 	}
 
+	return UnaryServerInterceptorFromSource(source)
+}
+
+// UnaryServerInterceptorFromSource is like UnaryServerInterceptor but reads the configuration from
+// source on every call, so pricing changes take effect without a restart.
+func UnaryServerInterceptorFromSource(source x402.ConfigSource) grpc.UnaryServerInterceptor {
+	if source == nil || source.Config() == nil {
+		panic("invalid x402 config: config source has no configuration")
+	}
+
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		cfg := source.Config()
+
 		rule, requiresPayment := cfg.MatchMethod(info.FullMethod)
 		if !requiresPayment {
 			return handler(ctx, req)
 		}
 
+		if paymentCtx, ok := accessTokenPayment(ctx, cfg, info.FullMethod); ok {
+			return handler(context.WithValue(ctx, x402.PaymentContextKey, paymentCtx), req)
+		}
+
+		rule, err := cfg.Quote(ctx, rule, &x402.PriceRequest{FullMethod: info.FullMethod, Message: req})
+		if err != nil {
+			return nil, x402.PaymentErrorStatus(err).Err()
+		}
+		if len(rule.AcceptedTokens) == 0 {
+			return handler(ctx, req)
+		}
+
 		md, ok := metadata.FromIncomingContext(ctx)
 		if !ok {
-			return nil, sendPaymentRequired(rule, info.FullMethod, &cfg)
+			return nil, sendPaymentRequired(ctx, rule, info.FullMethod, cfg)
 		}
 
 		// Extract payment (V2 first, V1 fallback).
 		payload, isV2, err := ExtractPaymentFromMetadata(md)
 		if err != nil {
-			return nil, sendPaymentRequired(rule, info.FullMethod, &cfg)
+			if x402.IsPaymentError(err) {
+				return nil, x402.PaymentErrorStatus(err).Err()
+			}
+			return nil, sendPaymentRequired(ctx, rule, info.FullMethod, cfg)
 		}
 
 		// Build requirements from the matched pricing rule.
@@ -44,27 +73,53 @@ func UnaryServerInterceptor(cfg x402.Config) grpc.UnaryServerInterceptor {
 
 		// Match the client's chosen token against accepted tokens.
 		var tokenSymbol string
-		if payload != nil {
-			if matched, symbol := x402.MatchClientToken(rule, payload); matched != nil {
-				requirements = matched
-				tokenSymbol = symbol
+		if isV2 {
+			requirements, tokenSymbol, err = cfg.ResolveRequirements(rule, payload, requirements)
+			if err != nil {
+				return nil, sendPaymentRejected(ctx, rule, info.FullMethod, cfg, err)
 			}
 		}
 
+		// A retry that resends the payment of a call that was already
+		// settled replays its result. Otherwise the key is held while this
+		// call is in flight, so concurrent retries are not charged twice.
+		idempotencyKey := idempotencyKey(ctx)
+		paymentKey := x402.PaymentNonceKey(payload)
+		record, finishKey, err := cfg.ReserveIdempotencyKey(ctx, idempotencyKey, payload, info.FullMethod)
+		if err != nil {
+			return nil, x402.PaymentErrorStatus(err).Err()
+		}
+		defer finishKey()
+		if record != nil {
+			return replayCall(ctx, req, handler, record, isV2)
+		}
+
+		// Reserve the payment nonce so concurrent duplicates never reach the verifier.
+		finishNonce, err := cfg.ReservePaymentNonce(ctx, payload)
+		if err != nil {
+			return nil, nonceError(err)
+		}
+		settled := false
+		defer func() { finishNonce(settled) }()
+
 		// Verify the payment.
 		verifyResult, err := cfg.Verifier.Verify(ctx, payload, requirements)
 		if err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("payment verification error: %v", err))
+			return nil, paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeVerificationFailed, "payment verification error")
 		}
 
 		if !verifyResult.Valid {
-			return nil, sendPaymentRequired(rule, info.FullMethod, &cfg)
+			return nil, sendPaymentRejected(ctx, rule, info.FullMethod, cfg, invalidPayment(verifyResult))
 		}
 
-		// Settle the payment on-chain.
-		settlementResult, err := cfg.Verifier.Settle(ctx, payload, requirements)
+		// A retry with a fresh payment from the same payer replays without
+		// settling it.
+		record, err = cfg.IdempotentReplay(ctx, idempotencyKey, verifyResult.PayerAddress, info.FullMethod)
 		if err != nil {
-			return nil, status.Error(codes.Unavailable, fmt.Sprintf("payment settlement failed: %v", err))
+			return nil, x402.PaymentErrorStatus(err).Err()
+		}
+		if record != nil {
+			return replayCall(ctx, req, handler, record, isV2)
 		}
 
 		// Resolve token symbol from rule match or verifier.
@@ -73,55 +128,230 @@ func UnaryServerInterceptor(cfg x402.Config) grpc.UnaryServerInterceptor {
 		}
 
 		// Create payment context.
-		paymentCtx := &x402.PaymentContext{
-			Verified:        true,
-			PayerAddress:    verifyResult.PayerAddress,
-			Amount:          verifyResult.Amount,
-			TokenSymbol:     tokenSymbol,
-			Network:         requirements.Network,
-			TransactionHash: settlementResult.TransactionHash,
-			SettledAt:       settlementResult.SettledAt,
+		paymentCtx := x402.NewPaymentContext(verifyResult, requirements)
+		paymentCtx.TokenSymbol = tokenSymbol
+		paymentCtx.Resource = info.FullMethod
+		paymentCtx.RequestID = requestID(ctx)
+
+		// "upto" payments settle what the handler used, so they always settle after it.
+		if cfg.SettlementMode == x402.SettleOnSuccess || requirements.Scheme == x402.SchemeUpto {
+			// Only charge the client if the handler succeeds.
+			resp, err := handler(context.WithValue(ctx, x402.PaymentContextKey, paymentCtx), req)
+			if err != nil {
+				return nil, err
+			}
+
+			settlementResult, err := cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+			if err != nil {
+				return nil, paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeSettlementFailed, "payment settlement failed")
+			}
+			if settlementResult == nil {
+				// The "upto" payment was not used, so nothing was charged.
+				return resp, nil
+			}
+
+			settled = true
+			paymentCtx.TransactionHash = settlementResult.TransactionHash
+			paymentCtx.SettledAt = settlementResult.SettledAt
+
+			rememberCall(ctx, cfg, idempotencyKey, paymentKey, paymentCtx, settlementResult, resp)
+			setPaymentResponseTrailer(ctx, settlementResult, isV2)
+			return resp, nil
+		}
+
+		// Settle the payment on-chain.
+		settlementResult, err := cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+		if err != nil {
+			return nil, paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeSettlementFailed, "payment settlement failed")
 		}
 
+		settled = true
+		paymentCtx.TransactionHash = settlementResult.TransactionHash
+		paymentCtx.SettledAt = settlementResult.SettledAt
+
 		ctx = context.WithValue(ctx, x402.PaymentContextKey, paymentCtx)
 
+		// Remember the settlement before the handler runs, so a retry after a
+		// dropped connection is not charged again.
+		rememberCall(ctx, cfg, idempotencyKey, paymentKey, paymentCtx, settlementResult, nil)
+
 		resp, err := handler(ctx, req)
 		if err != nil {
 			return nil, err
 		}
+		if cfg.CacheIdempotentResponses {
+			rememberCall(ctx, cfg, idempotencyKey, paymentKey, paymentCtx, settlementResult, resp)
+		}
 
 		// Set response metadata (version-aware).
-		paymentResponse := x402.PaymentResponse{
-			Success:     true,
-			Transaction: settlementResult.TransactionHash,
-			Network:     settlementResult.Network,
-			Payer:       settlementResult.PayerAddress,
-		}
-
-		encoded, encErr := EncodePaymentResponse(&paymentResponse)
-		if encErr == nil {
-			if isV2 {
-				trailer := metadata.Pairs(MetadataKeyPaymentResponse, encoded)
-				grpc.SetTrailer(ctx, trailer)
-			} else {
-				trailer := metadata.Pairs(MetadataKeyLegacyPaymentResponse, encoded)
-				grpc.SetTrailer(ctx, trailer)
-			}
-		}
+		setPaymentResponseTrailer(ctx, settlementResult, isV2)
 
 		return resp, nil
 	}
 }
 
-func sendPaymentRequired(rule *x402.PricingRule, fullMethod string, cfg *x402.Config) error {
-	accepts := BuildPaymentRequirements(rule, fullMethod, cfg.ValidityDuration)
+// paymentResponseTrailer builds the payment-response (V2) or x402-payment-response (V1)
+// trailer for a successful settlement.
+func paymentResponseTrailer(settlementResult *x402.SettlementResult, isV2 bool) (metadata.MD, bool) {
+	return receiptTrailer(settlementReceipt(settlementResult), isV2)
+}
+
+// settlementReceipt builds the payment response for a successful settlement.
+func settlementReceipt(settlementResult *x402.SettlementResult) *x402.PaymentResponse {
+	return &x402.PaymentResponse{
+		Success:     true,
+		Transaction: settlementResult.TransactionHash,
+		Network:     settlementResult.Network,
+		Payer:       settlementResult.PayerAddress,
+		Balance:     settlementResult.Balance,
+		AccessToken: settlementResult.AccessToken,
+		Pending:     settlementResult.Pending,
+	}
+}
+
+// receiptTrailer encodes a payment response as the payment-response (V2) or
+// x402-payment-response (V1) trailer.
+func receiptTrailer(receipt *x402.PaymentResponse, isV2 bool) (metadata.MD, bool) {
+	encoded, err := EncodePaymentResponse(receipt)
+	if err != nil {
+		return nil, false
+	}
+	if isV2 {
+		return metadata.Pairs(MetadataKeyPaymentResponse, encoded), true
+	}
+	return metadata.Pairs(MetadataKeyLegacyPaymentResponse, encoded), true
+}
+
+// requestID returns the call's x-request-id metadata, if any.
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(MetadataKeyRequestID); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// idempotencyKey returns the call's idempotency-key metadata, if any.
+func idempotencyKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(MetadataKeyIdempotencyKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// rememberCall stores a call settled with the payment keyed paymentKey under
+// its idempotency key, with its reply when the config caches responses.
+func rememberCall(ctx context.Context, cfg *x402.Config, key, paymentKey string, paymentCtx *x402.PaymentContext, settlementResult *x402.SettlementResult, reply interface{}) {
+	record := &x402.IdempotencyRecord{PaymentKey: paymentKey, Payment: paymentCtx, Receipt: settlementReceipt(settlementResult)}
+	if cfg.CacheIdempotentResponses {
+		record.Reply = reply
+	}
+	cfg.RememberIdempotent(ctx, key, record)
+}
 
-	encoded, err := EncodePaymentRequirements(accepts)
+// replayCall answers a retried unary call with the result of the settled
+// call in record.
+func replayCall(ctx context.Context, req interface{}, handler grpc.UnaryHandler, record *x402.IdempotencyRecord, isV2 bool) (interface{}, error) {
+	if trailer, ok := receiptTrailer(record.Receipt, isV2); ok {
+		grpc.SetTrailer(ctx, trailer)
+	}
+	if record.Reply != nil {
+		return record.Reply, nil
+	}
+	return handler(context.WithValue(ctx, x402.PaymentContextKey, record.Payment), req)
+}
+
+// accessTokenPayment admits a call that presents a valid access token
+// covering fullMethod, returning the PaymentContext of the payment that
+// bought it.
+func accessTokenPayment(ctx context.Context, cfg *x402.Config, fullMethod string) (*x402.PaymentContext, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	values := md.Get(MetadataKeyAccessToken)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	paymentCtx, err := cfg.VerifyAccessToken(values[0], "", fullMethod)
+	return paymentCtx, err == nil
+}
+
+func setPaymentResponseTrailer(ctx context.Context, settlementResult *x402.SettlementResult, isV2 bool) {
+	if trailer, ok := paymentResponseTrailer(settlementResult, isV2); ok {
+		grpc.SetTrailer(ctx, trailer)
+	}
+}
+
+// nonceError converts a ReservePaymentNonce failure to a gRPC status.
+func nonceError(err error) error {
+	if x402.IsPaymentError(err) {
+		return x402.PaymentErrorStatus(err).Err()
+	}
+	return status.Error(codes.Internal, fmt.Sprintf("payment nonce error: %v", err))
+}
+
+// paymentFailure converts a failed verification or settlement to a gRPC status.
+// Errors other than PaymentErrors are classified as code. Failures the client
+// can fix by paying again come with the payment challenge.
+func paymentFailure(ctx context.Context, rule *x402.PricingRule, fullMethod string, cfg *x402.Config, err error, code, message string) error {
+	if !x402.IsPaymentError(err) {
+		err = x402.NewPaymentError(code, message, err)
+	}
+	if x402.GRPCCode(x402.GetPaymentErrorCode(err)) == codes.ResourceExhausted {
+		return sendPaymentRejected(ctx, rule, fullMethod, cfg, err)
+	}
+	return x402.PaymentErrorStatus(err).Err()
+}
+
+// invalidPayment describes a payment the verifier rejected.
+func invalidPayment(result *x402.VerificationResult) error {
+	reason := result.Reason
+	if reason == "" {
+		reason = "payment is invalid"
+	}
+	return x402.NewPaymentError(x402.ErrCodePaymentRejected, reason, nil)
+}
+
+func sendPaymentRequired(ctx context.Context, rule *x402.PricingRule, fullMethod string, cfg *x402.Config) error {
+	return paymentRequiredError(ctx, rule, fullMethod, cfg, x402.ErrCodePaymentRequired, "payment required")
+}
+
+// sendPaymentRejected returns the payment challenge with the rejection reason in its error field.
+func sendPaymentRejected(ctx context.Context, rule *x402.PricingRule, fullMethod string, cfg *x402.Config, reason error) error {
+	code := x402.GetPaymentErrorCode(reason)
+	if code == "" {
+		code = x402.ErrCodePaymentRejected
+	}
+	return paymentRequiredError(ctx, rule, fullMethod, cfg, code, reason.Error())
+}
+
+// paymentRequiredError builds the RESOURCE_EXHAUSTED payment challenge, with the
+// requirements and an ErrorInfo for code as status details, and sets the
+// requirements in the payment-required trailer.
+func paymentRequiredError(ctx context.Context, rule *x402.PricingRule, fullMethod string, cfg *x402.Config, code, message string) error {
+	response := x402.PaymentRequiredResponse{
+		X402Version: 2,
+		Error:       message,
+		Accepts:     BuildPaymentRequirements(rule, fullMethod, cfg.ValidityDuration),
+	}
+
+	st, err := x402.PaymentRequiredStatus(&response, cfg.LegacyPaymentRequiredMessage)
+	if err == nil {
+		st, err = st.WithDetails(x402.PaymentErrorInfo(code))
+	}
 	if err != nil {
 		return status.Error(codes.Internal, fmt.Sprintf("failed to encode payment requirements: %v", err))
 	}
 
-	return status.Error(codes.ResourceExhausted, encoded)
+	if encoded, err := encodePaymentRequired(response); err == nil {
+		// Fails only outside a gRPC server (e.g. in tests); the status detail still carries the requirements.
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(MetadataKeyPaymentRequired, encoded))
+	}
+
+	return st.Err()
 }
 
 // GetPaymentFromContext extracts payment information from the gRPC context.
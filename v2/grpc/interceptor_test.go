@@ -0,0 +1,743 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// mockVerifier is a mock implementation of x402.ChainVerifier for testing.
+type mockVerifier struct {
+	verifyFunc func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.VerificationResult, error)
+	settleFunc func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error)
+}
+
+func (m *mockVerifier) Verify(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.VerificationResult, error) {
+	if m.verifyFunc != nil {
+		return m.verifyFunc(ctx, payload, requirements)
+	}
+	return &x402.VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: "1000000"}, nil
+}
+
+func (m *mockVerifier) Settle(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+	if m.settleFunc != nil {
+		return m.settleFunc(ctx, payload, requirements)
+	}
+	return &x402.SettlementResult{TransactionHash: "0xtxhash", Status: "success", Network: "eip155:84532"}, nil
+}
+
+func (m *mockVerifier) SupportedKinds() []x402.SupportedKind {
+	return []x402.SupportedKind{{Scheme: "exact", Network: "eip155:84532"}}
+}
+
+const testMethod = "/test.v1.Service/Paid"
+
+func testConfig(verifier x402.ChainVerifier) x402.Config {
+	return x402.Config{
+		Verifier: verifier,
+		MethodPricing: map[string]x402.PricingRule{
+			testMethod: {
+				AcceptedTokens: []x402.TokenRequirement{
+					{
+						Network:       "eip155:84532",
+						Symbol:        "USDC",
+						AssetContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+						Recipient:     "0xRecipient",
+						Amount:        "1000000",
+					},
+				},
+			},
+		},
+	}
+}
+
+func paidContext(t *testing.T) context.Context {
+	t.Helper()
+	return paidContextWithNonce(t, "0xnonce")
+}
+
+// paidContextWithNonce is like paidContext with another authorization nonce.
+func paidContextWithNonce(t *testing.T, nonce string) context.Context {
+	t.Helper()
+	encoded, err := EncodePaymentPayload(&x402.PaymentPayload{
+		X402Version: 2,
+		Accepted: x402.PaymentRequirements{
+			Scheme:  "exact",
+			Network: "eip155:84532",
+			Amount:  "1000000",
+			Asset:   "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+			PayTo:   "0xRecipient",
+		},
+		Payload: map[string]interface{}{
+			"signature": "0xsig",
+			"authorization": map[string]interface{}{
+				"from":  "0xPayer",
+				"to":    "0xRecipient",
+				"value": "1000000",
+				"nonce": nonce,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode payload: %v", err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKeyPaymentSignature, encoded))
+}
+
+func TestUnaryServerInterceptor_MissingPayment(t *testing.T) {
+	interceptor := UnaryServerInterceptor(testConfig(&mockVerifier{}))
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptorFromSource_PicksUpNewPricing(t *testing.T) {
+	cfg := testConfig(&mockVerifier{})
+	cfg.MethodPricing = map[string]x402.PricingRule{}
+	source, err := x402.NewAtomicConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	interceptor := UnaryServerInterceptorFromSource(source)
+
+	call := func() error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "ok", nil
+			})
+		return err
+	}
+
+	if err := call(); err != nil {
+		t.Fatalf("expected free call before repricing, got %v", err)
+	}
+
+	if err := source.Store(testConfig(&mockVerifier{})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := call(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted after repricing, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptor_SettleOnSuccess(t *testing.T) {
+	var order []string
+	verifier := &mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			order = append(order, "settle")
+			return &x402.SettlementResult{TransactionHash: "0xtxhash"}, nil
+		},
+	}
+	cfg := testConfig(verifier)
+	cfg.SettlementMode = x402.SettleOnSuccess
+	interceptor := UnaryServerInterceptor(cfg)
+
+	resp, err := interceptor(paidContext(t), "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			order = append(order, "handler")
+			if _, err := RequirePayment(ctx); err != nil {
+				t.Errorf("expected payment in context: %v", err)
+			}
+			return "ok", nil
+		})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp != "ok" {
+		t.Errorf("expected handler response, got %v", resp)
+	}
+	if len(order) != 2 || order[0] != "handler" || order[1] != "settle" {
+		t.Errorf("expected handler then settle, got %v", order)
+	}
+}
+
+func TestUnaryServerInterceptor_SettleOnSuccess_HandlerError(t *testing.T) {
+	settled := false
+	verifier := &mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			settled = true
+			return &x402.SettlementResult{TransactionHash: "0xtxhash"}, nil
+		},
+	}
+	cfg := testConfig(verifier)
+	cfg.SettlementMode = x402.SettleOnSuccess
+	interceptor := UnaryServerInterceptor(cfg)
+
+	_, err := interceptor(paidContext(t), "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.Internal, "backend failure")
+		})
+
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected handler error to propagate, got %v", err)
+	}
+	if settled {
+		t.Error("payment should not be settled when the handler fails")
+	}
+}
+
+func TestUnaryServerInterceptor_SettleOnSuccess_SettlementFailure(t *testing.T) {
+	verifier := &mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			return nil, errors.New("insufficient funds")
+		},
+	}
+	cfg := testConfig(verifier)
+	cfg.SettlementMode = x402.SettleOnSuccess
+	interceptor := UnaryServerInterceptor(cfg)
+
+	resp, err := interceptor(paidContext(t), "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(status.Convert(err)); code != x402.ErrCodeSettlementFailed {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodeSettlementFailed, code)
+	}
+	if resp != nil {
+		t.Error("response must not be returned when settlement fails")
+	}
+}
+
+func TestUnaryServerInterceptor_NonceStore_RejectsReplay(t *testing.T) {
+	cfg := testConfig(&mockVerifier{})
+	cfg.NonceStore = x402.NewMemoryNonceStore()
+	interceptor := UnaryServerInterceptor(cfg)
+	ctx := paidContext(t)
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	if _, err := interceptor(ctx, "req", info, handler); err != nil {
+		t.Fatalf("unexpected error on first call: %v", err)
+	}
+
+	_, err := interceptor(ctx, "req", info, handler)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists for replayed payment, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptor_StrictMatching(t *testing.T) {
+	cfg := testConfig(&mockVerifier{})
+	cfg.StrictPaymentMatching = true
+	interceptor := UnaryServerInterceptor(cfg)
+
+	encoded, _ := EncodePaymentPayload(&x402.PaymentPayload{
+		X402Version: 2,
+		Accepted:    x402.PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", PayTo: "0xAttacker", Amount: "1000000"},
+		Payload:     map[string]interface{}{"signature": "0xsig"},
+	})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKeyPaymentSignature, encoded))
+
+	_, err := interceptor(ctx, "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	paymentReq, ok := PaymentRequiredFromError(err)
+	if !ok {
+		t.Fatalf("expected payment challenge, got %v", err)
+	}
+	if !strings.Contains(paymentReq.Error, x402.ErrCodeRecipientMismatch) {
+		t.Errorf("expected %s in challenge, got %q", x402.ErrCodeRecipientMismatch, paymentReq.Error)
+	}
+}
+
+func TestUnaryServerInterceptor_MalformedPayment(t *testing.T) {
+	interceptor := UnaryServerInterceptor(testConfig(&mockVerifier{}))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKeyPaymentSignature, "not-valid-base64!!!"))
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(status.Convert(err)); code != x402.ErrCodeInvalidPayment {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodeInvalidPayment, code)
+	}
+}
+
+func TestUnaryServerInterceptor_VerifierError(t *testing.T) {
+	verifier := &mockVerifier{
+		verifyFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.VerificationResult, error) {
+			return nil, errors.New("facilitator unreachable")
+		},
+	}
+	interceptor := UnaryServerInterceptor(testConfig(verifier))
+
+	_, err := interceptor(paidContext(t), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(status.Convert(err)); code != x402.ErrCodeVerificationFailed {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodeVerificationFailed, code)
+	}
+}
+
+func TestUnaryServerInterceptor_PaymentRejected(t *testing.T) {
+	verifier := &mockVerifier{
+		verifyFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.VerificationResult, error) {
+			return &x402.VerificationResult{Valid: false, Reason: "signature expired"}, nil
+		},
+	}
+	interceptor := UnaryServerInterceptor(testConfig(verifier))
+
+	_, err := interceptor(paidContext(t), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	paymentReq, ok := PaymentRequiredFromError(err)
+	if !ok {
+		t.Fatalf("expected payment challenge, got %v", err)
+	}
+	if !strings.Contains(paymentReq.Error, "signature expired") {
+		t.Errorf("expected rejection reason in challenge, got %q", paymentReq.Error)
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(status.Convert(err)); code != x402.ErrCodePaymentRejected {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodePaymentRejected, code)
+	}
+}
+
+// uptoVerifier is a mockVerifier that records SettleAmount calls.
+type uptoVerifier struct {
+	mockVerifier
+	settledAmount string
+}
+
+func (v *uptoVerifier) SettleAmount(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements, amount string) (*x402.SettlementResult, error) {
+	v.settledAmount = amount
+	return &x402.SettlementResult{TransactionHash: "0xtx", Amount: amount, Network: requirements.Network}, nil
+}
+
+func TestUnaryServerInterceptor_UptoSettlesReportedUsage(t *testing.T) {
+	verifier := &uptoVerifier{}
+	cfg := testConfig(verifier)
+	rule := cfg.MethodPricing[testMethod]
+	rule.Scheme = x402.SchemeUpto
+	cfg.MethodPricing[testMethod] = rule
+	interceptor := UnaryServerInterceptor(cfg)
+
+	_, err := interceptor(paidContext(t), "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			payment, err := RequirePayment(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if payment.MaxAmount != "1000000" {
+				t.Errorf("expected max amount 1000000, got %q", payment.MaxAmount)
+			}
+			return "ok", payment.ReportUsage("420")
+		})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verifier.settledAmount != "420" {
+		t.Errorf("expected to settle 420, got %q", verifier.settledAmount)
+	}
+}
+
+func TestUnaryServerInterceptor_UptoUnusedSkipsSettlement(t *testing.T) {
+	verifier := &uptoVerifier{}
+	cfg := testConfig(verifier)
+	cfg.NonceStore = x402.NewMemoryNonceStore()
+	rule := cfg.MethodPricing[testMethod]
+	rule.Scheme = x402.SchemeUpto
+	cfg.MethodPricing[testMethod] = rule
+	interceptor := UnaryServerInterceptor(cfg)
+
+	// The unused authorization is released, so it can be presented again.
+	for i := 0; i < 2; i++ {
+		stream := &trailerStream{}
+		_, err := interceptor(grpc.NewContextWithServerTransportStream(paidContext(t), stream), "req", &grpc.UnaryServerInfo{FullMethod: testMethod},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "ok", x402.ReportUsage(ctx, "0")
+			})
+		if err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+		if len(stream.trailer.Get(MetadataKeyPaymentResponse)) != 0 {
+			t.Errorf("call %d: expected no payment-response trailer", i)
+		}
+	}
+	if verifier.settledAmount != "" {
+		t.Errorf("expected no settlement, got %q", verifier.settledAmount)
+	}
+}
+
+func TestUnaryServerInterceptor_PriceFunc(t *testing.T) {
+	cfg := testConfig(&mockVerifier{})
+	rule := cfg.MethodPricing[testMethod]
+	static := rule.AcceptedTokens[0]
+	rule.AcceptedTokens = nil
+	rule.PriceFunc = func(ctx context.Context, req *x402.PriceRequest) ([]x402.TokenRequirement, error) {
+		if req.FullMethod != testMethod {
+			t.Errorf("expected method %s, got %s", testMethod, req.FullMethod)
+		}
+		token := static
+		token.Amount = req.Message.(string)
+		return []x402.TokenRequirement{token}, nil
+	}
+	cfg.MethodPricing[testMethod] = rule
+	interceptor := UnaryServerInterceptor(cfg)
+
+	_, err := interceptor(context.Background(), "2500", &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	paymentReq, ok := PaymentRequiredFromError(err)
+	if !ok {
+		t.Fatalf("expected payment challenge, got %v", err)
+	}
+	if paymentReq.Accepts[0].Amount != "2500" {
+		t.Errorf("expected price from the request message, got %s", paymentReq.Accepts[0].Amount)
+	}
+}
+
+func TestUnaryServerInterceptor_CreditBalanceTrailer(t *testing.T) {
+	settles := 0
+	cfg := testConfig(&mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			settles++
+			return &x402.SettlementResult{TransactionHash: "0xtx", Network: requirements.Network, Amount: payload.Accepted.Amount}, nil
+		},
+	})
+	cfg.BalanceStore = x402.NewMemoryBalanceStore()
+	cfg.NonceStore = x402.NewMemoryNonceStore()
+	rule := cfg.MethodPricing[testMethod]
+	rule.Credits = true
+	rule.AcceptedTokens[0].Amount = "250000"
+	cfg.MethodPricing[testMethod] = rule
+	interceptor := UnaryServerInterceptor(cfg)
+
+	for i, want := range []string{"750000", "500000"} {
+		stream := &trailerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(paidContextWithNonce(t, fmt.Sprintf("0xnonce%d", i)), stream)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+		if err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+
+		values := stream.trailer.Get(MetadataKeyPaymentResponse)
+		if len(values) != 1 {
+			t.Fatalf("call %d: expected payment-response trailer, got %v", i, stream.trailer)
+		}
+		response, err := x402.DecodePaymentResponse(values[0])
+		if err != nil {
+			t.Fatalf("call %d: failed to decode trailer: %v", i, err)
+		}
+		if response.Balance != want {
+			t.Errorf("call %d: expected balance %s, got %s", i, want, response.Balance)
+		}
+	}
+	if settles != 1 {
+		t.Errorf("expected a single settlement, got %d", settles)
+	}
+}
+
+func TestUnaryServerInterceptor_AccessToken(t *testing.T) {
+	verifies := 0
+	cfg := testConfig(&mockVerifier{
+		verifyFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.VerificationResult, error) {
+			verifies++
+			return &x402.VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: "1000000"}, nil
+		},
+	})
+	cfg.AccessTokenKey = []byte("0123456789abcdef0123456789abcdef")
+	rule := cfg.MethodPricing[testMethod]
+	rule.Access = &x402.AccessGrant{Duration: time.Hour, Patterns: []string{"/test.v1.Service/*"}}
+	cfg.MethodPricing[testMethod] = rule
+	cfg.MethodPricing["/test.v1.Service/Other"] = x402.PricingRule{AcceptedTokens: rule.AcceptedTokens}
+	interceptor := UnaryServerInterceptor(cfg)
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+
+	stream := &trailerStream{}
+	_, err := interceptor(grpc.NewContextWithServerTransportStream(paidContext(t), stream), nil, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	response, err := x402.DecodePaymentResponse(stream.trailer.Get(MetadataKeyPaymentResponse)[0])
+	if err != nil || response.AccessToken == "" {
+		t.Fatalf("expected an access token in the trailer, got %+v (%v)", response, err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKeyAccessToken, response.AccessToken))
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		payment, ok := GetPaymentFromContext(ctx)
+		if !ok || payment.PayerAddress != "0xPayer" || payment.AccessExpiresAt.IsZero() {
+			t.Errorf("expected the access token's payment context, got %+v", payment)
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("expected token to admit the call, got %v", err)
+	}
+
+	// The token also covers methods priced by rules without Access.
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.v1.Service/Other"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	if err != nil {
+		t.Fatalf("expected token to admit the other method, got %v", err)
+	}
+	if verifies != 1 {
+		t.Errorf("expected a single verification, got %d", verifies)
+	}
+}
+
+func TestUnaryServerInterceptor_RecordsSettlement(t *testing.T) {
+	ledger := x402.NewMemoryLedger()
+	cfg := testConfig(&mockVerifier{})
+	cfg.Ledger = ledger
+	interceptor := UnaryServerInterceptor(cfg)
+
+	md, _ := metadata.FromIncomingContext(paidContext(t))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Join(md, metadata.Pairs(MetadataKeyRequestID, "req-7")))
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, _ := ledger.Query(context.Background(), x402.LedgerQuery{Resource: testMethod})
+	if len(records) != 1 || records[0].RequestID != "req-7" || records[0].Payer != "0xPayer" {
+		t.Errorf("unexpected ledger records: %+v", records)
+	}
+}
+
+func TestUnaryServerInterceptor_IdempotentRetry(t *testing.T) {
+	calls, settlements := 0, 0
+	cfg := testConfig(&mockVerifier{
+		settleFunc: func(ctx context.Context, payment *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			settlements++
+			return &x402.SettlementResult{TransactionHash: "0xtx", Network: requirements.Network, PayerAddress: "0xPayer"}, nil
+		},
+	})
+	cfg.MethodPricing["/test.v1.Service/Other"] = cfg.MethodPricing[testMethod]
+	cfg.IdempotencyStore = x402.NewMemoryIdempotencyStore()
+	cfg.CacheIdempotentResponses = true
+	// The retry resends the very same payment, whose nonce is spent by then.
+	cfg.NonceStore = x402.NewMemoryNonceStore()
+	interceptor := UnaryServerInterceptor(cfg)
+
+	md, _ := metadata.FromIncomingContext(paidContext(t))
+	call := func(method, key string) (interface{}, metadata.MD, error) {
+		stream := &trailerStream{}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Join(md, metadata.Pairs(MetadataKeyIdempotencyKey, key)))
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+		resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				calls++
+				return calls, nil
+			})
+		return resp, stream.trailer, err
+	}
+
+	first, _, err := call(testMethod, "key-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	retry, trailer, err := call(testMethod, "key-1")
+	if err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if settlements != 1 || calls != 1 || retry != first {
+		t.Errorf("expected cached reply %v after 1 call and settlement, got %v after %d calls and %d settlements", first, retry, calls, settlements)
+	}
+	if len(trailer.Get(MetadataKeyPaymentResponse)) == 0 {
+		t.Error("expected payment-response trailer on replay")
+	}
+
+	_, _, err = call("/test.v1.Service/Other", "key-1")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected ALREADY_EXISTS for a reused key, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptor_IdempotentConcurrentRetry(t *testing.T) {
+	settlements := 0
+	cfg := testConfig(&mockVerifier{
+		settleFunc: func(ctx context.Context, payment *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			settlements++
+			return &x402.SettlementResult{TransactionHash: "0xtx", Network: requirements.Network}, nil
+		},
+	})
+	cfg.SettlementMode = x402.SettleOnSuccess
+	cfg.IdempotencyStore = x402.NewMemoryIdempotencyStore()
+	interceptor := UnaryServerInterceptor(cfg)
+
+	md, _ := metadata.FromIncomingContext(paidContext(t))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Join(md, metadata.Pairs(MetadataKeyIdempotencyKey, "key-1")))
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &trailerStream{})
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+
+	var concurrentErr error
+	var handler grpc.UnaryHandler
+	handler = func(hctx context.Context, req interface{}) (interface{}, error) {
+		if concurrentErr == nil {
+			// Retry with the same key while the first call is in flight.
+			_, concurrentErr = interceptor(ctx, nil, info, handler)
+		}
+		return "ok", nil
+	}
+
+	if _, err := interceptor(ctx, nil, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Code(concurrentErr) != codes.AlreadyExists {
+		t.Errorf("expected ALREADY_EXISTS for the in-flight retry, got %v", concurrentErr)
+	}
+	if settlements != 1 {
+		t.Errorf("expected 1 settlement, got %d", settlements)
+	}
+}
+
+func TestUnaryServerInterceptor_SettleAsync(t *testing.T) {
+	settlements := 0
+	cfg := testConfig(&mockVerifier{
+		settleFunc: func(ctx context.Context, payment *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			settlements++
+			return &x402.SettlementResult{TransactionHash: "0xtx", Network: requirements.Network}, nil
+		},
+	})
+	cfg.SettlementMode = x402.SettleAsync
+	cfg.Settler = x402.NewSettler(10)
+	cfg.NonceStore = x402.NewMemoryNonceStore()
+	interceptor := UnaryServerInterceptor(cfg)
+
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(paidContext(t), stream)
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			payment, _ := GetPaymentFromContext(ctx)
+			if payment.Settlement != x402.SettlementPending {
+				t.Errorf("expected pending settlement, got %q", payment.Settlement)
+			}
+			return "ok", nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settlements != 0 {
+		t.Errorf("expected no settlement on the call path, got %d", settlements)
+	}
+
+	receipt, err := DecodePaymentResponse(stream.trailer.Get(MetadataKeyPaymentResponse)[0])
+	if err != nil || !receipt.Pending {
+		t.Errorf("expected pending payment-response trailer, got %+v (%v)", receipt, err)
+	}
+
+	stopCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.Settler.Run(stopCtx)
+	if settlements != 1 {
+		t.Errorf("expected 1 settlement after draining, got %d", settlements)
+	}
+}
+
+// trailerStream records the trailers set through grpc.SetTrailer.
+type trailerStream struct {
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Method() string                  { return testMethod }
+func (s *trailerStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *trailerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestUnaryServerInterceptor_PaymentRequiredDetails(t *testing.T) {
+	interceptor := UnaryServerInterceptor(testConfig(&mockVerifier{}))
+
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("handler should not be called")
+			return nil, nil
+		})
+
+	st := status.Convert(err)
+	if st.Message() != "payment required" {
+		t.Errorf("expected human-readable message, got %q", st.Message())
+	}
+
+	paymentReq, ok := x402.PaymentRequiredFromStatus(st)
+	if !ok {
+		t.Fatal("expected payment requirements in status details")
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(st); code != x402.ErrCodePaymentRequired {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodePaymentRequired, code)
+	}
+	if paymentReq.Accepts[0].Amount != "1000000" {
+		t.Errorf("unexpected requirement: %+v", paymentReq.Accepts[0])
+	}
+
+	values := stream.trailer.Get(MetadataKeyPaymentRequired)
+	if len(values) != 1 {
+		t.Fatalf("expected payment-required trailer, got %v", stream.trailer)
+	}
+	fromTrailer, err := DecodePaymentRequirements(values[0])
+	if err != nil {
+		t.Fatalf("failed to decode trailer: %v", err)
+	}
+	if len(fromTrailer.Accepts) != 1 {
+		t.Errorf("expected 1 requirement in trailer, got %d", len(fromTrailer.Accepts))
+	}
+}
+
+func TestUnaryServerInterceptor_LegacyPaymentRequiredMessage(t *testing.T) {
+	cfg := testConfig(&mockVerifier{})
+	cfg.LegacyPaymentRequiredMessage = true
+	interceptor := UnaryServerInterceptor(cfg)
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+
+	paymentReq, err := DecodePaymentRequirements(status.Convert(err).Message())
+	if err != nil {
+		t.Fatalf("expected base64 requirements in message: %v", err)
+	}
+	if len(paymentReq.Accepts) != 1 {
+		t.Errorf("expected 1 requirement, got %d", len(paymentReq.Accepts))
+	}
+}
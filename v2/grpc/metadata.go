@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
 	"google.golang.org/grpc/metadata"
@@ -14,6 +15,9 @@ const (
 	MetadataKeyPaymentSignature    = "payment-signature"
 	MetadataKeyPaymentResponse     = "payment-response"
 	MetadataKeyPaymentRequired     = "payment-required"
+	MetadataKeyAccessToken         = "payment-access-token"
+	MetadataKeyRequestID           = "x-request-id"
+	MetadataKeyIdempotencyKey      = "idempotency-key"
 
 	// V1 legacy metadata keys.
 	MetadataKeyLegacyPayment              = "x402-payment"
@@ -23,12 +27,14 @@ const (
 
 // EncodePaymentRequirements encodes a PaymentRequiredResponse to base64 JSON.
 func EncodePaymentRequirements(accepts []x402.PaymentRequirements) (string, error) {
-	response := x402.PaymentRequiredResponse{
+	return encodePaymentRequired(x402.PaymentRequiredResponse{
 		X402Version: 2,
 		Error:       "payment required",
 		Accepts:     accepts,
-	}
+	})
+}
 
+func encodePaymentRequired(response x402.PaymentRequiredResponse) (string, error) {
 	jsonBytes, err := json.Marshal(response)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal payment requirements: %w", err)
@@ -39,17 +45,7 @@ func EncodePaymentRequirements(accepts []x402.PaymentRequirements) (string, erro
 
 // DecodePaymentRequirements decodes base64 JSON payment requirements.
 func DecodePaymentRequirements(encoded string) (*x402.PaymentRequiredResponse, error) {
-	jsonBytes, err := base64.StdEncoding.DecodeString(encoded)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64: %w", err)
-	}
-
-	var response x402.PaymentRequiredResponse
-	if err := json.Unmarshal(jsonBytes, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payment requirements: %w", err)
-	}
-
-	return &response, nil
+	return x402.DecodePaymentRequirements(encoded)
 }
 
 // EncodePaymentPayload encodes a PaymentPayload to base64 JSON for metadata.
@@ -144,17 +140,24 @@ func DecodePaymentResponse(encoded string) (*x402.PaymentResponse, error) {
 
 // ExtractPaymentFromMetadata extracts payment from gRPC metadata.
 // Tries V2 key (payment-signature) first, falls back to V1 (x402-payment).
+// A payment that fails to decode yields a PaymentError with code ErrCodeInvalidPayment.
 func ExtractPaymentFromMetadata(md metadata.MD) (*x402.PaymentPayload, bool, error) {
 	// Try V2 first.
 	if values := md.Get(MetadataKeyPaymentSignature); len(values) > 0 {
 		payload, err := DecodePaymentPayload(values[0])
-		return payload, true, err
+		if err != nil {
+			return nil, true, x402.NewPaymentError(x402.ErrCodeInvalidPayment, "invalid payment-signature metadata", err)
+		}
+		return payload, true, nil
 	}
 
 	// Fall back to V1.
 	if values := md.Get(MetadataKeyLegacyPayment); len(values) > 0 {
 		payload, err := DecodeLegacyPayment(values[0])
-		return payload, false, err
+		if err != nil {
+			return nil, false, x402.NewPaymentError(x402.ErrCodeInvalidPayment, "invalid x402-payment metadata", err)
+		}
+		return payload, false, nil
 	}
 
 	return nil, false, fmt.Errorf("no payment found in metadata")
@@ -163,15 +166,10 @@ func ExtractPaymentFromMetadata(md metadata.MD) (*x402.PaymentPayload, bool, err
 // BuildPaymentRequirements builds PaymentRequirements from a pricing rule.
 func BuildPaymentRequirements(rule *x402.PricingRule, fullMethod string, validityDuration interface{}) []x402.PaymentRequirements {
 	accepts := make([]x402.PaymentRequirements, 0, len(rule.AcceptedTokens))
+	timeout, _ := validityDuration.(time.Duration)
 
 	for _, token := range rule.AcceptedTokens {
-		accepts = append(accepts, x402.PaymentRequirements{
-			Scheme:  "exact",
-			Network: token.Network,
-			Amount:  token.Amount,
-			Asset:   token.AssetContract,
-			PayTo:   token.Recipient,
-		})
+		accepts = append(accepts, rule.Requirements(token, timeout))
 	}
 
 	return accepts
@@ -0,0 +1,202 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// PaymentCarrier is implemented by client stream messages that can carry a
+// top-up payment, such as a generated message with a
+// `string payment_signature = N;` field. A non-empty signature holds an
+// encoded V2 payment payload (see EncodePaymentPayload).
+type PaymentCarrier interface {
+	GetPaymentSignature() string
+}
+
+// serveMeteredStream runs a stream whose pricing rule has Metering: the
+// initial payment and every top-up are settled immediately and each buys
+// the rule's allowance, which the server's messages use up.
+func serveMeteredStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler, cfg *x402.Config, rule *x402.PricingRule, payload *x402.PaymentPayload, isV2 bool) error {
+	ctx := ss.Context()
+
+	paymentCtx, settlementResult, err := settlePayment(ctx, cfg, rule, info.FullMethod, payload, isV2)
+	if err != nil {
+		return err
+	}
+
+	stream := &meteredServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ctx, x402.PaymentContextKey, paymentCtx),
+		cfg:          cfg,
+		rule:         rule,
+		fullMethod:   info.FullMethod,
+		remaining:    rule.Metering.Allowance,
+	}
+	stream.setReceipt(settlementResult, isV2)
+
+	err = handler(srv, stream)
+
+	// Trailers set on a stream accumulate, so the receipt is set once, when
+	// the handler returns, and holds only the latest settlement.
+	stream.mu.Lock()
+	receipt := stream.receipt
+	stream.mu.Unlock()
+	if receipt != nil {
+		ss.SetTrailer(receipt)
+	}
+	return err
+}
+
+// meteredServerStream counts the messages or bytes the handler sends against
+// the prepaid allowance and accepts top-up payments on received messages.
+type meteredServerStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	cfg        *x402.Config
+	rule       *x402.PricingRule
+	fullMethod string
+
+	mu        sync.Mutex
+	remaining int64
+	receipt   metadata.MD
+}
+
+func (s *meteredServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg sends m while allowance remains. A message larger than the remaining
+// byte allowance is still sent, and the overdraft is taken from the next
+// top-up. Once the allowance is used up SendMsg returns the payment
+// challenge, which the handler should return to end the stream.
+func (s *meteredServerStream) SendMsg(m interface{}) error {
+	units, err := s.units(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.remaining <= 0 {
+		s.mu.Unlock()
+		return sendPaymentRejected(s.ctx, s.rule, s.fullMethod, s.cfg,
+			x402.NewPaymentError(x402.ErrCodePaymentRequired, "stream allowance exhausted", nil))
+	}
+	s.remaining -= units
+	s.mu.Unlock()
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// RecvMsg receives m and, if it carries a payment (see PaymentCarrier),
+// settles it and adds to the allowance before returning m to the handler.
+func (s *meteredServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	carrier, ok := m.(PaymentCarrier)
+	if !ok || carrier.GetPaymentSignature() == "" {
+		return nil
+	}
+
+	payload, err := DecodePaymentPayload(carrier.GetPaymentSignature())
+	if err != nil {
+		return x402.PaymentErrorStatus(x402.NewPaymentError(x402.ErrCodeInvalidPayment, "invalid top-up payment", err)).Err()
+	}
+
+	_, settlementResult, err := settlePayment(s.ctx, s.cfg, s.rule, s.fullMethod, payload, true)
+	if err != nil {
+		return err
+	}
+	s.setReceipt(settlementResult, true)
+
+	s.mu.Lock()
+	s.remaining += s.rule.Metering.Allowance
+	s.mu.Unlock()
+	return nil
+}
+
+// setReceipt makes settlementResult the receipt sent in the stream's trailer.
+func (s *meteredServerStream) setReceipt(settlementResult *x402.SettlementResult, isV2 bool) {
+	trailer, ok := paymentResponseTrailer(settlementResult, isV2)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.receipt = trailer
+	s.mu.Unlock()
+}
+
+func (s *meteredServerStream) units(m interface{}) (int64, error) {
+	if s.rule.Metering.Unit != x402.MeterBytes {
+		return 1, nil
+	}
+
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return 0, status.Error(codes.Internal, fmt.Sprintf("cannot meter bytes of %T", m))
+	}
+	return int64(proto.Size(msg)), nil
+}
+
+// settlePayment verifies and settles a payment for rule right away,
+// regardless of the settlement mode.
+func settlePayment(ctx context.Context, cfg *x402.Config, rule *x402.PricingRule, fullMethod string, payload *x402.PaymentPayload, isV2 bool) (*x402.PaymentContext, *x402.SettlementResult, error) {
+	accepts := BuildPaymentRequirements(rule, fullMethod, cfg.ValidityDuration)
+	if len(accepts) == 0 {
+		return nil, nil, status.Error(codes.Internal, "no payment requirements configured")
+	}
+	requirements := &accepts[0]
+
+	var tokenSymbol string
+	var err error
+	if isV2 {
+		requirements, tokenSymbol, err = cfg.ResolveRequirements(rule, payload, requirements)
+		if err != nil {
+			return nil, nil, sendPaymentRejected(ctx, rule, fullMethod, cfg, err)
+		}
+	}
+
+	finishNonce, err := cfg.ReservePaymentNonce(ctx, payload)
+	if err != nil {
+		return nil, nil, nonceError(err)
+	}
+	settled := false
+	defer func() { finishNonce(settled) }()
+
+	verifyResult, err := cfg.Verifier.Verify(ctx, payload, requirements)
+	if err != nil {
+		return nil, nil, paymentFailure(ctx, rule, fullMethod, cfg, err, x402.ErrCodeVerificationFailed, "payment verification error")
+	}
+	if !verifyResult.Valid {
+		return nil, nil, sendPaymentRejected(ctx, rule, fullMethod, cfg, invalidPayment(verifyResult))
+	}
+
+	settlementResult, err := cfg.Verifier.Settle(ctx, payload, requirements)
+	if err != nil {
+		return nil, nil, paymentFailure(ctx, rule, fullMethod, cfg, err, x402.ErrCodeSettlementFailed, "payment settlement failed")
+	}
+	settled = true
+
+	if tokenSymbol == "" {
+		tokenSymbol = verifyResult.TokenSymbol
+	}
+
+	paymentCtx := x402.NewPaymentContext(verifyResult, requirements)
+	paymentCtx.TokenSymbol = tokenSymbol
+	paymentCtx.TransactionHash = settlementResult.TransactionHash
+	paymentCtx.SettledAt = settlementResult.SettledAt
+	paymentCtx.Resource = fullMethod
+	paymentCtx.RequestID = requestID(ctx)
+	paymentCtx.Settlement = x402.SettlementConfirmed
+	cfg.RecordSettlement(ctx, requirements, paymentCtx, settlementResult)
+	return paymentCtx, settlementResult, nil
+}
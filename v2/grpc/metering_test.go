@@ -0,0 +1,192 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	x402 "github.com/becomeliminal/grpc-gateway-x402/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeServerStream records sent messages and trailers and replays queued client messages.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	sent     []interface{}
+	received []*topUpMessage
+	trailer  metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	next := s.received[0]
+	s.received = s.received[1:]
+	*m.(*topUpMessage) = *next
+	return nil
+}
+
+func (s *fakeServerStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+type topUpMessage struct {
+	paymentSignature string
+}
+
+func (m *topUpMessage) GetPaymentSignature() string { return m.paymentSignature }
+
+func meteredConfig(verifier x402.ChainVerifier, metering x402.StreamMetering) x402.Config {
+	cfg := testConfig(verifier)
+	rule := cfg.MethodPricing[testMethod]
+	rule.Metering = &metering
+	cfg.MethodPricing[testMethod] = rule
+	return cfg
+}
+
+func paymentSignature(t *testing.T) string {
+	t.Helper()
+	md, _ := metadata.FromIncomingContext(paidContext(t))
+	return md.Get(MetadataKeyPaymentSignature)[0]
+}
+
+func TestStreamServerInterceptor_MeteredAllowanceExhausted(t *testing.T) {
+	var settlements int32
+	verifier := &mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			atomic.AddInt32(&settlements, 1)
+			return &x402.SettlementResult{TransactionHash: "0xtxhash", Network: "eip155:84532"}, nil
+		},
+	}
+	interceptor := StreamServerInterceptor(meteredConfig(verifier, x402.StreamMetering{Allowance: 2}))
+	ss := &fakeServerStream{ctx: paidContext(t)}
+
+	var sendErr error
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: testMethod, IsServerStream: true},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			if _, ok := GetPaymentFromContext(stream.Context()); !ok {
+				t.Error("expected payment context")
+			}
+			for i := 0; i < 3; i++ {
+				if sendErr = stream.SendMsg(wrapperspb.String("tick")); sendErr != nil {
+					return sendErr
+				}
+			}
+			return nil
+		})
+
+	if len(ss.sent) != 2 {
+		t.Errorf("expected 2 messages within the allowance, got %d", len(ss.sent))
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted once the allowance ran out, got %v", err)
+	}
+	if _, ok := PaymentRequiredFromError(err); !ok {
+		t.Error("expected payment challenge in the status")
+	}
+	if code, _ := x402.PaymentErrorCodeFromStatus(status.Convert(err)); code != x402.ErrCodePaymentRequired {
+		t.Errorf("expected reason %s, got %q", x402.ErrCodePaymentRequired, code)
+	}
+	if settlements != 1 {
+		t.Errorf("expected the initial payment to settle once, got %d", settlements)
+	}
+	if len(ss.trailer.Get(MetadataKeyPaymentResponse)) != 1 {
+		t.Errorf("expected payment-response trailer, got %v", ss.trailer)
+	}
+}
+
+func TestStreamServerInterceptor_MeteredTopUp(t *testing.T) {
+	var settlements int32
+	verifier := &mockVerifier{
+		settleFunc: func(ctx context.Context, payload *x402.PaymentPayload, requirements *x402.PaymentRequirements) (*x402.SettlementResult, error) {
+			n := atomic.AddInt32(&settlements, 1)
+			return &x402.SettlementResult{TransactionHash: fmt.Sprintf("0xtx%d", n), Network: "eip155:84532"}, nil
+		},
+	}
+	interceptor := StreamServerInterceptor(meteredConfig(verifier, x402.StreamMetering{Unit: x402.MeterMessages, Allowance: 1}))
+	ss := &fakeServerStream{
+		ctx:      paidContext(t),
+		received: []*topUpMessage{{paymentSignature: paymentSignature(t)}},
+	}
+
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: testMethod, IsClientStream: true, IsServerStream: true},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			if err := stream.SendMsg(wrapperspb.String("first")); err != nil {
+				return err
+			}
+			var msg topUpMessage
+			if err := stream.RecvMsg(&msg); err != nil {
+				return err
+			}
+			return stream.SendMsg(wrapperspb.String("second"))
+		})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ss.sent) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(ss.sent))
+	}
+	if settlements != 2 {
+		t.Errorf("expected initial payment and top-up to settle, got %d", settlements)
+	}
+
+	// The trailer carries only the latest receipt.
+	values := ss.trailer.Get(MetadataKeyPaymentResponse)
+	if len(values) != 1 {
+		t.Fatalf("expected one payment-response trailer, got %v", values)
+	}
+	if receipt, err := DecodePaymentResponse(values[0]); err != nil || receipt.Transaction != "0xtx2" {
+		t.Errorf("expected the top-up receipt, got %+v (%v)", receipt, err)
+	}
+}
+
+func TestStreamServerInterceptor_MeteredInvalidTopUp(t *testing.T) {
+	interceptor := StreamServerInterceptor(meteredConfig(&mockVerifier{}, x402.StreamMetering{Allowance: 1}))
+	ss := &fakeServerStream{
+		ctx:      paidContext(t),
+		received: []*topUpMessage{{paymentSignature: "not-valid-base64!!!"}},
+	}
+
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: testMethod, IsClientStream: true},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			var msg topUpMessage
+			return stream.RecvMsg(&msg)
+		})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a malformed top-up, got %v", err)
+	}
+}
+
+func TestStreamServerInterceptor_MeteredBytes(t *testing.T) {
+	interceptor := StreamServerInterceptor(meteredConfig(&mockVerifier{}, x402.StreamMetering{Unit: x402.MeterBytes, Allowance: 5}))
+	ss := &fakeServerStream{ctx: paidContext(t)}
+
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: testMethod, IsServerStream: true},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			// The first message overdraws the allowance; the second finds it empty.
+			if err := stream.SendMsg(wrapperspb.String("hello world")); err != nil {
+				return err
+			}
+			return stream.SendMsg(wrapperspb.String("again"))
+		})
+
+	if len(ss.sent) != 1 {
+		t.Errorf("expected 1 message, got %d", len(ss.sent))
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+}
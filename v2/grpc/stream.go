@@ -12,28 +12,64 @@ import (
 )
 
 // StreamServerInterceptor creates a gRPC stream server interceptor that enforces x402 payments.
-// Payment is verified BEFORE the stream begins (upfront payment).
+// Payment is verified BEFORE the stream begins (upfront payment). In SettleOnSuccess
+// mode settlement is deferred until the handler returns without error. Methods whose
+// pricing rule has Metering are charged per message or byte sent instead (see StreamMetering).
 func StreamServerInterceptor(cfg x402.Config) grpc.StreamServerInterceptor {
-	if err := cfg.Validate(); err != nil {
+	source, err := x402.NewAtomicConfig(cfg)
+	if err != nil {
 		panic(fmt.Sprintf("invalid x402 config: %v", err))
 	}
 
+	return StreamServerInterceptorFromSource(source)
+}
+
+// StreamServerInterceptorFromSource is like StreamServerInterceptor but reads the configuration from
+// source on every call, so pricing changes take effect without a restart.
+func StreamServerInterceptorFromSource(source x402.ConfigSource) grpc.StreamServerInterceptor {
+	if source == nil || source.Config() == nil {
+		panic("invalid x402 config: config source has no configuration")
+	}
+
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		ctx := ss.Context()
+		cfg := source.Config()
 
 		rule, requiresPayment := cfg.MatchMethod(info.FullMethod)
 		if !requiresPayment {
 			return handler(srv, ss)
 		}
 
+		if paymentCtx, ok := accessTokenPayment(ctx, cfg, info.FullMethod); ok {
+			return handler(srv, &paymentServerStream{
+				ServerStream: ss,
+				ctx:          context.WithValue(ctx, x402.PaymentContextKey, paymentCtx),
+			})
+		}
+
+		rule, err := cfg.Quote(ctx, rule, &x402.PriceRequest{FullMethod: info.FullMethod})
+		if err != nil {
+			return x402.PaymentErrorStatus(err).Err()
+		}
+		if len(rule.AcceptedTokens) == 0 {
+			return handler(srv, ss)
+		}
+
 		md, ok := metadata.FromIncomingContext(ctx)
 		if !ok {
-			return sendPaymentRequired(rule, info.FullMethod, &cfg)
+			return sendPaymentRequired(ctx, rule, info.FullMethod, cfg)
 		}
 
 		payload, isV2, err := ExtractPaymentFromMetadata(md)
 		if err != nil {
-			return sendPaymentRequired(rule, info.FullMethod, &cfg)
+			if x402.IsPaymentError(err) {
+				return x402.PaymentErrorStatus(err).Err()
+			}
+			return sendPaymentRequired(ctx, rule, info.FullMethod, cfg)
+		}
+
+		if rule.Metering != nil {
+			return serveMeteredStream(srv, ss, info, handler, cfg, rule, payload, isV2)
 		}
 
 		accepts := BuildPaymentRequirements(rule, info.FullMethod, cfg.ValidityDuration)
@@ -42,41 +78,77 @@ func StreamServerInterceptor(cfg x402.Config) grpc.StreamServerInterceptor {
 		}
 		requirements := &accepts[0]
 
-		// Match client's chosen token against accepted tokens.
+		// Match the client's chosen token against accepted tokens.
 		var tokenSymbol string
-		if payload != nil {
-			if matched, symbol := x402.MatchClientToken(rule, payload); matched != nil {
-				requirements = matched
-				tokenSymbol = symbol
+		if isV2 {
+			requirements, tokenSymbol, err = cfg.ResolveRequirements(rule, payload, requirements)
+			if err != nil {
+				return sendPaymentRejected(ctx, rule, info.FullMethod, cfg, err)
 			}
 		}
 
+		// A retry that resends the payment of a stream that was already
+		// settled runs again without paying; stream responses are never
+		// cached. Otherwise the key is held while this stream is in flight,
+		// so concurrent retries are not charged twice.
+		idempotencyKey := idempotencyKey(ctx)
+		paymentKey := x402.PaymentNonceKey(payload)
+		record, finishKey, err := cfg.ReserveIdempotencyKey(ctx, idempotencyKey, payload, info.FullMethod)
+		if err != nil {
+			return x402.PaymentErrorStatus(err).Err()
+		}
+		defer finishKey()
+		if record != nil {
+			return replayStream(srv, ss, handler, record, isV2)
+		}
+
+		finishNonce, err := cfg.ReservePaymentNonce(ctx, payload)
+		if err != nil {
+			return nonceError(err)
+		}
+		settled := false
+		defer func() { finishNonce(settled) }()
+
 		verifyResult, err := cfg.Verifier.Verify(ctx, payload, requirements)
 		if err != nil {
-			return status.Error(codes.Internal, fmt.Sprintf("payment verification error: %v", err))
+			return paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeVerificationFailed, "payment verification error")
 		}
 
 		if !verifyResult.Valid {
-			return sendPaymentRequired(rule, info.FullMethod, &cfg)
+			return sendPaymentRejected(ctx, rule, info.FullMethod, cfg, invalidPayment(verifyResult))
 		}
 
-		settlementResult, err := cfg.Verifier.Settle(ctx, payload, requirements)
+		// A retry with a fresh payment from the same payer runs without
+		// settling it.
+		record, err = cfg.IdempotentReplay(ctx, idempotencyKey, verifyResult.PayerAddress, info.FullMethod)
 		if err != nil {
-			return status.Error(codes.Unavailable, fmt.Sprintf("payment settlement failed: %v", err))
+			return x402.PaymentErrorStatus(err).Err()
+		}
+		if record != nil {
+			return replayStream(srv, ss, handler, record, isV2)
 		}
 
 		if tokenSymbol == "" {
 			tokenSymbol = verifyResult.TokenSymbol
 		}
 
-		paymentCtx := &x402.PaymentContext{
-			Verified:        true,
-			PayerAddress:    verifyResult.PayerAddress,
-			Amount:          verifyResult.Amount,
-			TokenSymbol:     tokenSymbol,
-			Network:         requirements.Network,
-			TransactionHash: settlementResult.TransactionHash,
-			SettledAt:       settlementResult.SettledAt,
+		paymentCtx := x402.NewPaymentContext(verifyResult, requirements)
+		paymentCtx.TokenSymbol = tokenSymbol
+		paymentCtx.Resource = info.FullMethod
+		paymentCtx.RequestID = requestID(ctx)
+
+		// In SettleOnSuccess mode, and for "upto" payments, the stream runs
+		// first and is only charged if the handler returns without error.
+		var settlementResult *x402.SettlementResult
+		if cfg.SettlementMode != x402.SettleOnSuccess && requirements.Scheme != x402.SchemeUpto {
+			settlementResult, err = cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+			if err != nil {
+				return paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeSettlementFailed, "payment settlement failed")
+			}
+			settled = true
+			paymentCtx.TransactionHash = settlementResult.TransactionHash
+			paymentCtx.SettledAt = settlementResult.SettledAt
+			rememberCall(ctx, cfg, idempotencyKey, paymentKey, paymentCtx, settlementResult, nil)
 		}
 
 		ctx = context.WithValue(ctx, x402.PaymentContextKey, paymentCtx)
@@ -86,30 +158,43 @@ func StreamServerInterceptor(cfg x402.Config) grpc.StreamServerInterceptor {
 			ctx:          ctx,
 		}
 
-		handlerErr := handler(srv, wrappedStream)
+		if err := handler(srv, wrappedStream); err != nil {
+			return err
+		}
 
-		if handlerErr == nil {
-			paymentResponse := x402.PaymentResponse{
-				Success:     true,
-				Transaction: settlementResult.TransactionHash,
-				Network:     settlementResult.Network,
-				Payer:       settlementResult.PayerAddress,
+		if settlementResult == nil {
+			settlementResult, err = cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+			if err != nil {
+				return paymentFailure(ctx, rule, info.FullMethod, cfg, err, x402.ErrCodeSettlementFailed, "payment settlement failed")
 			}
-
-			encoded, encErr := EncodePaymentResponse(&paymentResponse)
-			if encErr == nil {
-				if isV2 {
-					trailer := metadata.Pairs(MetadataKeyPaymentResponse, encoded)
-					wrappedStream.SetTrailer(trailer)
-				} else {
-					trailer := metadata.Pairs(MetadataKeyLegacyPaymentResponse, encoded)
-					wrappedStream.SetTrailer(trailer)
-				}
+			if settlementResult == nil {
+				// The "upto" payment was not used, so nothing was charged.
+				return nil
 			}
+			settled = true
+			paymentCtx.TransactionHash = settlementResult.TransactionHash
+			paymentCtx.SettledAt = settlementResult.SettledAt
+			rememberCall(ctx, cfg, idempotencyKey, paymentKey, paymentCtx, settlementResult, nil)
+		}
+
+		if trailer, ok := paymentResponseTrailer(settlementResult, isV2); ok {
+			wrappedStream.SetTrailer(trailer)
 		}
 
-		return handlerErr
+		return nil
+	}
+}
+
+// replayStream runs a retried stream with the payment of the settled stream
+// in record.
+func replayStream(srv interface{}, ss grpc.ServerStream, handler grpc.StreamHandler, record *x402.IdempotencyRecord, isV2 bool) error {
+	if trailer, ok := receiptTrailer(record.Receipt, isV2); ok {
+		ss.SetTrailer(trailer)
 	}
+	return handler(srv, &paymentServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), x402.PaymentContextKey, record.Payment),
+	})
 }
 
 type paymentServerStream struct {
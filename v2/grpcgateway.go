@@ -2,12 +2,99 @@ package x402
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/textproto"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// gRPC metadata keys used by the x402 interceptors (see the v2/grpc package).
+const (
+	metadataPaymentSignature     = "payment-signature"
+	metadataLegacyPayment        = "x402-payment"
+	metadataAccessToken          = "payment-access-token"
+	metadataIdempotencyKey       = "idempotency-key"
+	trailerPaymentResponse       = "payment-response"
+	trailerLegacyPaymentResponse = "x402-payment-response"
+)
+
+// GatewayMiddleware returns a grpc-gateway middleware that enforces x402 payments
+// inside the ServeMux. Install it with runtime.WithMiddlewares. Unlike
+// PaymentMiddleware, which sees only the raw URL, it prices requests by the
+// gateway route they matched (see Config.MatchGatewayRoute), so one MethodPricing
+// table can serve both the gateway and the native gRPC interceptors.
+func GatewayMiddleware(cfg Config) runtime.Middleware {
+	source, err := NewAtomicConfig(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("invalid x402 middleware configuration: %v", err))
+	}
+
+	return GatewayMiddlewareFromSource(source)
+}
+
+// GatewayMiddlewareFromSource is like GatewayMiddleware but reads the configuration
+// from source on every request.
+func GatewayMiddlewareFromSource(source ConfigSource) runtime.Middleware {
+	if source == nil || source.Config() == nil {
+		panic("invalid x402 middleware configuration: config source has no configuration")
+	}
+
+	return func(next runtime.HandlerFunc) runtime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			cfg := source.Config()
+
+			var pattern string
+			if p, ok := runtime.HTTPPattern(r.Context()); ok {
+				pattern = p.String()
+			}
+
+			rule, requiresPayment := cfg.MatchGatewayRoute(r.Method, pattern, r.URL.Path)
+			if !requiresPayment {
+				next(w, r, pathParams)
+				return
+			}
+
+			enforcePayment(w, r, cfg, rule, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next(w, r, pathParams)
+			}))
+		}
+	}
+}
+
+// WithPaymentHeaderForwarding returns a ServeMuxOption that forwards the raw
+// PAYMENT-SIGNATURE and X-PAYMENT headers to the gRPC backend as payment-signature
+// and x402-payment metadata, for services that enforce payment with the v2/grpc
+// interceptors instead of the HTTP middleware. It replaces the incoming header
+// matcher with PaymentHeaderMatcher. Requests whose payment was already handled by
+// PaymentMiddleware or GatewayMiddleware arrive without these headers, so a
+// payment is never charged by both layers.
+func WithPaymentHeaderForwarding() runtime.ServeMuxOption {
+	return runtime.WithIncomingHeaderMatcher(PaymentHeaderMatcher)
+}
+
+// PaymentHeaderMatcher is a runtime.HeaderMatcherFunc for incoming headers. It
+// maps the x402 payment headers to their gRPC metadata keys and falls back to
+// runtime.DefaultHeaderMatcher for everything else.
+func PaymentHeaderMatcher(key string) (string, bool) {
+	switch textproto.CanonicalMIMEHeaderKey(key) {
+	case textproto.CanonicalMIMEHeaderKey(HeaderPaymentSignature):
+		return metadataPaymentSignature, true
+	case textproto.CanonicalMIMEHeaderKey(HeaderLegacyPayment):
+		return metadataLegacyPayment, true
+	case textproto.CanonicalMIMEHeaderKey(HeaderAccessToken):
+		return metadataAccessToken, true
+	case textproto.CanonicalMIMEHeaderKey(HeaderIdempotencyKey):
+		return metadataIdempotencyKey, true
+	default:
+		return runtime.DefaultHeaderMatcher(key)
+	}
+}
+
 // WithPaymentMetadata returns a ServeMuxOption that propagates payment information
 // from HTTP context to gRPC metadata, making it accessible in gRPC handlers.
 func WithPaymentMetadata() runtime.ServeMuxOption {
@@ -32,6 +119,10 @@ func WithPaymentMetadata() runtime.ServeMuxOption {
 			if payment.TransactionHash != "" {
 				md.Set("x-payment-tx-hash", payment.TransactionHash)
 			}
+
+			if payment.Settlement != "" {
+				md.Set("x-payment-settlement", string(payment.Settlement))
+			}
 		}
 
 		return md
@@ -74,6 +165,10 @@ func GetPaymentFromGRPCContext(ctx context.Context) (*PaymentContext, bool) {
 		payment.TransactionHash = txHash[0]
 	}
 
+	if settlement := md.Get("x-payment-settlement"); len(settlement) > 0 {
+		payment.Settlement = SettlementStatus(settlement[0])
+	}
+
 	return payment, true
 }
 
@@ -82,3 +177,102 @@ func GetHTTPPathPattern(ctx context.Context) (string, bool) {
 	pattern, ok := runtime.HTTPPathPattern(ctx)
 	return pattern, ok
 }
+
+// WithPaymentErrorHandler returns a ServeMuxOption that installs PaymentErrorHandler
+// in front of runtime.DefaultHTTPErrorHandler.
+func WithPaymentErrorHandler() runtime.ServeMuxOption {
+	return runtime.WithErrorHandler(PaymentErrorHandler(runtime.DefaultHTTPErrorHandler))
+}
+
+// PaymentErrorHandler wraps a grpc-gateway error handler so x402 payment challenges
+// from the gRPC interceptors (RESOURCE_EXHAUSTED with encoded requirements) become
+// HTTP 402 responses with a PAYMENT-REQUIRED header and JSON body, instead of an
+// opaque 429. Other errors are passed to next, with any payment-response trailer
+// copied to the PAYMENT-RESPONSE header.
+func PaymentErrorHandler(next runtime.ErrorHandlerFunc) runtime.ErrorHandlerFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+		if paymentReq, ok := paymentRequiredFromStatus(err); ok {
+			w.Header().Del("Trailer")
+			copyPaymentResponseTrailer(ctx, w.Header())
+			writePaymentRequiredResponse(w, paymentReq)
+			return
+		}
+
+		// The default handler forwards trailers itself to clients that accept them.
+		if !strings.Contains(strings.ToLower(r.Header.Get("TE")), "trailers") {
+			copyPaymentResponseTrailer(ctx, w.Header())
+		}
+		next(ctx, mux, marshaler, w, r, err)
+	}
+}
+
+// paymentRequiredFromStatus decodes the requirements carried by an x402 payment
+// challenge status.
+func paymentRequiredFromStatus(err error) (*PaymentRequiredResponse, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	return PaymentRequiredFromStatus(st)
+}
+
+// WithPaymentResponseTrailer returns a ServeMuxOption that exposes the gRPC
+// payment-response trailer as the PAYMENT-RESPONSE (or X-PAYMENT-RESPONSE) HTTP
+// header. It installs PaymentTrailerMatcher as the outgoing trailer matcher, for
+// clients that accept HTTP trailers, and copies the receipt into the response
+// headers for everyone else.
+func WithPaymentResponseTrailer() runtime.ServeMuxOption {
+	return func(mux *runtime.ServeMux) {
+		runtime.WithOutgoingTrailerMatcher(PaymentTrailerMatcher)(mux)
+		runtime.WithForwardResponseOption(forwardPaymentResponse)(mux)
+	}
+}
+
+// PaymentTrailerMatcher is a runtime.HeaderMatcherFunc for outgoing trailers. It
+// maps the x402 payment-response trailers to their HTTP header names and keeps
+// the gateway's default "Grpc-Trailer-" prefix for everything else.
+func PaymentTrailerMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case trailerPaymentResponse:
+		return HeaderPaymentResponse, true
+	case trailerLegacyPaymentResponse:
+		return HeaderLegacyPaymentResponse, true
+	default:
+		return runtime.MetadataTrailerPrefix + key, true
+	}
+}
+
+func forwardPaymentResponse(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	copyPaymentResponseTrailer(ctx, w.Header())
+	return nil
+}
+
+// copyPaymentResponseTrailer sets the PAYMENT-RESPONSE header from the gRPC
+// trailer, unless the gateway is already forwarding it as an HTTP trailer.
+func copyPaymentResponseTrailer(ctx context.Context, header http.Header) {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	for key, name := range map[string]string{
+		trailerPaymentResponse:       HeaderPaymentResponse,
+		trailerLegacyPaymentResponse: HeaderLegacyPaymentResponse,
+	} {
+		values := md.TrailerMD.Get(key)
+		if len(values) == 0 || header.Get(name) != "" || declaresTrailer(header, name) {
+			continue
+		}
+		header.Set(name, values[0])
+	}
+}
+
+func declaresTrailer(header http.Header, name string) bool {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	for _, declared := range header.Values("Trailer") {
+		if textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(declared)) == name {
+			return true
+		}
+	}
+	return false
+}
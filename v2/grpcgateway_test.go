@@ -0,0 +1,405 @@
+package x402
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func newGatewayMux(t *testing.T, cfg Config) *runtime.ServeMux {
+	t.Helper()
+
+	mux := runtime.NewServeMux(runtime.WithMiddlewares(GatewayMiddleware(cfg)))
+	handler := func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if payment, ok := GetPaymentFromContext(r.Context()); ok && payment.Verified {
+			w.Header().Set("X-Paid-By", payment.PayerAddress)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	for _, route := range []struct{ method, pattern string }{
+		{http.MethodGet, "/v1/items/{id}"},
+		{http.MethodDelete, "/v1/items/{id}"},
+		{http.MethodGet, "/v1/shelves/{shelf=shelves/*}/books"},
+		{http.MethodGet, "/v1/free"},
+	} {
+		if err := mux.HandlePath(route.method, route.pattern, handler); err != nil {
+			t.Fatalf("failed to register %s %s: %v", route.method, route.pattern, err)
+		}
+	}
+	return mux
+}
+
+func TestGatewayMiddleware_PricesByGRPCMethod(t *testing.T) {
+	cfg := testConfig()
+	cfg.EndpointPricing = nil
+	cfg.MethodPricing = map[string]PricingRule{
+		"/test.v1.Items/GetItem": testConfig().EndpointPricing["/v1/paid"],
+	}
+	cfg.GatewayRoutes = map[string]string{
+		"GET /v1/items/{id}":    "/test.v1.Items/GetItem",
+		"DELETE /v1/items/{id}": "/test.v1.Items/DeleteItem",
+	}
+	mux := newGatewayMux(t, cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/items/42", nil))
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/items/42", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with payment, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Paid-By") == "" {
+		t.Error("expected payment context to reach the gateway handler")
+	}
+	if rec.Header().Get(HeaderPaymentResponse) == "" {
+		t.Error("expected PAYMENT-RESPONSE header")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/items/42", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected unpriced gRPC method to be free, got %d", rec.Code)
+	}
+}
+
+func TestGatewayMiddleware_AccessTokenForGRPCMethod(t *testing.T) {
+	var verifies int
+	cfg := accessConfig(&verifies)
+	cfg.EndpointPricing = nil
+	cfg.MethodPricing = map[string]PricingRule{
+		"/test.v1.Items/GetItem": accessConfig(&verifies).EndpointPricing["/v1/premium/*"],
+	}
+	cfg.GatewayRoutes = map[string]string{"GET /v1/items/{id}": "/test.v1.Items/GetItem"}
+	mux := newGatewayMux(t, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/items/42", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with payment, got %d: %s", rec.Code, rec.Body.String())
+	}
+	response, err := DecodePaymentResponse(rec.Header().Get(HeaderPaymentResponse))
+	if err != nil || response.AccessToken == "" {
+		t.Fatalf("expected an access token in the payment response, got %+v (%v)", response, err)
+	}
+
+	// The token names the gRPC method, and admits later gateway requests.
+	req = httptest.NewRequest(http.MethodGet, "/v1/items/7", nil)
+	req.Header.Set(HeaderAccessToken, response.AccessToken)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected token to admit the request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if verifies != 1 {
+		t.Errorf("expected no verification for the token request, got %d verifications", verifies)
+	}
+}
+
+func TestGatewayMiddleware_PricesByRouteTemplate(t *testing.T) {
+	cfg := testConfig()
+	cfg.EndpointPricing = map[string]PricingRule{
+		"GET /v1/shelves/{name=shelves/*}/books": cfg.EndpointPricing["/v1/paid"],
+	}
+	mux := newGatewayMux(t, cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/shelves/shelves/7/books", nil))
+	if rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected 402 for templated route, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/free", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected free route to pass, got %d", rec.Code)
+	}
+}
+
+func TestMatchGatewayRoute(t *testing.T) {
+	cfg := testConfig()
+	cfg.MethodPricing = map[string]PricingRule{
+		"/test.v1.Items/*": cfg.EndpointPricing["/v1/paid"],
+	}
+	cfg.GatewayRoutes = map[string]string{
+		"/v1/items/{id}": "/test.v1.Items/GetItem",
+	}
+	cfg.SkipMethods = []string{"/test.v1.Items/Health"}
+	cfg.GatewayRoutes["GET /v1/health"] = "/test.v1.Items/Health"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := cfg.MatchGatewayRoute(http.MethodGet, "/v1/items/{id=*}", "/v1/items/1"); !ok {
+		t.Error("expected gateway template to resolve to priced gRPC method")
+	}
+	if _, ok := cfg.MatchGatewayRoute(http.MethodGet, "/v1/health", "/v1/health"); ok {
+		t.Error("expected skipped gRPC method to be free")
+	}
+	if _, ok := cfg.MatchGatewayRoute(http.MethodGet, "", "/v1/paid"); !ok {
+		t.Error("expected fallback to path matching without a template")
+	}
+}
+
+func TestValidate_InvalidGatewayRoute(t *testing.T) {
+	cfg := testConfig()
+	cfg.GatewayRoutes = map[string]string{"GET /v1/items/{id}": "GetItem"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a method name without a leading slash")
+	}
+}
+
+func paymentChallenge(t *testing.T) error {
+	t.Helper()
+	cfg := testConfig()
+	response := PaymentRequiredResponse{
+		X402Version: 2,
+		Error:       "payment required",
+		Accepts:     buildAcceptsFromRule(&PricingRule{AcceptedTokens: cfg.EndpointPricing["/v1/paid"].AcceptedTokens}, 0),
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status.Error(codes.ResourceExhausted, base64.StdEncoding.EncodeToString(data))
+}
+
+func TestPaymentErrorHandler_PaymentChallenge(t *testing.T) {
+	mux := runtime.NewServeMux(WithPaymentErrorHandler())
+	handler := PaymentErrorHandler(runtime.DefaultHTTPErrorHandler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/items/1", nil)
+	handler(context.Background(), mux, &runtime.JSONPb{}, rec, req, paymentChallenge(t))
+
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", rec.Code)
+	}
+	header, err := DecodePaymentRequirements(rec.Header().Get(HeaderPaymentRequired))
+	if err != nil {
+		t.Fatalf("failed to decode PAYMENT-REQUIRED header: %v", err)
+	}
+	if len(header.Accepts) != 1 || header.Accepts[0].Amount != "1000000" {
+		t.Errorf("unexpected requirements in header: %+v", header.Accepts)
+	}
+
+	var body PaymentRequiredResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body.X402Version != 2 || len(body.Accepts) != 1 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+}
+
+func TestPaymentErrorHandler_OtherErrors(t *testing.T) {
+	mux := runtime.NewServeMux()
+	handler := PaymentErrorHandler(runtime.DefaultHTTPErrorHandler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/items/1", nil)
+	handler(context.Background(), mux, &runtime.JSONPb{}, rec, req, status.Error(codes.ResourceExhausted, "quota exceeded"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected plain RESOURCE_EXHAUSTED to stay 429, got %d", rec.Code)
+	}
+
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		TrailerMD: metadata.Pairs(trailerPaymentResponse, "receipt"),
+	})
+	rec = httptest.NewRecorder()
+	handler(ctx, mux, &runtime.JSONPb{}, rec, req, status.Error(codes.Unavailable, "payment settlement failed"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if got := rec.Header().Get(HeaderPaymentResponse); got != "receipt" {
+		t.Errorf("expected PAYMENT-RESPONSE header from trailer, got %q", got)
+	}
+}
+
+func TestWithPaymentResponseTrailer(t *testing.T) {
+	mux := runtime.NewServeMux(WithPaymentResponseTrailer())
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		TrailerMD: metadata.Pairs(trailerPaymentResponse, "receipt", "other", "x"),
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/items/1", nil)
+	runtime.ForwardResponseMessage(ctx, mux, &runtime.JSONPb{}, rec, req, &emptypb.Empty{}, mux.GetForwardResponseOptions()...)
+	if got := rec.Header().Get(HeaderPaymentResponse); got != "receipt" {
+		t.Errorf("expected PAYMENT-RESPONSE header, got %q", got)
+	}
+
+	// Clients accepting trailers get it as a real trailer instead of a header.
+	rec = httptest.NewRecorder()
+	req.Header.Set("TE", "trailers")
+	runtime.ForwardResponseMessage(ctx, mux, &runtime.JSONPb{}, rec, req, &emptypb.Empty{}, mux.GetForwardResponseOptions()...)
+	if values := rec.Result().Trailer.Values(HeaderPaymentResponse); len(values) != 1 || values[0] != "receipt" {
+		t.Errorf("expected a single PAYMENT-RESPONSE trailer, got %v", values)
+	}
+}
+
+func TestPaymentTrailerMatcher(t *testing.T) {
+	tests := map[string]string{
+		"payment-response":      HeaderPaymentResponse,
+		"x402-payment-response": HeaderLegacyPaymentResponse,
+		"other":                 runtime.MetadataTrailerPrefix + "other",
+	}
+	for key, want := range tests {
+		if got, ok := PaymentTrailerMatcher(key); !ok || got != want {
+			t.Errorf("PaymentTrailerMatcher(%q) = %q, %v; want %q", key, got, ok, want)
+		}
+	}
+}
+
+func TestPaymentHeaderMatcher(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"PAYMENT-SIGNATURE", "payment-signature", true},
+		{"Payment-Signature", "payment-signature", true},
+		{"X-PAYMENT", "x402-payment", true},
+		{"Idempotency-Key", "idempotency-key", true},
+		{"Grpc-Metadata-Trace", "Trace", true},
+		{"X-Unrelated", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := PaymentHeaderMatcher(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PaymentHeaderMatcher(%q) = %q, %v; want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// forwardedMetadata serves req through a gateway mux with payment header
+// forwarding and returns the gRPC metadata the backend would receive.
+func forwardedMetadata(t *testing.T, wrap func(http.Handler) http.Handler, req *http.Request) metadata.MD {
+	t.Helper()
+
+	var md metadata.MD
+	mux := runtime.NewServeMux(WithPaymentHeaderForwarding())
+	err := mux.HandlePath(http.MethodGet, "/v1/paid", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		ctx, err := runtime.AnnotateIncomingContext(r.Context(), mux, r, "/test.v1.Service/Paid")
+		if err != nil {
+			t.Errorf("failed to annotate context: %v", err)
+		}
+		md, _ = metadata.FromIncomingContext(ctx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handler http.Handler = mux
+	if wrap != nil {
+		handler = wrap(mux)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return md
+}
+
+func TestWithPaymentHeaderForwarding(t *testing.T) {
+	header := makeV2PaymentHeader(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, header)
+	md := forwardedMetadata(t, nil, req)
+	if got := md.Get(metadataPaymentSignature); len(got) != 1 || got[0] != header {
+		t.Errorf("expected payment-signature metadata to be forwarded, got %v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/paid", nil)
+	req.Header.Set(HeaderLegacyPayment, "legacy")
+	md = forwardedMetadata(t, nil, req)
+	if got := md.Get(metadataLegacyPayment); len(got) != 1 || got[0] != "legacy" {
+		t.Errorf("expected x402-payment metadata to be forwarded, got %v", got)
+	}
+}
+
+func TestWithPaymentHeaderForwarding_StrippedAfterHTTPSettlement(t *testing.T) {
+	for _, mode := range []SettlementMode{SettleBeforeHandler, SettleOnSuccess} {
+		t.Run(mode.String(), func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SettlementMode = mode
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/paid", nil)
+			req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+			md := forwardedMetadata(t, PaymentMiddleware(cfg), req)
+
+			if got := md.Get(metadataPaymentSignature); len(got) != 0 {
+				t.Errorf("expected settled payment not to reach the backend, got %v", got)
+			}
+			if req.Header.Get(HeaderPaymentSignature) == "" {
+				t.Error("expected the caller's request headers to be left untouched")
+			}
+		})
+	}
+}
+
+func TestWithPaymentHeaderForwarding_StrippedOnOtherAdmissions(t *testing.T) {
+	paid := func(header, value string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/v1/paid", nil)
+		req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return req
+	}
+
+	var verifies int
+	access := accessConfig(&verifies)
+	access.EndpointPricing = map[string]PricingRule{"/v1/paid": access.EndpointPricing["/v1/premium/*"]}
+	w := httptest.NewRecorder()
+	PaymentMiddleware(access)(http.NotFoundHandler()).ServeHTTP(w, paid("", ""))
+	response, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil || response.AccessToken == "" {
+		t.Fatalf("expected an access token, got %+v (%v)", response, err)
+	}
+
+	free := testConfig()
+	free.EndpointPricing["/v1/paid"] = PricingRule{
+		PriceFunc: func(ctx context.Context, req *PriceRequest) ([]TokenRequirement, error) { return nil, nil },
+	}
+
+	idempotent := testConfig()
+	idempotent.IdempotencyStore = NewMemoryIdempotencyStore()
+	replay := PaymentMiddleware(idempotent)
+	forwardedMetadata(t, replay, paid(HeaderIdempotencyKey, "key-1"))
+
+	for _, tc := range []struct {
+		name string
+		wrap func(http.Handler) http.Handler
+		req  *http.Request
+	}{
+		{"access token", PaymentMiddleware(access), paid(HeaderAccessToken, response.AccessToken)},
+		{"free price", PaymentMiddleware(free), paid("", "")},
+		{"idempotent replay", replay, paid(HeaderIdempotencyKey, "key-1")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			md := forwardedMetadata(t, tc.wrap, tc.req)
+			if got := md.Get(metadataPaymentSignature); len(got) != 0 {
+				t.Errorf("expected payment not to reach the backend, got %v", got)
+			}
+		})
+	}
+}
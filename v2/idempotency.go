@@ -0,0 +1,279 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderIdempotencyKey lets a client retry a paid request without paying twice.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// defaultIdempotencyWindow is used when Config.IdempotencyWindow is unset.
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// ErrIdempotencyKeyInUse is returned by IdempotencyStore.Reserve when a key
+// is already reserved by an in-flight request or has a record.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key already in use")
+
+// IdempotencyRecord is what a settled request leaves behind for retries
+// with the same idempotency key.
+type IdempotencyRecord struct {
+	// PaymentKey is the PaymentNonceKey of the settled payment. A retry that
+	// resends that same payment is replayed without verifying it again.
+	PaymentKey string
+
+	// Payment is the context of the settled payment.
+	Payment *PaymentContext
+
+	// Receipt is the payment response sent with the original request.
+	Receipt *PaymentResponse
+
+	// Response is the original HTTP response, if Config.CacheIdempotentResponses is set.
+	Response *CachedResponse
+
+	// Reply is the original unary gRPC response, if Config.CacheIdempotentResponses is set.
+	Reply interface{}
+}
+
+// CachedResponse is a recorded HTTP response.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyStore keeps IdempotencyRecords by idempotency key, and reserves
+// keys while the request that first used them is in flight.
+type IdempotencyStore interface {
+	// Get returns the record stored for key, or nil if there is none or key
+	// is only reserved.
+	Get(ctx context.Context, key string) (*IdempotencyRecord, error)
+
+	// Reserve claims key for ttl. It returns ErrIdempotencyKeyInUse if the
+	// key is already reserved or has a record.
+	Reserve(ctx context.Context, key string, ttl time.Duration) error
+
+	// Put stores record for key for ttl, replacing its reservation or any
+	// earlier record.
+	Put(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+
+	// Release frees a reserved key that has no record, so the request can be
+	// retried. Keys with a record are left alone.
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore with per-key expiry.
+// It is safe for concurrent use but is not shared across processes.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// idempotencyEntry is a record, or a reservation if record is nil.
+type idempotencyEntry struct {
+	record    *IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in-memory idempotency store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the unexpired record for key.
+func (s *MemoryIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || !s.now().Before(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.record, nil
+}
+
+// Reserve claims key for ttl.
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		return ErrIdempotencyKeyInUse
+	}
+
+	s.entries[key] = idempotencyEntry{expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Put stores record for key for ttl.
+func (s *MemoryIdempotencyStore) Put(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	s.entries[key] = idempotencyEntry{record: record, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Release frees key unless it has a record.
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.record == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < nonceSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// ReserveIdempotencyKey looks up an idempotency key before the payment is
+// verified. If an earlier request with key settled this very payment (see
+// IdempotencyRecord.PaymentKey) for resource, its record is returned for
+// replay. If key has no record yet, it is reserved in c.IdempotencyStore for
+// the configured ValidityDuration, so concurrent retries are not charged
+// twice. The returned finish function must be called once the request is
+// done: it releases the key unless a record was stored for it. A retry with
+// a fresh payment must be verified, then checked with IdempotentReplay.
+// Without an IdempotencyStore or key, ReserveIdempotencyKey is a no-op.
+//
+// Another request with key still in flight yields a PaymentError with code
+// ErrCodeIdempotencyConflict.
+func (c *Config) ReserveIdempotencyKey(ctx context.Context, key string, payload *PaymentPayload, resource string) (*IdempotencyRecord, func(), error) {
+	if c.IdempotencyStore == nil || key == "" {
+		return nil, func() {}, nil
+	}
+
+	record, err := c.idempotencyRecord(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record != nil {
+		return resentPayment(record, payload, resource), func() {}, nil
+	}
+
+	if err := c.IdempotencyStore.Reserve(ctx, key, c.ValidityDuration); err != nil {
+		if !errors.Is(err, ErrIdempotencyKeyInUse) {
+			return nil, nil, NewPaymentError(ErrCodeInvalidConfig, "idempotency store error", err)
+		}
+		// The request holding the key may have settled since the lookup.
+		record, err := c.idempotencyRecord(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if record != nil {
+			return resentPayment(record, payload, resource), func() {}, nil
+		}
+		return nil, nil, NewPaymentError(ErrCodeIdempotencyConflict,
+			fmt.Sprintf("a request with idempotency key %q is already in progress", key), err)
+	}
+
+	// Finish outlives the request, so don't let cancellation drop the update.
+	ctx = context.WithoutCancel(ctx)
+	return nil, func() { c.IdempotencyStore.Release(ctx, key) }, nil
+}
+
+// resentPayment returns record if payload is the payment it settled for
+// resource, or nil otherwise.
+func resentPayment(record *IdempotencyRecord, payload *PaymentPayload, resource string) *IdempotencyRecord {
+	if record.PaymentKey == "" || record.PaymentKey != PaymentNonceKey(payload) || record.Payment.Resource != resource {
+		return nil
+	}
+	return record
+}
+
+// IdempotentReplay returns the record left by an earlier settled request
+// with the same idempotency key, or nil if there is none or no
+// IdempotencyStore is configured. payer must be the verified payer of the
+// retry's payment. A record for another payer or resource is an
+// ErrCodeIdempotencyConflict PaymentError.
+func (c *Config) IdempotentReplay(ctx context.Context, key, payer, resource string) (*IdempotencyRecord, error) {
+	if c.IdempotencyStore == nil || key == "" {
+		return nil, nil
+	}
+
+	record, err := c.idempotencyRecord(ctx, key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	if !strings.EqualFold(record.Payment.PayerAddress, payer) || record.Payment.Resource != resource {
+		return nil, NewPaymentError(ErrCodeIdempotencyConflict,
+			fmt.Sprintf("idempotency key %q was used by another payer or resource", key), nil)
+	}
+	return record, nil
+}
+
+// idempotencyRecord returns the record stored for key, if any.
+func (c *Config) idempotencyRecord(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	record, err := c.IdempotencyStore.Get(ctx, key)
+	if err != nil {
+		return nil, NewPaymentError(ErrCodeInvalidConfig, "idempotency store error", err)
+	}
+	if record == nil || record.Payment == nil {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// RememberIdempotent stores record for retries with key for IdempotencyWindow.
+// It does nothing without an IdempotencyStore or key. Store errors are
+// ignored: the payment has already been settled.
+func (c *Config) RememberIdempotent(ctx context.Context, key string, record *IdempotencyRecord) {
+	if c.IdempotencyStore == nil || key == "" {
+		return
+	}
+
+	window := c.IdempotencyWindow
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	_ = c.IdempotencyStore.Put(ctx, key, record, window)
+}
+
+// cachedResponse records a buffered response for replay. Server errors are
+// not cached, so a retry runs the handler again.
+func (b *bufferedResponseWriter) cachedResponse() *CachedResponse {
+	if b.status >= 500 {
+		return nil
+	}
+	return &CachedResponse{
+		StatusCode: b.status,
+		Header:     b.header.Clone(),
+		Body:       append([]byte(nil), b.body.Bytes()...),
+	}
+}
+
+// replayResponse writes a cached response to w.
+func replayResponse(w http.ResponseWriter, response *CachedResponse) {
+	dst := w.Header()
+	for key, values := range response.Header {
+		dst[key] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.Body)
+}
@@ -0,0 +1,332 @@
+package x402
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	record := &IdempotencyRecord{Payment: &PaymentContext{PayerAddress: "0xPayer"}}
+	if err := store.Put(ctx, "key-1", record, time.Minute); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	if got, _ := store.Get(ctx, "key-1"); got != record {
+		t.Errorf("expected stored record, got %+v", got)
+	}
+	if got, _ := store.Get(ctx, "key-2"); got != nil {
+		t.Errorf("expected no record for unknown key, got %+v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got, _ := store.Get(ctx, "key-1"); got != nil {
+		t.Errorf("expected record to expire, got %+v", got)
+	}
+}
+
+func TestMemoryIdempotencyStore_Reserve(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore()
+
+	if err := store.Reserve(ctx, "key-1", time.Minute); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	if err := store.Reserve(ctx, "key-1", time.Minute); !errors.Is(err, ErrIdempotencyKeyInUse) {
+		t.Errorf("expected ErrIdempotencyKeyInUse, got %v", err)
+	}
+	if got, _ := store.Get(ctx, "key-1"); got != nil {
+		t.Errorf("expected no record for a reserved key, got %+v", got)
+	}
+
+	store.Release(ctx, "key-1")
+	if err := store.Reserve(ctx, "key-1", time.Minute); err != nil {
+		t.Errorf("expected released key to be reservable, got %v", err)
+	}
+
+	// A stored record replaces the reservation and survives Release.
+	record := &IdempotencyRecord{Payment: &PaymentContext{}}
+	store.Put(ctx, "key-1", record, time.Minute)
+	store.Release(ctx, "key-1")
+	if got, _ := store.Get(ctx, "key-1"); got != record {
+		t.Errorf("expected record to survive Release, got %+v", got)
+	}
+	if err := store.Reserve(ctx, "key-1", time.Minute); !errors.Is(err, ErrIdempotencyKeyInUse) {
+		t.Errorf("expected a key with a record to stay in use, got %v", err)
+	}
+}
+
+// idempotentServer serves /v1/paid and /v1/other with cfg and counts handler
+// calls and settlements. It keeps the VerifyFunc of cfg's MockVerifier.
+func idempotentServer(cfg Config) (http.Handler, *int, *int) {
+	var calls, settlements int
+	verifier := &MockVerifier{
+		SettleFunc: func(ctx context.Context, payment *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			settlements++
+			return &SettlementResult{TransactionHash: "0xtxhash", Network: requirements.Network, PayerAddress: "0xtest"}, nil
+		},
+	}
+	if mock, ok := cfg.Verifier.(*MockVerifier); ok {
+		verifier.VerifyFunc = mock.VerifyFunc
+	}
+	cfg.Verifier = verifier
+	cfg.EndpointPricing["/v1/other"] = cfg.EndpointPricing["/v1/paid"]
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		payment, _ := GetPaymentFromContext(r.Context())
+		w.Header().Set("X-Transaction", payment.TransactionHash)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "call %d", calls)
+	}))
+	return handler, &calls, &settlements
+}
+
+func idempotentRequest(t *testing.T, handler http.Handler, path, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	req.Header.Set(HeaderIdempotencyKey, key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestPaymentMiddleware_IdempotentRetry(t *testing.T) {
+	for _, mode := range []SettlementMode{SettleBeforeHandler, SettleOnSuccess} {
+		t.Run(mode.String(), func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SettlementMode = mode
+			cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+			handler, calls, settlements := idempotentServer(cfg)
+
+			first := idempotentRequest(t, handler, "/v1/paid", "key-1")
+			retry := idempotentRequest(t, handler, "/v1/paid", "key-1")
+			if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+				t.Fatalf("expected status 201 twice, got %d and %d", first.Code, retry.Code)
+			}
+			if *settlements != 1 {
+				t.Errorf("expected 1 settlement, got %d", *settlements)
+			}
+			// Without cached responses the handler runs again with the original payment.
+			if *calls != 2 || retry.Header().Get("X-Transaction") != "0xtxhash" {
+				t.Errorf("expected handler to rerun with the settled payment, got %d calls", *calls)
+			}
+			if retry.Header().Get(HeaderPaymentResponse) == "" {
+				t.Error("expected PAYMENT-RESPONSE header on replay")
+			}
+
+			idempotentRequest(t, handler, "/v1/paid", "key-2")
+			if *settlements != 2 {
+				t.Errorf("expected a new key to settle again, got %d settlements", *settlements)
+			}
+		})
+	}
+}
+
+func TestPaymentMiddleware_IdempotentRetryWithNonceStore(t *testing.T) {
+	cfg := testConfig()
+	cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+	cfg.NonceStore = NewMemoryNonceStore()
+	handler, calls, settlements := idempotentServer(cfg)
+
+	// The retry resends the very same payment, whose nonce is now spent.
+	header := makeV2PaymentHeader(t)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/v1/paid", nil)
+		req.Header.Set(HeaderPaymentSignature, header)
+		req.Header.Set(HeaderIdempotencyKey, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("request %d: expected status 201, got %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+	if *settlements != 1 || *calls != 2 {
+		t.Errorf("expected 1 settlement and 2 calls, got %d and %d", *settlements, *calls)
+	}
+
+	// Without a key, resending the payment is still a duplicate.
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, header)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), ErrCodeDuplicatePayment) {
+		t.Errorf("expected %s, got %d: %s", ErrCodeDuplicatePayment, w.Code, w.Body)
+	}
+}
+
+// resignedPaymentHeader returns a payment like makeV2PaymentHeader's with
+// another nonce and signature.
+func resignedPaymentHeader(t *testing.T, nonce, signature string) string {
+	t.Helper()
+	payload, err := parsePaymentPayload(makeV2PaymentHeader(t))
+	if err != nil {
+		t.Fatalf("failed to parse payment: %v", err)
+	}
+	fields := payload.Payload.(map[string]interface{})
+	fields["signature"] = signature
+	fields["authorization"].(map[string]interface{})["nonce"] = nonce
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to marshal payment: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(payloadJSON)
+}
+
+func TestPaymentMiddleware_IdempotentRetryIsVerified(t *testing.T) {
+	cfg := testConfig()
+	cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+	cfg.Verifier = &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			if payload.Payload.(map[string]interface{})["signature"] == "0xforged" {
+				return &VerificationResult{Valid: false, Reason: "invalid signature"}, nil
+			}
+			return &VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: requirements.Amount}, nil
+		},
+	}
+	handler, calls, settlements := idempotentServer(cfg)
+
+	idempotentRequest(t, handler, "/v1/paid", "key-1")
+
+	send := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/paid", nil)
+		req.Header.Set(HeaderPaymentSignature, header)
+		req.Header.Set(HeaderIdempotencyKey, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Knowing the key and the payer's address is not enough to replay.
+	if w := send(resignedPaymentHeader(t, "0xnonce456", "0xforged")); w.Code != http.StatusPaymentRequired {
+		t.Errorf("expected a forged payment to get 402, got %d", w.Code)
+	}
+	if *calls != 1 {
+		t.Errorf("expected the forged retry not to reach the handler, got %d calls", *calls)
+	}
+
+	// A fresh payment from the same payer replays without settling.
+	if w := send(resignedPaymentHeader(t, "0xnonce456", "0xsig456")); w.Code != http.StatusCreated {
+		t.Errorf("expected a verified retry to replay, got %d", w.Code)
+	}
+	if *calls != 2 || *settlements != 1 {
+		t.Errorf("expected 2 calls and 1 settlement, got %d and %d", *calls, *settlements)
+	}
+}
+
+func TestPaymentMiddleware_IdempotentConcurrentRetry(t *testing.T) {
+	var handler http.Handler
+	var concurrent *httptest.ResponseRecorder
+	settlements := 0
+
+	cfg := testConfig()
+	cfg.SettlementMode = SettleOnSuccess
+	cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+	cfg.Verifier = &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			settlements++
+			return &SettlementResult{TransactionHash: "0xtxhash", Network: requirements.Network}, nil
+		},
+	}
+	handler = PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if concurrent == nil {
+			// Retry with the same key while the first request is in flight.
+			concurrent = idempotentRequest(t, handler, "/v1/paid", "key-1")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	first := idempotentRequest(t, handler, "/v1/paid", "key-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected first request to succeed, got %d", first.Code)
+	}
+	if concurrent.Code != http.StatusConflict || !strings.Contains(concurrent.Body.String(), ErrCodeIdempotencyConflict) {
+		t.Errorf("expected in-flight retry to get 409 %s, got %d: %s", ErrCodeIdempotencyConflict, concurrent.Code, concurrent.Body)
+	}
+	if settlements != 1 {
+		t.Errorf("expected 1 settlement, got %d", settlements)
+	}
+
+	// Once the first request has settled, a retry replays it.
+	retry := idempotentRequest(t, handler, "/v1/paid", "key-1")
+	if retry.Code != http.StatusOK || settlements != 1 {
+		t.Errorf("expected replay without settlement, got %d after %d settlements", retry.Code, settlements)
+	}
+}
+
+func TestPaymentMiddleware_IdempotencyKeyReleasedOnFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+	cfg.Verifier = &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			return &VerificationResult{Valid: false, Reason: "insufficient funds"}, nil
+		},
+	}
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	idempotentRequest(t, handler, "/v1/paid", "key-1")
+	if err := cfg.IdempotencyStore.Reserve(context.Background(), "key-1", time.Minute); err != nil {
+		t.Errorf("expected the key to be released after a failed payment, got %v", err)
+	}
+}
+
+func TestPaymentMiddleware_IdempotentCachedResponse(t *testing.T) {
+	for _, mode := range []SettlementMode{SettleBeforeHandler, SettleOnSuccess} {
+		t.Run(mode.String(), func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SettlementMode = mode
+			cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+			cfg.CacheIdempotentResponses = true
+			handler, calls, settlements := idempotentServer(cfg)
+
+			first := idempotentRequest(t, handler, "/v1/paid", "key-1")
+			retry := idempotentRequest(t, handler, "/v1/paid", "key-1")
+			if *calls != 1 || *settlements != 1 {
+				t.Fatalf("expected 1 call and 1 settlement, got %d and %d", *calls, *settlements)
+			}
+			if retry.Code != first.Code || retry.Body.String() != first.Body.String() ||
+				retry.Header().Get("X-Transaction") != first.Header().Get("X-Transaction") {
+				t.Errorf("expected replayed response %d %q, got %d %q", first.Code, first.Body, retry.Code, retry.Body)
+			}
+		})
+	}
+}
+
+func TestPaymentMiddleware_IdempotencyConflict(t *testing.T) {
+	cfg := testConfig()
+	cfg.IdempotencyStore = NewMemoryIdempotencyStore()
+	handler, _, settlements := idempotentServer(cfg)
+
+	idempotentRequest(t, handler, "/v1/paid", "key-1")
+	w := idempotentRequest(t, handler, "/v1/other", "key-1")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrCodeIdempotencyConflict) {
+		t.Errorf("expected %s in body, got %s", ErrCodeIdempotencyConflict, w.Body)
+	}
+	if *settlements != 1 {
+		t.Errorf("expected 1 settlement, got %d", *settlements)
+	}
+
+	// Another payer reusing the key is also a conflict.
+	record, err := cfg.IdempotentReplay(context.Background(), "key-1", "0xOther", "/v1/paid")
+	if record != nil || GetPaymentErrorCode(err) != ErrCodeIdempotencyConflict {
+		t.Errorf("expected conflict for another payer, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package x402
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderRequestID is the request header whose value is recorded as the
+// request ID of ledger records.
+const HeaderRequestID = "X-Request-Id"
+
+// LedgerRecord is a durable record of one settled payment.
+type LedgerRecord struct {
+	Payer       string    `json:"payer"`
+	Amount      string    `json:"amount"` // Atomic units
+	Asset       string    `json:"asset"`
+	TokenSymbol string    `json:"tokenSymbol,omitempty"`
+	Network     string    `json:"network"` // CAIP-2
+	Transaction string    `json:"transaction,omitempty"`
+	Scheme      string    `json:"scheme,omitempty"`
+	Resource    string    `json:"resource"` // HTTP path or full gRPC method
+	RequestID   string    `json:"requestId,omitempty"`
+	Time        time.Time `json:"time"`
+
+	// Credit is set when the amount was debited from the payer's prepaid
+	// balance instead of settled on-chain (see PricingRule.Credits).
+	Credit bool `json:"credit,omitempty"`
+
+	// Error is set when a payment queued on a Settler failed to settle.
+	Error string `json:"error,omitempty"`
+}
+
+// LedgerQuery selects ledger records. Zero fields match everything.
+type LedgerQuery struct {
+	// Payer matches the payer address, ignoring case.
+	Payer string
+
+	// Resource matches the HTTP path or full gRPC method exactly.
+	Resource string
+
+	// Since and Until bound the record time to [Since, Until).
+	Since time.Time
+	Until time.Time
+
+	// Limit caps the number of records returned, oldest first.
+	Limit int
+}
+
+// Matches reports whether record is selected by the query.
+func (q *LedgerQuery) Matches(record *LedgerRecord) bool {
+	if q.Payer != "" && !strings.EqualFold(q.Payer, record.Payer) {
+		return false
+	}
+	if q.Resource != "" && q.Resource != record.Resource {
+		return false
+	}
+	if !q.Since.IsZero() && record.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !record.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Ledger records every settled payment (see Config.Ledger) and answers
+// queries for reconciliation.
+type Ledger interface {
+	// Record stores a settled payment.
+	Record(ctx context.Context, record LedgerRecord) error
+
+	// Query returns the records matching q in the order they were recorded.
+	Query(ctx context.Context, q LedgerQuery) ([]LedgerRecord, error)
+}
+
+// MemoryLedger is an in-memory Ledger. It is safe for concurrent use, but
+// records are lost on restart.
+type MemoryLedger struct {
+	mu      sync.Mutex
+	records []LedgerRecord
+}
+
+// NewMemoryLedger creates an empty in-memory ledger.
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{}
+}
+
+// Record stores record.
+func (l *MemoryLedger) Record(ctx context.Context, record LedgerRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record)
+	return nil
+}
+
+// Query returns the records matching q.
+func (l *MemoryLedger) Query(ctx context.Context, q LedgerQuery) ([]LedgerRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []LedgerRecord
+	for i := range l.records {
+		if q.Limit > 0 && len(matched) == q.Limit {
+			break
+		}
+		if q.Matches(&l.records[i]) {
+			matched = append(matched, l.records[i])
+		}
+	}
+	return matched, nil
+}
+
+// FileLedger is a Ledger that appends records to a file as JSON lines. It is
+// safe for concurrent use within one process; Query reads the whole file.
+type FileLedger struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileLedger opens, creating it if needed, the JSON-lines ledger at path.
+func NewFileLedger(path string) (*FileLedger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	return &FileLedger{path: path, file: f}, nil
+}
+
+// Record appends record to the file and syncs it to disk.
+func (l *FileLedger) Record(ctx context.Context, record LedgerRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write ledger record: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync ledger: %w", err)
+	}
+	return nil
+}
+
+// Query reads the file and returns the records matching q.
+func (l *FileLedger) Query(ctx context.Context, q LedgerQuery) ([]LedgerRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+
+	var matched []LedgerRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if q.Limit > 0 && len(matched) == q.Limit {
+			break
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record LedgerRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", l.path, line, err)
+		}
+		if q.Matches(&record) {
+			matched = append(matched, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return matched, nil
+}
+
+// Close closes the ledger file.
+func (l *FileLedger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// RecordSettlement writes a settled payment to the Ledger, if one is
+// configured. A failure to record does not undo the payment; it is reported
+// to OnLedgerError.
+func (c *Config) RecordSettlement(ctx context.Context, requirements *PaymentRequirements, payment *PaymentContext, result *SettlementResult) {
+	if c.Ledger == nil {
+		return
+	}
+	c.writeLedger(ctx, settlementRecord(requirements, payment, result))
+}
+
+// recordSettlementFailure writes a queued payment that failed to settle to
+// the Ledger, if one is configured.
+func (c *Config) recordSettlementFailure(ctx context.Context, requirements *PaymentRequirements, payment *PaymentContext, err error) {
+	if c.Ledger == nil {
+		return
+	}
+	record := settlementRecord(requirements, payment, &SettlementResult{})
+	record.Error = err.Error()
+	c.writeLedger(ctx, record)
+}
+
+func (c *Config) writeLedger(ctx context.Context, record LedgerRecord) {
+	if err := c.Ledger.Record(ctx, record); err != nil && c.OnLedgerError != nil {
+		c.OnLedgerError(record, err)
+	}
+}
+
+func settlementRecord(requirements *PaymentRequirements, payment *PaymentContext, result *SettlementResult) LedgerRecord {
+	amount := result.Amount
+	if amount == "" && payment.Scheme == SchemeUpto {
+		amount = payment.UsedAmount()
+	} else if amount == "" {
+		amount = requirements.Amount
+	}
+	settledAt := result.SettledAt
+	if settledAt.IsZero() {
+		settledAt = time.Now()
+	}
+
+	return LedgerRecord{
+		Payer:       payment.PayerAddress,
+		Amount:      amount,
+		Asset:       requirements.Asset,
+		TokenSymbol: payment.TokenSymbol,
+		Network:     requirements.Network,
+		Transaction: result.TransactionHash,
+		Scheme:      requirements.Scheme,
+		Resource:    payment.Resource,
+		RequestID:   payment.RequestID,
+		Time:        settledAt,
+		Credit:      result.Status == settlementStatusCredit,
+	}
+}
@@ -0,0 +1,125 @@
+package x402
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ledgerRecords() []LedgerRecord {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []LedgerRecord{
+		{Payer: "0xAlice", Amount: "100", Resource: "/v1/a", Time: base},
+		{Payer: "0xBob", Amount: "200", Resource: "/v1/a", Time: base.Add(time.Hour)},
+		{Payer: "0xalice", Amount: "300", Resource: "/v1/b", Time: base.Add(2 * time.Hour)},
+	}
+}
+
+func testLedgerQueries(t *testing.T, ledger Ledger) {
+	t.Helper()
+	ctx := context.Background()
+	for _, record := range ledgerRecords() {
+		if err := ledger.Record(ctx, record); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+
+	base := ledgerRecords()[0].Time
+	tests := []struct {
+		name  string
+		query LedgerQuery
+		want  []string
+	}{
+		{"all", LedgerQuery{}, []string{"100", "200", "300"}},
+		{"payer ignores case", LedgerQuery{Payer: "0xALICE"}, []string{"100", "300"}},
+		{"resource", LedgerQuery{Resource: "/v1/a"}, []string{"100", "200"}},
+		{"time range", LedgerQuery{Since: base.Add(time.Hour), Until: base.Add(2 * time.Hour)}, []string{"200"}},
+		{"limit", LedgerQuery{Limit: 2}, []string{"100", "200"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := ledger.Query(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("expected %d records, got %d", len(tt.want), len(records))
+			}
+			for i, record := range records {
+				if record.Amount != tt.want[i] {
+					t.Errorf("record %d: expected amount %s, got %s", i, tt.want[i], record.Amount)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLedger(t *testing.T) {
+	testLedgerQueries(t, NewMemoryLedger())
+}
+
+func TestFileLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, err := NewFileLedger(path)
+	if err != nil {
+		t.Fatalf("failed to open ledger: %v", err)
+	}
+	testLedgerQueries(t, ledger)
+	if err := ledger.Close(); err != nil {
+		t.Fatalf("failed to close ledger: %v", err)
+	}
+
+	// Records survive reopening, and new ones are appended.
+	reopened, err := NewFileLedger(path)
+	if err != nil {
+		t.Fatalf("failed to reopen ledger: %v", err)
+	}
+	defer reopened.Close()
+	if err := reopened.Record(context.Background(), LedgerRecord{Payer: "0xCarol", Amount: "400"}); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	records, err := reopened.Query(context.Background(), LedgerQuery{})
+	if err != nil || len(records) != 4 {
+		t.Fatalf("expected 4 records after reopening, got %d (%v)", len(records), err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("expected 4 JSON lines, got %d", lines)
+	}
+}
+
+func TestPaymentMiddleware_RecordsSettlement(t *testing.T) {
+	ledger := NewMemoryLedger()
+	cfg := testConfig()
+	cfg.Ledger = ledger
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	req.Header.Set(HeaderRequestID, "req-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	records, _ := ledger.Query(context.Background(), LedgerQuery{Resource: "/v1/paid"})
+	if len(records) != 1 {
+		t.Fatalf("expected 1 ledger record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Payer != "0xtest" || record.Amount != "1000000" || record.Transaction != "0xtxhash" ||
+		record.RequestID != "req-42" || record.Network != "eip155:84532" || record.Time.IsZero() {
+		t.Errorf("unexpected ledger record: %+v", record)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"strings"
 	"time"
+
+	"google.golang.org/grpc/codes"
 )
 
 // V2 header names.
@@ -25,115 +28,315 @@ const (
 // PaymentMiddleware creates HTTP middleware that enforces x402 payment requirements.
 // It detects V2 headers (PAYMENT-SIGNATURE) first and falls back to V1 (X-PAYMENT).
 func PaymentMiddleware(cfg Config) func(http.Handler) http.Handler {
-	if err := cfg.Validate(); err != nil {
+	source, err := NewAtomicConfig(cfg)
+	if err != nil {
 		panic(fmt.Sprintf("invalid x402 middleware configuration: %v", err))
 	}
 
+	return PaymentMiddlewareFromSource(source)
+}
+
+// PaymentMiddlewareFromSource is like PaymentMiddleware but reads the configuration
+// from source on every request, so pricing changes take effect without a restart.
+func PaymentMiddlewareFromSource(source ConfigSource) func(http.Handler) http.Handler {
+	if source == nil || source.Config() == nil {
+		panic("invalid x402 middleware configuration: config source has no configuration")
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := r.Context()
+			cfg := source.Config()
 
-			rule, requiresPayment := cfg.MatchEndpoint(r.URL.Path)
+			rule, requiresPayment := cfg.MatchRequest(r.Method, r.URL.Path)
 			if !requiresPayment {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Detect protocol version from headers.
-			// V2: PAYMENT-SIGNATURE, V1 fallback: X-PAYMENT
-			paymentHeader := r.Header.Get(HeaderPaymentSignature)
-			isV2 := true
-			if paymentHeader == "" {
-				paymentHeader = r.Header.Get(HeaderLegacyPayment)
-				isV2 = false
-			}
+			enforcePayment(w, r, cfg, rule, next)
+		})
+	}
+}
 
-			if paymentHeader == "" {
-				sendPaymentRequired(w, r, rule, &cfg)
-				return
-			}
+// enforcePayment runs the x402 flow for a request priced by rule (resolved
+// with Config.Quote, so a PriceFunc sees the request): it answers
+// with 402 until a valid payment is presented, then verifies and settles it
+// according to cfg.SettlementMode and calls next with the PaymentContext.
+func enforcePayment(w http.ResponseWriter, r *http.Request, cfg *Config, rule *PricingRule, next http.Handler) {
+	ctx := r.Context()
+
+	// A valid access token bought by an earlier payment admits the request,
+	// whichever rule prices it: the token's patterns decide what it covers.
+	// Gateway routes priced as a gRPC method are checked against the method,
+	// as that is what the token's patterns name.
+	if token := r.Header.Get(HeaderAccessToken); token != "" {
+		method, resource := r.Method, r.URL.Path
+		if rule.fullMethod != "" {
+			method, resource = "", rule.fullMethod
+		}
+		if paymentCtx, err := cfg.VerifyAccessToken(token, method, resource); err == nil {
+			next.ServeHTTP(w, withoutPaymentHeaders(r, context.WithValue(ctx, PaymentContextKey, paymentCtx)))
+			return
+		}
+	}
 
-			// Default requirements from first accepted token (used for V1 legacy).
-			requirements := buildRequirementsFromRule(rule)
+	rule, err := cfg.Quote(ctx, rule, &PriceRequest{HTTPRequest: r})
+	if err != nil {
+		code := GetPaymentErrorCode(err)
+		statusCode := http.StatusInternalServerError
+		if GRPCCode(code) == codes.InvalidArgument {
+			statusCode = http.StatusBadRequest
+		}
+		sendError(w, statusCode, code, err.Error())
+		return
+	}
+	if len(rule.AcceptedTokens) == 0 {
+		next.ServeHTTP(w, withoutPaymentHeaders(r, ctx))
+		return
+	}
 
-			// Parse payment header.
-			var payload *PaymentPayload
-			var err error
-			if isV2 {
-				payload, err = parsePaymentPayload(paymentHeader)
-			} else {
-				payload, err = parseLegacyPayment(paymentHeader, requirements)
-			}
-			if err != nil {
-				sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid payment header: %v", err))
-				return
-			}
+	// Detect protocol version from headers.
+	// V2: PAYMENT-SIGNATURE, V1 fallback: X-PAYMENT
+	paymentHeader := r.Header.Get(HeaderPaymentSignature)
+	isV2 := true
+	if paymentHeader == "" {
+		paymentHeader = r.Header.Get(HeaderLegacyPayment)
+		isV2 = false
+	}
 
-			// For V2, match the client's chosen token against the rule's accepted tokens
-			// so requirements/symbol are correct for multi-token rules.
-			var tokenSymbol string
-			if isV2 && payload != nil {
-				if matched, symbol := MatchClientToken(rule, payload); matched != nil {
-					requirements = matched
-					tokenSymbol = symbol
-				}
-			}
+	if paymentHeader == "" {
+		sendPaymentRequired(w, r, rule, cfg)
+		return
+	}
 
-			// Verify the payment.
-			verifyResult, err := cfg.Verifier.Verify(ctx, payload, requirements)
-			if err != nil {
-				sendError(w, http.StatusInternalServerError, fmt.Sprintf("Payment verification error: %v", err))
-				return
-			}
+	// Default requirements from first accepted token (used for V1 legacy).
+	requirements := buildRequirementsFromRule(rule)
 
-			if !verifyResult.Valid {
-				sendPaymentRequired(w, r, rule, &cfg)
-				return
-			}
+	// Parse payment header.
+	var payload *PaymentPayload
+	if isV2 {
+		payload, err = parsePaymentPayload(paymentHeader)
+	} else {
+		payload, err = parseLegacyPayment(paymentHeader, requirements)
+	}
+	if err != nil {
+		sendError(w, http.StatusBadRequest, ErrCodeInvalidPayment, fmt.Sprintf("Invalid payment header: %v", err))
+		return
+	}
 
-			// Settle the payment on-chain.
-			settlementResult, err := cfg.Verifier.Settle(ctx, payload, requirements)
-			if err != nil {
-				sendError(w, http.StatusInternalServerError, fmt.Sprintf("Payment settlement error: %v", err))
-				return
-			}
+	// Match the client's chosen token against the rule's accepted tokens
+	// so requirements/symbol are correct for multi-token rules.
+	var tokenSymbol string
+	if isV2 {
+		requirements, tokenSymbol, err = cfg.ResolveRequirements(rule, payload, requirements)
+		if err != nil {
+			sendPaymentRejected(w, r, rule, cfg, err)
+			return
+		}
+	}
 
-			// Resolve token symbol from rule match or verifier.
-			if tokenSymbol == "" {
-				tokenSymbol = verifyResult.TokenSymbol
-			}
+	// A retry that resends the payment of a request that was already
+	// settled replays its result. Otherwise the key is held while this
+	// request is in flight, so concurrent retries are not charged twice.
+	idempotencyKey := r.Header.Get(HeaderIdempotencyKey)
+	paymentKey := PaymentNonceKey(payload)
+	record, finishKey, err := cfg.ReserveIdempotencyKey(ctx, idempotencyKey, payload, r.URL.Path)
+	if err != nil {
+		sendIdempotencyError(w, err)
+		return
+	}
+	defer finishKey()
+	if record != nil {
+		replayIdempotent(w, r, record, isV2, next)
+		return
+	}
 
-			// Create payment context for downstream handlers.
-			paymentCtx := &PaymentContext{
-				Verified:        true,
-				PayerAddress:    verifyResult.PayerAddress,
-				Amount:          verifyResult.Amount,
-				TokenSymbol:     tokenSymbol,
-				Network:         requirements.Network,
-				TransactionHash: settlementResult.TransactionHash,
-				SettledAt:       settlementResult.SettledAt,
-			}
+	// Reserve the payment nonce so concurrent duplicates never reach the verifier.
+	finishNonce, err := cfg.ReservePaymentNonce(ctx, payload)
+	if err != nil {
+		switch code := GetPaymentErrorCode(err); code {
+		case ErrCodeDuplicatePayment:
+			sendError(w, http.StatusConflict, code, "Payment has already been submitted")
+		case ErrCodeInvalidPayment:
+			sendError(w, http.StatusBadRequest, code, fmt.Sprintf("Invalid payment: %v", err))
+		default:
+			sendError(w, http.StatusInternalServerError, code, fmt.Sprintf("Payment nonce error: %v", err))
+		}
+		return
+	}
+	settled := false
+	defer func() { finishNonce(settled) }()
 
-			ctx = context.WithValue(ctx, PaymentContextKey, paymentCtx)
+	// Verify the payment.
+	verifyResult, err := cfg.Verifier.Verify(ctx, payload, requirements)
+	if err != nil {
+		sendPaymentFailure(w, r, rule, cfg, err, ErrCodeVerificationFailed, "Payment verification error")
+		return
+	}
 
-			// Set response headers (version-aware).
-			paymentResponse := PaymentResponse{
-				Success:     true,
-				Transaction: settlementResult.TransactionHash,
-				Network:     settlementResult.Network,
-				Payer:       settlementResult.PayerAddress,
-			}
-			if responseJSON, err := json.Marshal(paymentResponse); err == nil {
-				encoded := base64.StdEncoding.EncodeToString(responseJSON)
-				if isV2 {
-					w.Header().Set(HeaderPaymentResponse, encoded)
-				} else {
-					w.Header().Set(HeaderLegacyPaymentResponse, encoded)
-				}
-			}
+	if !verifyResult.Valid {
+		sendPaymentRejected(w, r, rule, cfg, invalidPayment(verifyResult))
+		return
+	}
 
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
+	// A retry with a fresh payment from the same payer replays without
+	// settling it.
+	record, err = cfg.IdempotentReplay(ctx, idempotencyKey, verifyResult.PayerAddress, r.URL.Path)
+	if err != nil {
+		sendIdempotencyError(w, err)
+		return
+	}
+	if record != nil {
+		replayIdempotent(w, r, record, isV2, next)
+		return
+	}
+
+	// Resolve token symbol from rule match or verifier.
+	if tokenSymbol == "" {
+		tokenSymbol = verifyResult.TokenSymbol
+	}
+
+	// Create payment context for downstream handlers.
+	paymentCtx := NewPaymentContext(verifyResult, requirements)
+	paymentCtx.TokenSymbol = tokenSymbol
+	paymentCtx.Resource = r.URL.Path
+	paymentCtx.RequestID = r.Header.Get(HeaderRequestID)
+
+	// "upto" payments settle what the handler used, so they always settle after it.
+	if cfg.SettlementMode == SettleOnSuccess || requirements.Scheme == SchemeUpto {
+		// Run the handler against a buffer so nothing reaches the client
+		// until we know whether to settle.
+		buf := newBufferedResponseWriter()
+		next.ServeHTTP(buf, withoutPaymentHeaders(r, context.WithValue(ctx, PaymentContextKey, paymentCtx)))
+
+		if !buf.succeeded() {
+			buf.flushTo(w)
+			return
+		}
+
+		settlementResult, err := cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+		if err != nil {
+			setPaymentResponseHeader(w, &PaymentResponse{
+				Success:     false,
+				Network:     requirements.Network,
+				Payer:       verifyResult.PayerAddress,
+				ErrorReason: err.Error(),
+			}, isV2)
+			sendError(w, http.StatusPaymentRequired, settlementErrorCode(err), fmt.Sprintf("Payment settlement error: %v", err))
+			return
+		}
+		if settlementResult == nil {
+			// The "upto" payment was not used, so nothing was charged.
+			buf.flushTo(w)
+			return
+		}
+
+		settled = true
+		paymentCtx.TransactionHash = settlementResult.TransactionHash
+		paymentCtx.SettledAt = settlementResult.SettledAt
+
+		receipt := settlementResponse(settlementResult)
+		record := &IdempotencyRecord{PaymentKey: paymentKey, Payment: paymentCtx, Receipt: receipt}
+		if cfg.CacheIdempotentResponses {
+			record.Response = buf.cachedResponse()
+		}
+		cfg.RememberIdempotent(ctx, idempotencyKey, record)
+
+		setPaymentResponseHeader(w, receipt, isV2)
+		buf.flushTo(w)
+		return
+	}
+
+	// Settle the payment on-chain.
+	settlementResult, err := cfg.SettlePayment(ctx, rule, payload, requirements, paymentCtx)
+	if err != nil {
+		sendPaymentFailure(w, r, rule, cfg, err, ErrCodeSettlementFailed, "Payment settlement error")
+		return
+	}
+
+	settled = true
+	paymentCtx.TransactionHash = settlementResult.TransactionHash
+	paymentCtx.SettledAt = settlementResult.SettledAt
+
+	ctx = context.WithValue(ctx, PaymentContextKey, paymentCtx)
+
+	// Remember the settlement before the handler runs, so a retry after a
+	// dropped connection is not charged again.
+	receipt := settlementResponse(settlementResult)
+	cfg.RememberIdempotent(ctx, idempotencyKey, &IdempotencyRecord{PaymentKey: paymentKey, Payment: paymentCtx, Receipt: receipt})
+
+	// Set response headers (version-aware).
+	setPaymentResponseHeader(w, receipt, isV2)
+
+	if cfg.CacheIdempotentResponses && cfg.IdempotencyStore != nil && idempotencyKey != "" {
+		buf := newBufferedResponseWriter()
+		next.ServeHTTP(buf, withoutPaymentHeaders(r, ctx))
+		cfg.RememberIdempotent(ctx, idempotencyKey, &IdempotencyRecord{PaymentKey: paymentKey, Payment: paymentCtx, Receipt: receipt, Response: buf.cachedResponse()})
+		buf.flushTo(w)
+		return
+	}
+
+	next.ServeHTTP(w, withoutPaymentHeaders(r, ctx))
+}
+
+// replayIdempotent answers a retry with the result of the settled request
+// in record.
+func replayIdempotent(w http.ResponseWriter, r *http.Request, record *IdempotencyRecord, isV2 bool, next http.Handler) {
+	setPaymentResponseHeader(w, record.Receipt, isV2)
+	if record.Response != nil {
+		replayResponse(w, record.Response)
+		return
+	}
+	next.ServeHTTP(w, withoutPaymentHeaders(r, context.WithValue(r.Context(), PaymentContextKey, record.Payment)))
+}
+
+// sendIdempotencyError reports a failed idempotency key lookup.
+func sendIdempotencyError(w http.ResponseWriter, err error) {
+	code := GetPaymentErrorCode(err)
+	statusCode := http.StatusInternalServerError
+	if code == ErrCodeIdempotencyConflict {
+		statusCode = http.StatusConflict
+	}
+	sendError(w, statusCode, code, err.Error())
+}
+
+// withoutPaymentHeaders returns a copy of r with ctx and without the payment
+// headers. Once the HTTP layer has admitted a request, whether by payment,
+// access token, idempotent replay or a free price, a gRPC backend behind the
+// gateway (see WithPaymentHeaderForwarding) must not see a payment and charge
+// it.
+func withoutPaymentHeaders(r *http.Request, ctx context.Context) *http.Request {
+	r = r.WithContext(ctx)
+	r.Header = r.Header.Clone()
+	r.Header.Del(HeaderPaymentSignature)
+	r.Header.Del(HeaderLegacyPayment)
+	return r
+}
+
+// settlementResponse builds the PAYMENT-RESPONSE body for a successful settlement.
+func settlementResponse(result *SettlementResult) *PaymentResponse {
+	return &PaymentResponse{
+		Success:     true,
+		Transaction: result.TransactionHash,
+		Network:     result.Network,
+		Payer:       result.PayerAddress,
+		Balance:     result.Balance,
+		AccessToken: result.AccessToken,
+		Pending:     result.Pending,
+	}
+}
+
+// setPaymentResponseHeader sets PAYMENT-RESPONSE (V2) or X-PAYMENT-RESPONSE (V1).
+func setPaymentResponseHeader(w http.ResponseWriter, response *PaymentResponse, isV2 bool) {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(responseJSON)
+	if isV2 {
+		w.Header().Set(HeaderPaymentResponse, encoded)
+	} else {
+		w.Header().Set(HeaderLegacyPaymentResponse, encoded)
 	}
 }
 
@@ -143,14 +346,8 @@ func buildRequirementsFromRule(rule *PricingRule) *PaymentRequirements {
 	if len(rule.AcceptedTokens) == 0 {
 		return nil
 	}
-	token := rule.AcceptedTokens[0]
-	return &PaymentRequirements{
-		Scheme:  "exact",
-		Network: token.Network,
-		Amount:  token.Amount,
-		Asset:   token.AssetContract,
-		PayTo:   token.Recipient,
-	}
+	requirements := rule.Requirements(rule.AcceptedTokens[0], 0)
+	return &requirements
 }
 
 // MatchClientToken finds the accepted token matching the client's chosen asset+network.
@@ -161,20 +358,99 @@ func MatchClientToken(rule *PricingRule, payload *PaymentPayload) (*PaymentRequi
 
 	for _, token := range rule.AcceptedTokens {
 		if strings.ToLower(token.AssetContract) == clientAsset && token.Network == clientNetwork {
-			return &PaymentRequirements{
-				Scheme:  "exact",
-				Network: token.Network,
-				Amount:  token.Amount,
-				Asset:   token.AssetContract,
-				PayTo:   token.Recipient,
-			}, token.Symbol
+			requirements := rule.Requirements(token, 0)
+			return &requirements, token.Symbol
 		}
 	}
 	return nil, ""
 }
 
+// ResolveRequirements determines the requirements a V2 payment must be verified against.
+// fallback is used when the payload matches no accepted token and StrictPaymentMatching
+// is off.
+//
+// With StrictPaymentMatching, V2 payloads must name an accepted asset and network,
+// and their accepted scheme, payTo, and amount must equal the server's. Violations
+// return a PaymentError with a specific code.
+func (c *Config) ResolveRequirements(rule *PricingRule, payload *PaymentPayload, fallback *PaymentRequirements) (*PaymentRequirements, string, error) {
+	if payload == nil {
+		return fallback, "", nil
+	}
+
+	matched, symbol := MatchClientToken(rule, payload)
+	if matched == nil {
+		if !c.StrictPaymentMatching {
+			return fallback, "", nil
+		}
+		for _, token := range rule.AcceptedTokens {
+			if token.Network == payload.Accepted.Network {
+				return nil, "", NewPaymentError(ErrCodeTokenNotAccepted,
+					fmt.Sprintf("asset %s is not accepted on network %s", payload.Accepted.Asset, payload.Accepted.Network), nil)
+			}
+		}
+		return nil, "", NewPaymentError(ErrCodeNetworkNotSupported,
+			fmt.Sprintf("network %s is not accepted", payload.Accepted.Network), nil)
+	}
+
+	matched = c.lockedQuote(rule, payload, matched)
+
+	if c.StrictPaymentMatching {
+		if err := checkAccepted(&payload.Accepted, matched, rule.Credits); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return matched, symbol, nil
+}
+
+// checkAccepted verifies the client's accepted requirements against the server's.
+// allowSurplus accepts amounts above the required one, for prepaying credit.
+func checkAccepted(accepted, required *PaymentRequirements, allowSurplus bool) error {
+	if accepted.Scheme != required.Scheme {
+		return NewPaymentError(ErrCodeSchemeMismatch,
+			fmt.Sprintf("scheme %q does not match required scheme %q", accepted.Scheme, required.Scheme), nil)
+	}
+
+	if !strings.EqualFold(accepted.PayTo, required.PayTo) {
+		return NewPaymentError(ErrCodeRecipientMismatch,
+			fmt.Sprintf("payTo %s does not match required recipient %s", accepted.PayTo, required.PayTo), nil)
+	}
+
+	acceptedAmount, ok := new(big.Int).SetString(accepted.Amount, 10)
+	if !ok {
+		return NewPaymentError(ErrCodeInvalidPayment, fmt.Sprintf("invalid amount %q", accepted.Amount), nil)
+	}
+	requiredAmount, ok := new(big.Int).SetString(required.Amount, 10)
+	if !ok {
+		return NewPaymentError(ErrCodeInvalidConfig, fmt.Sprintf("invalid configured amount %q", required.Amount), nil)
+	}
+	switch acceptedAmount.Cmp(requiredAmount) {
+	case -1:
+		return NewPaymentError(ErrCodeInsufficientAmount,
+			fmt.Sprintf("amount %s is less than required amount %s", accepted.Amount, required.Amount), nil)
+	case 1:
+		if allowSurplus {
+			break
+		}
+		return NewPaymentError(ErrCodeAmountMismatch,
+			fmt.Sprintf("amount %s does not match required amount %s", accepted.Amount, required.Amount), nil)
+	}
+
+	return nil
+}
+
 // sendPaymentRequired sends a 402 Payment Required response with V2 format.
 func sendPaymentRequired(w http.ResponseWriter, r *http.Request, rule *PricingRule, cfg *Config) {
+	writePaymentRequired(w, r, rule, cfg, "Payment required")
+}
+
+// sendPaymentRejected sends a 402 listing the valid payment options, with the
+// rejection reason in the error field.
+func sendPaymentRejected(w http.ResponseWriter, r *http.Request, rule *PricingRule, cfg *Config, err error) {
+	writePaymentRequired(w, r, rule, cfg, err.Error())
+}
+
+func writePaymentRequired(w http.ResponseWriter, r *http.Request, rule *PricingRule, cfg *Config, message string) {
 	if cfg.CustomPaywallHTML != "" && isBrowserRequest(r) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.WriteHeader(http.StatusPaymentRequired)
@@ -182,28 +458,15 @@ func sendPaymentRequired(w http.ResponseWriter, r *http.Request, rule *PricingRu
 		return
 	}
 
-	accepts := make([]PaymentRequirements, 0, len(rule.AcceptedTokens))
-	for _, token := range rule.AcceptedTokens {
-		accepts = append(accepts, PaymentRequirements{
-			Scheme:            "exact",
-			Network:           token.Network,
-			Amount:            token.Amount,
-			Asset:             token.AssetContract,
-			PayTo:             token.Recipient,
-			MaxTimeoutSeconds: int(cfg.ValidityDuration.Seconds()),
-			Extra: map[string]interface{}{
-				"name":    token.TokenName,
-				"version": "2",
-			},
-		})
-	}
-
-	response := PaymentRequiredResponse{
+	writePaymentRequiredResponse(w, &PaymentRequiredResponse{
 		X402Version: 2,
-		Error:       "Payment required",
-		Accepts:     accepts,
-	}
+		Error:       message,
+		Accepts:     buildAcceptsFromRule(rule, cfg.ValidityDuration),
+	})
+}
 
+// writePaymentRequiredResponse writes a 402 with the PAYMENT-REQUIRED header and JSON body.
+func writePaymentRequiredResponse(w http.ResponseWriter, response *PaymentRequiredResponse) {
 	// Set PAYMENT-REQUIRED header with base64-encoded requirements.
 	if responseJSON, err := json.Marshal(response); err == nil {
 		w.Header().Set(HeaderPaymentRequired, base64.StdEncoding.EncodeToString(responseJSON))
@@ -214,12 +477,54 @@ func sendPaymentRequired(w http.ResponseWriter, r *http.Request, rule *PricingRu
 	json.NewEncoder(w).Encode(response)
 }
 
-func sendError(w http.ResponseWriter, statusCode int, message string) {
+// sendPaymentFailure responds to a failed verification or settlement. Errors
+// other than PaymentErrors are classified as code. Failures the client can fix
+// by paying again get a 402 challenge with the reason, the rest a JSON error.
+func sendPaymentFailure(w http.ResponseWriter, r *http.Request, rule *PricingRule, cfg *Config, err error, code, message string) {
+	if !IsPaymentError(err) {
+		err = NewPaymentError(code, message, err)
+	}
+
+	code = GetPaymentErrorCode(err)
+	switch GRPCCode(code) {
+	case codes.ResourceExhausted:
+		sendPaymentRejected(w, r, rule, cfg, err)
+	case codes.InvalidArgument:
+		sendError(w, http.StatusBadRequest, code, err.Error())
+	default:
+		sendError(w, http.StatusInternalServerError, code, err.Error())
+	}
+}
+
+// settlementErrorCode classifies a settlement error.
+func settlementErrorCode(err error) string {
+	if code := GetPaymentErrorCode(err); code != "" {
+		return code
+	}
+	return ErrCodeSettlementFailed
+}
+
+// invalidPayment describes a payment the verifier rejected.
+func invalidPayment(result *VerificationResult) error {
+	reason := result.Reason
+	if reason == "" {
+		reason = "payment is invalid"
+	}
+	return NewPaymentError(ErrCodePaymentRejected, reason, nil)
+}
+
+// sendError writes a JSON error body. code is the PaymentError code, if any.
+func sendError(w http.ResponseWriter, statusCode int, code, message string) {
+	body := map[string]string{
+		"error": message,
+	}
+	if code != "" {
+		body["code"] = code
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": message,
-	})
+	json.NewEncoder(w).Encode(body)
 }
 
 // parsePaymentPayload decodes a V2 PAYMENT-SIGNATURE header into a PaymentPayload.
@@ -330,6 +635,22 @@ func DecodePaymentResponse(header string) (*PaymentResponse, error) {
 	return &response, nil
 }
 
+// DecodePaymentRequirements decodes a base64 JSON PaymentRequiredResponse, as
+// carried by the PAYMENT-REQUIRED header and by x402 gRPC status messages.
+func DecodePaymentRequirements(encoded string) (*PaymentRequiredResponse, error) {
+	jsonBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %w", err)
+	}
+
+	var response PaymentRequiredResponse
+	if err := json.Unmarshal(jsonBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payment requirements: %w", err)
+	}
+
+	return &response, nil
+}
+
 // ReadPaymentRequirements extracts payment requirements from a 402 response.
 func ReadPaymentRequirements(resp *http.Response) (*PaymentRequiredResponse, error) {
 	if resp.StatusCode != http.StatusPaymentRequired {
@@ -381,14 +702,7 @@ func isBrowserRequest(r *http.Request) bool {
 func buildAcceptsFromRule(rule *PricingRule, validityDuration time.Duration) []PaymentRequirements {
 	accepts := make([]PaymentRequirements, 0, len(rule.AcceptedTokens))
 	for _, token := range rule.AcceptedTokens {
-		accepts = append(accepts, PaymentRequirements{
-			Scheme:            "exact",
-			Network:           token.Network,
-			Amount:            token.Amount,
-			Asset:             token.AssetContract,
-			PayTo:             token.Recipient,
-			MaxTimeoutSeconds: int(validityDuration.Seconds()),
-		})
+		accepts = append(accepts, rule.Requirements(token, validityDuration))
 	}
 	return accepts
 }
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -410,6 +412,14 @@ func TestPaymentMiddleware_InvalidV2Header(t *testing.T) {
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("expected status 400 for invalid header, got %d", w.Code)
 	}
+
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode error body: %v", err)
+	}
+	if body["code"] != ErrCodeInvalidPayment {
+		t.Errorf("expected code %s, got %q", ErrCodeInvalidPayment, body["code"])
+	}
 }
 
 func TestPaymentMiddleware_V2Header_VersionTooLow(t *testing.T) {
@@ -471,6 +481,44 @@ func TestPaymentMiddleware_VerificationFailed(t *testing.T) {
 	if w.Code != http.StatusPaymentRequired {
 		t.Errorf("expected status 402, got %d", w.Code)
 	}
+
+	var body PaymentRequiredResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode 402 body: %v", err)
+	}
+	if !strings.Contains(body.Error, "insufficient balance") {
+		t.Errorf("expected rejection reason in 402 body, got %q", body.Error)
+	}
+}
+
+func TestPaymentMiddleware_VerifierError(t *testing.T) {
+	verifier := &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			return nil, errors.New("facilitator unreachable")
+		},
+	}
+	cfg := testConfig()
+	cfg.Verifier = verifier
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode error body: %v", err)
+	}
+	if body["code"] != ErrCodeVerificationFailed {
+		t.Errorf("expected code %s, got %q", ErrCodeVerificationFailed, body["code"])
+	}
 }
 
 func TestPaymentMiddleware_SkipPaths(t *testing.T) {
@@ -918,3 +966,391 @@ func TestIsBrowserRequest(t *testing.T) {
 		}
 	}
 }
+
+// --- Settle-on-success tests ---
+
+func TestPaymentMiddleware_SettleOnSuccess_SettlesAfterHandler(t *testing.T) {
+	var order []string
+	verifier := &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			order = append(order, "settle")
+			return &SettlementResult{TransactionHash: "0xtxhash", Network: "eip155:84532", PayerAddress: "0xPayer"}, nil
+		},
+	}
+
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.SettlementMode = SettleOnSuccess
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+		payment, ok := GetPaymentFromContext(r.Context())
+		if !ok || !payment.Verified {
+			t.Error("expected verified payment context")
+		}
+		w.Header().Set("X-Handler", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest("POST", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "created" {
+		t.Errorf("expected buffered body to be flushed, got %q", w.Body.String())
+	}
+	if w.Header().Get("X-Handler") != "yes" {
+		t.Error("expected handler headers to be flushed")
+	}
+	if len(order) != 2 || order[0] != "handler" || order[1] != "settle" {
+		t.Errorf("expected handler then settle, got %v", order)
+	}
+
+	resp, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil {
+		t.Fatalf("failed to decode payment response: %v", err)
+	}
+	if !resp.Success || resp.Transaction != "0xtxhash" {
+		t.Errorf("unexpected payment response: %+v", resp)
+	}
+}
+
+func TestPaymentMiddleware_SettleOnSuccess_HandlerFailureSkipsSettlement(t *testing.T) {
+	settled := false
+	verifier := &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			settled = true
+			return &SettlementResult{TransactionHash: "0xtxhash"}, nil
+		},
+	}
+
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.SettlementMode = SettleOnSuccess
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("backend down"))
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if settled {
+		t.Error("payment should not be settled when the handler fails")
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected handler status 503, got %d", w.Code)
+	}
+	if w.Body.String() != "backend down" {
+		t.Errorf("expected handler body, got %q", w.Body.String())
+	}
+	if w.Header().Get(HeaderPaymentResponse) != "" {
+		t.Error("expected no PAYMENT-RESPONSE header when not settled")
+	}
+}
+
+func TestPaymentMiddleware_SettleOnSuccess_SettlementFailure(t *testing.T) {
+	verifier := &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			return nil, errors.New("insufficient funds")
+		},
+	}
+
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.SettlementMode = SettleOnSuccess
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("premium content"))
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "premium content") {
+		t.Error("buffered body must not be sent when settlement fails")
+	}
+
+	resp, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil {
+		t.Fatalf("failed to decode payment response: %v", err)
+	}
+	if resp.Success {
+		t.Error("expected success=false in payment response")
+	}
+	if resp.ErrorReason == "" {
+		t.Error("expected error reason in payment response")
+	}
+}
+
+// --- Replay protection tests ---
+
+func TestPaymentMiddleware_NonceStore_RejectsConcurrentDuplicate(t *testing.T) {
+	header := makeV2PaymentHeader(t)
+	var handler http.Handler
+	var duplicate *httptest.ResponseRecorder
+	verifyCalls := 0
+
+	verifier := &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			verifyCalls++
+			if duplicate == nil {
+				// Submit the same payment while the first is still in flight.
+				req := httptest.NewRequest("GET", "/v1/paid", nil)
+				req.Header.Set(HeaderPaymentSignature, header)
+				duplicate = httptest.NewRecorder()
+				handler.ServeHTTP(duplicate, req)
+			}
+			return &VerificationResult{Valid: true, PayerAddress: "0xPayer", Amount: "1000000"}, nil
+		},
+	}
+
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.NonceStore = NewMemoryNonceStore()
+
+	handler = PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, header)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected first request to succeed, got %d", w.Code)
+	}
+	if duplicate.Code != http.StatusConflict {
+		t.Errorf("expected duplicate to get 409, got %d", duplicate.Code)
+	}
+	if verifyCalls != 1 {
+		t.Errorf("expected verifier to be called once, got %d", verifyCalls)
+	}
+
+	// Settled payments cannot be replayed either.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected replay of settled payment to get 409, got %d", w.Code)
+	}
+}
+
+func TestPaymentMiddleware_NonceStore_ReleasesOnFailure(t *testing.T) {
+	settleErr := errors.New("facilitator down")
+	verifier := &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			if settleErr != nil {
+				return nil, settleErr
+			}
+			return &SettlementResult{TransactionHash: "0xtxhash"}, nil
+		},
+	}
+
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.NonceStore = NewMemoryNonceStore()
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected settlement failure, got %d", w.Code)
+	}
+
+	// The nonce was released, so the client can retry the same payment.
+	settleErr = nil
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected retry after failed settlement to succeed, got %d", w.Code)
+	}
+}
+
+// --- Strict payment matching tests ---
+
+func TestResolveRequirements(t *testing.T) {
+	rule := &PricingRule{
+		AcceptedTokens: []TokenRequirement{
+			{Network: "eip155:84532", Symbol: "USDC", AssetContract: "0xUSDC", Recipient: "0xRecipient", Amount: "1000000"},
+			{Network: "eip155:84532", Symbol: "EURC", AssetContract: "0xEURC", Recipient: "0xRecipient", Amount: "900000"},
+		},
+	}
+	fallback := buildRequirementsFromRule(rule)
+
+	accepted := func(mutate func(*PaymentRequirements)) *PaymentPayload {
+		req := PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Asset: "0xeurc", PayTo: "0xrecipient", Amount: "900000"}
+		if mutate != nil {
+			mutate(&req)
+		}
+		return &PaymentPayload{X402Version: 2, Accepted: req}
+	}
+
+	tests := []struct {
+		name     string
+		strict   bool
+		payload  *PaymentPayload
+		wantCode string
+		wantSym  string
+	}{
+		{name: "match", strict: true, payload: accepted(nil), wantSym: "EURC"},
+		{name: "lenient unknown asset falls back", payload: accepted(func(r *PaymentRequirements) { r.Asset = "0xDAI" })},
+		{name: "lenient ignores amount", payload: accepted(func(r *PaymentRequirements) { r.Amount = "1" }), wantSym: "EURC"},
+		{name: "unknown asset", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Asset = "0xDAI" }), wantCode: ErrCodeTokenNotAccepted},
+		{name: "unknown network", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Network = "eip155:1" }), wantCode: ErrCodeNetworkNotSupported},
+		{name: "scheme", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Scheme = "upto" }), wantCode: ErrCodeSchemeMismatch},
+		{name: "recipient", strict: true, payload: accepted(func(r *PaymentRequirements) { r.PayTo = "0xAttacker" }), wantCode: ErrCodeRecipientMismatch},
+		{name: "underpaid", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Amount = "899999" }), wantCode: ErrCodeInsufficientAmount},
+		{name: "overpaid", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Amount = "900001" }), wantCode: ErrCodeAmountMismatch},
+		{name: "invalid amount", strict: true, payload: accepted(func(r *PaymentRequirements) { r.Amount = "0.9" }), wantCode: ErrCodeInvalidPayment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{StrictPaymentMatching: tt.strict}
+			requirements, symbol, err := cfg.ResolveRequirements(rule, tt.payload, fallback)
+			if tt.wantCode != "" {
+				if GetPaymentErrorCode(err) != tt.wantCode {
+					t.Fatalf("expected error code %s, got %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if symbol != tt.wantSym {
+				t.Errorf("expected symbol %q, got %q", tt.wantSym, symbol)
+			}
+			if tt.wantSym == "" && requirements != fallback {
+				t.Error("expected fallback requirements")
+			}
+		})
+	}
+}
+
+func TestPaymentMiddleware_StrictMatching_RejectsUnknownToken(t *testing.T) {
+	verifyCalled := false
+	cfg := testConfig()
+	cfg.StrictPaymentMatching = true
+	cfg.Verifier = &MockVerifier{
+		VerifyFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*VerificationResult, error) {
+			verifyCalled = true
+			return &VerificationResult{Valid: true}, nil
+		},
+	}
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	payload := PaymentPayload{
+		X402Version: 2,
+		Accepted:    PaymentRequirements{Scheme: "exact", Network: "eip155:1", Asset: "0xDAI", PayTo: "0xRecipient", Amount: "1000000"},
+		Payload:     map[string]interface{}{"signature": "0xsig"},
+	}
+	header, _ := EncodePaymentPayload(&payload)
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, header)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	if verifyCalled {
+		t.Error("verifier should not be called for unaccepted tokens")
+	}
+
+	var response PaymentRequiredResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.Contains(response.Error, ErrCodeNetworkNotSupported) {
+		t.Errorf("expected %s in error, got %q", ErrCodeNetworkNotSupported, response.Error)
+	}
+	if len(response.Accepts) != 1 || response.Accepts[0].Network != "eip155:84532" {
+		t.Errorf("expected valid options to be listed, got %+v", response.Accepts)
+	}
+}
+
+func TestPaymentMiddleware_StrictMatching_RejectsAmountMismatch(t *testing.T) {
+	cfg := testConfig()
+	cfg.StrictPaymentMatching = true
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	payload := PaymentPayload{
+		X402Version: 2,
+		Accepted:    PaymentRequirements{Scheme: "exact", Network: "eip155:84532", Asset: "0x036CbD53842c5426634e7929541eC2318f3dCF7e", PayTo: "0xRecipient", Amount: "1"},
+		Payload:     map[string]interface{}{"signature": "0xsig"},
+	}
+	header, _ := EncodePaymentPayload(&payload)
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, header)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrCodeInsufficientAmount) {
+		t.Errorf("expected %s in body, got %s", ErrCodeInsufficientAmount, w.Body.String())
+	}
+
+	// The same payment is accepted when strict matching is off.
+	cfg.StrictPaymentMatching = false
+	handler = PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected lenient mode to accept payment, got %d", w.Code)
+	}
+}
+
+func TestPaymentMiddleware_MethodAwarePricing(t *testing.T) {
+	cfg := testConfig()
+	cfg.EndpointPricing = map[string]PricingRule{
+		"DELETE /v1/items/{id}": cfg.EndpointPricing["/v1/paid"],
+	}
+
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodDelete: http.StatusPaymentRequired,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/v1/items/42", nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", method, want, rec.Code)
+		}
+	}
+}
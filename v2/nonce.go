@@ -0,0 +1,217 @@
+package x402
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNonceInUse is returned by NonceStore.Reserve when a nonce is already
+// reserved by an in-flight payment or has already been consumed.
+var ErrNonceInUse = errors.New("payment nonce already in use")
+
+// NonceStore tracks payment nonces so the same payment cannot be submitted
+// twice while settlement is in flight or after it has completed.
+type NonceStore interface {
+	// Reserve claims key for ttl. It returns ErrNonceInUse if the key is
+	// already reserved or consumed.
+	Reserve(ctx context.Context, key string, ttl time.Duration) error
+
+	// Consume marks a reserved key as spent. The key stays unavailable until
+	// its ttl expires.
+	Consume(ctx context.Context, key string) error
+
+	// Release frees a reserved key so the same payment can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryNonceStore is an in-memory NonceStore with per-key expiry.
+// It is safe for concurrent use but is not shared across processes.
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	entries   map[string]nonceEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type nonceEntry struct {
+	expiresAt time.Time
+	consumed  bool
+}
+
+// nonceSweepInterval bounds how often expired entries are pruned.
+const nonceSweepInterval = time.Minute
+
+// NewMemoryNonceStore creates an empty in-memory nonce store.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		entries: make(map[string]nonceEntry),
+		now:     time.Now,
+	}
+}
+
+// Reserve claims key for ttl.
+func (s *MemoryNonceStore) Reserve(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		return ErrNonceInUse
+	}
+
+	s.entries[key] = nonceEntry{expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Consume marks key as spent until its reservation expires.
+func (s *MemoryNonceStore) Consume(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("nonce %q is not reserved", key)
+	}
+	entry.consumed = true
+	s.entries[key] = entry
+	return nil
+}
+
+// Release frees key unless it has already been consumed.
+func (s *MemoryNonceStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && !entry.consumed {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryNonceStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < nonceSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// eip3009Authorization is the part of an EIP-3009 payload that names the
+// payer and nonce, and how long the authorization is valid.
+type eip3009Authorization struct {
+	From        string      `json:"from"`
+	Nonce       string      `json:"nonce"`
+	ValidBefore json.Number `json:"validBefore"`
+}
+
+// authorization decodes the EIP-3009 authorization of a payload, returning
+// nil for other schemes.
+func authorization(payloadJSON []byte) *eip3009Authorization {
+	var eip3009 struct {
+		Authorization *eip3009Authorization `json:"authorization"`
+	}
+	if err := json.Unmarshal(payloadJSON, &eip3009); err != nil {
+		return nil
+	}
+	return eip3009.Authorization
+}
+
+// PaymentNonceKey derives the replay-protection key for a payment payload.
+// EIP-3009 payloads are keyed by network, asset, payer, and authorization nonce.
+// Other schemes fall back to a SHA-256 hash of the scheme-specific payload.
+func PaymentNonceKey(payload *PaymentPayload) string {
+	payloadJSON, err := json.Marshal(payload.Payload)
+	if err != nil {
+		return ""
+	}
+
+	if auth := authorization(payloadJSON); auth != nil && auth.Nonce != "" {
+		return strings.ToLower(strings.Join([]string{
+			"eip3009",
+			payload.Accepted.Network,
+			payload.Accepted.Asset,
+			auth.From,
+			auth.Nonce,
+		}, ":"))
+	}
+
+	sum := sha256.Sum256(payloadJSON)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// nonceTTL is how long the payload's nonce must be kept: until its EIP-3009
+// authorization expires, so a payment that was never settled on-chain (such
+// as a credit debit) cannot be replayed, and at least ValidityDuration.
+func (c *Config) nonceTTL(payload *PaymentPayload) time.Duration {
+	ttl := c.ValidityDuration
+	payloadJSON, err := json.Marshal(payload.Payload)
+	if err != nil {
+		return ttl
+	}
+	auth := authorization(payloadJSON)
+	if auth == nil {
+		return ttl
+	}
+	validBefore, err := auth.ValidBefore.Int64()
+	if err != nil {
+		return ttl
+	}
+	remaining := validBefore - time.Now().Unix() + 1
+	if remaining > int64(math.MaxInt64/time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	if d := time.Duration(remaining) * time.Second; d > ttl {
+		return d
+	}
+	return ttl
+}
+
+// ReservePaymentNonce reserves the payload's nonce in c.NonceStore until its
+// authorization expires, and for at least the configured ValidityDuration
+// (see nonceTTL). The returned finish function must be called
+// exactly once: with true after a successful settlement to consume the nonce,
+// or with false to release it. If no NonceStore is configured, ReservePaymentNonce
+// is a no-op.
+//
+// A duplicate payment yields a PaymentError with code ErrCodeDuplicatePayment.
+func (c *Config) ReservePaymentNonce(ctx context.Context, payload *PaymentPayload) (func(settled bool), error) {
+	if c.NonceStore == nil {
+		return func(bool) {}, nil
+	}
+
+	key := PaymentNonceKey(payload)
+	if key == "" {
+		return nil, NewPaymentError(ErrCodeInvalidPayment, "cannot derive payment nonce", nil)
+	}
+
+	if err := c.NonceStore.Reserve(ctx, key, c.nonceTTL(payload)); err != nil {
+		if errors.Is(err, ErrNonceInUse) {
+			return nil, NewPaymentError(ErrCodeDuplicatePayment, "payment has already been submitted", err)
+		}
+		return nil, fmt.Errorf("failed to reserve payment nonce: %w", err)
+	}
+
+	// Finish outlives the request, so don't let cancellation drop the update.
+	ctx = context.WithoutCancel(ctx)
+	return func(settled bool) {
+		if settled {
+			c.NonceStore.Consume(ctx, key)
+		} else {
+			c.NonceStore.Release(ctx, key)
+		}
+	}, nil
+}
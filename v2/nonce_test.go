@@ -0,0 +1,132 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceStore_ReserveConsumeRelease(t *testing.T) {
+	store := NewMemoryNonceStore()
+	ctx := context.Background()
+
+	if err := store.Reserve(ctx, "a", time.Minute); err != nil {
+		t.Fatalf("unexpected error reserving: %v", err)
+	}
+	if err := store.Reserve(ctx, "a", time.Minute); !errors.Is(err, ErrNonceInUse) {
+		t.Errorf("expected ErrNonceInUse for in-flight nonce, got %v", err)
+	}
+
+	// Released nonces can be reserved again.
+	store.Release(ctx, "a")
+	if err := store.Reserve(ctx, "a", time.Minute); err != nil {
+		t.Errorf("expected released nonce to be reservable, got %v", err)
+	}
+
+	// Consumed nonces stay unavailable, even after Release.
+	if err := store.Consume(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error consuming: %v", err)
+	}
+	store.Release(ctx, "a")
+	if err := store.Reserve(ctx, "a", time.Minute); !errors.Is(err, ErrNonceInUse) {
+		t.Errorf("expected ErrNonceInUse for consumed nonce, got %v", err)
+	}
+
+	if err := store.Consume(ctx, "unknown"); err == nil {
+		t.Error("expected error consuming unreserved nonce")
+	}
+}
+
+func TestMemoryNonceStore_Expiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryNonceStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Reserve(ctx, "a", time.Minute)
+	store.Consume(ctx, "a")
+
+	now = now.Add(2 * time.Minute)
+	if err := store.Reserve(ctx, "a", time.Minute); err != nil {
+		t.Errorf("expected expired nonce to be reservable, got %v", err)
+	}
+}
+
+func TestReservePaymentNonce_KeepsUntilValidBefore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore()
+	store.now = func() time.Time { return now }
+	cfg := Config{NonceStore: store, ValidityDuration: time.Minute}
+	ctx := context.Background()
+
+	payload := &PaymentPayload{Payload: map[string]interface{}{
+		"authorization": map[string]interface{}{
+			"from":        "0xPayer",
+			"nonce":       "0xNonce",
+			"validBefore": now.Add(time.Hour).Unix(),
+		},
+	}}
+	finish, err := cfg.ReservePaymentNonce(ctx, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	finish(true)
+
+	// The authorization outlives ValidityDuration, and so does its nonce.
+	now = now.Add(30 * time.Minute)
+	if _, err := cfg.ReservePaymentNonce(ctx, payload); GetPaymentErrorCode(err) != ErrCodeDuplicatePayment {
+		t.Errorf("expected nonce to be kept until validBefore, got %v", err)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if _, err := cfg.ReservePaymentNonce(ctx, payload); err != nil {
+		t.Errorf("expected nonce to expire with its authorization, got %v", err)
+	}
+}
+
+func TestPaymentNonceKey(t *testing.T) {
+	payload := &PaymentPayload{
+		Accepted: PaymentRequirements{Network: "eip155:84532", Asset: "0xAsset"},
+		Payload: map[string]interface{}{
+			"signature": "0xsig",
+			"authorization": map[string]interface{}{
+				"from":  "0xPayer",
+				"nonce": "0xNonce",
+			},
+		},
+	}
+
+	key := PaymentNonceKey(payload)
+	if key != "eip3009:eip155:84532:0xasset:0xpayer:0xnonce" {
+		t.Errorf("unexpected EIP-3009 nonce key: %s", key)
+	}
+
+	// Different signature over the same authorization is still the same payment.
+	other := *payload
+	other.Payload = map[string]interface{}{
+		"signature": "0xother",
+		"authorization": map[string]interface{}{
+			"from":  "0xPAYER",
+			"nonce": "0xNONCE",
+		},
+	}
+	if PaymentNonceKey(&other) != key {
+		t.Error("expected nonce key to ignore signature and case")
+	}
+
+	generic := &PaymentPayload{Payload: map[string]interface{}{"proof": "abc"}}
+	if key := PaymentNonceKey(generic); !strings.HasPrefix(key, "sha256:") {
+		t.Errorf("expected hash fallback for non-EIP-3009 payload, got %s", key)
+	}
+}
+
+func TestReservePaymentNonce_NoStore(t *testing.T) {
+	cfg := testConfig()
+	finish, err := cfg.ReservePaymentNonce(context.Background(), &PaymentPayload{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	finish(true)
+}
@@ -0,0 +1,54 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PriceRequest describes the request a PriceFunc prices.
+type PriceRequest struct {
+	// HTTPRequest is the request, for HTTP middleware. Its body has not been
+	// read; a PriceFunc that reads it must replace it for the handler.
+	HTTPRequest *http.Request
+
+	// FullMethod is the gRPC method, for the gRPC interceptors.
+	FullMethod string
+
+	// Message is the gRPC request message for unary calls. It is nil for streams.
+	Message interface{}
+}
+
+// PriceFunc returns the tokens, with their amounts, to advertise and enforce
+// for a request. Returning no tokens makes the request free. An error fails
+// the request; return a PaymentError to choose its code.
+type PriceFunc func(ctx context.Context, req *PriceRequest) ([]TokenRequirement, error)
+
+// Price returns the rule to enforce for a request: the rule itself, or, if
+// it has a PriceFunc, a copy whose AcceptedTokens are the function's result.
+// Errors are PaymentErrors, with ErrCodeInvalidConfig unless the PriceFunc
+// chose a code.
+func (p *PricingRule) Price(ctx context.Context, req *PriceRequest) (*PricingRule, error) {
+	if p.PriceFunc == nil {
+		return p, nil
+	}
+
+	tokens, err := p.PriceFunc(ctx, req)
+	if err != nil {
+		if IsPaymentError(err) {
+			return nil, err
+		}
+		return nil, NewPaymentError(ErrCodeInvalidConfig, "pricing failed", err)
+	}
+
+	for i := range tokens {
+		if err := p.validateToken(&tokens[i]); err != nil {
+			return nil, NewPaymentError(ErrCodeInvalidConfig, fmt.Sprintf("price function returned an invalid token at index %d", i), err)
+		}
+	}
+
+	priced := *p
+	priced.AcceptedTokens = tokens
+	priced.PriceFunc = nil
+	return &priced, nil
+}
@@ -0,0 +1,143 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func resolutionPricing() PricingRule {
+	return PricingRule{
+		PriceFunc: func(ctx context.Context, req *PriceRequest) ([]TokenRequirement, error) {
+			amount := "1000000"
+			switch req.HTTPRequest.URL.Query().Get("resolution") {
+			case "4k":
+				amount = "5000000"
+			case "preview":
+				return nil, nil
+			case "bogus":
+				return nil, NewPaymentError(ErrCodeInvalidPayment, "unknown resolution", nil)
+			case "broken":
+				return nil, errors.New("price feed down")
+			}
+			return []TokenRequirement{{
+				Network:       "eip155:84532",
+				Symbol:        "USDC",
+				AssetContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+				Recipient:     "0xRecipient",
+				Amount:        amount,
+			}}, nil
+		},
+	}
+}
+
+func dynamicPricingHandler(t *testing.T, called *bool) http.Handler {
+	t.Helper()
+	cfg := Config{
+		Verifier:              &MockVerifier{},
+		StrictPaymentMatching: true,
+		EndpointPricing:       map[string]PricingRule{"/v1/render": resolutionPricing()},
+	}
+	return PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestPaymentMiddleware_PriceFuncAdvertisesComputedPrice(t *testing.T) {
+	var called bool
+	handler := dynamicPricingHandler(t, &called)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/render?resolution=4k", nil))
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+	var body PaymentRequiredResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode 402 body: %v", err)
+	}
+	if len(body.Accepts) != 1 || body.Accepts[0].Amount != "5000000" {
+		t.Errorf("expected computed amount 5000000, got %+v", body.Accepts)
+	}
+}
+
+func TestPaymentMiddleware_PriceFuncEnforcesComputedPrice(t *testing.T) {
+	var called bool
+	handler := dynamicPricingHandler(t, &called)
+
+	// The test payment authorizes 1000000, enough for the default resolution only.
+	req := httptest.NewRequest("GET", "/v1/render?resolution=4k", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPaymentRequired || called {
+		t.Errorf("expected 402 for an underpaid 4k request, got %d (handler called: %v)", w.Code, called)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/render", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !called {
+		t.Errorf("expected paid request to succeed, got %d", w.Code)
+	}
+}
+
+func TestPaymentMiddleware_PriceFuncFreeAndErrors(t *testing.T) {
+	tests := []struct {
+		resolution string
+		wantStatus int
+		wantCode   string
+	}{
+		{"preview", http.StatusOK, ""},
+		{"bogus", http.StatusBadRequest, ErrCodeInvalidPayment},
+		{"broken", http.StatusInternalServerError, ErrCodeInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolution, func(t *testing.T) {
+			var called bool
+			handler := dynamicPricingHandler(t, &called)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/render?resolution="+tt.resolution, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode error body: %v", err)
+			}
+			if body["code"] != tt.wantCode {
+				t.Errorf("expected code %s, got %q", tt.wantCode, body["code"])
+			}
+		})
+	}
+}
+
+func TestPricingRule_PriceRejectsInvalidTokens(t *testing.T) {
+	rule := PricingRule{
+		PriceFunc: func(ctx context.Context, req *PriceRequest) ([]TokenRequirement, error) {
+			return []TokenRequirement{{Network: "eip155:84532"}}, nil
+		},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("rule with a PriceFunc needs no static tokens: %v", err)
+	}
+
+	_, err := rule.Price(context.Background(), &PriceRequest{})
+	if GetPaymentErrorCode(err) != ErrCodeInvalidConfig {
+		t.Errorf("expected %s for an invalid computed token, got %v", ErrCodeInvalidConfig, err)
+	}
+}
@@ -0,0 +1,277 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.4
+// 	protoc        (unknown)
+// source: x402/payment.proto
+
+package x402pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// PaymentRequirements is one way to pay for a call (scheme + network + token).
+type PaymentRequirements struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Scheme is the payment scheme (e.g., "exact").
+	Scheme string `protobuf:"bytes,1,opt,name=scheme,proto3" json:"scheme,omitempty"`
+	// Network is the blockchain network in CAIP-2 format (e.g., "eip155:8453").
+	Network string `protobuf:"bytes,2,opt,name=network,proto3" json:"network,omitempty"`
+	// Amount is the payment amount in atomic units.
+	Amount string `protobuf:"bytes,3,opt,name=amount,proto3" json:"amount,omitempty"`
+	// Asset is the token contract address.
+	Asset string `protobuf:"bytes,4,opt,name=asset,proto3" json:"asset,omitempty"`
+	// PayTo is the recipient address.
+	PayTo string `protobuf:"bytes,5,opt,name=pay_to,json=payTo,proto3" json:"pay_to,omitempty"`
+	// MaxTimeoutSeconds is how long the requirements are valid.
+	MaxTimeoutSeconds int32 `protobuf:"varint,6,opt,name=max_timeout_seconds,json=maxTimeoutSeconds,proto3" json:"max_timeout_seconds,omitempty"`
+	// Extra carries scheme-specific data, such as the EIP-712 domain name and version.
+	Extra         *structpb.Struct `protobuf:"bytes,7,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaymentRequirements) Reset() {
+	*x = PaymentRequirements{}
+	mi := &file_x402_payment_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaymentRequirements) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaymentRequirements) ProtoMessage() {}
+
+func (x *PaymentRequirements) ProtoReflect() protoreflect.Message {
+	mi := &file_x402_payment_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaymentRequirements.ProtoReflect.Descriptor instead.
+func (*PaymentRequirements) Descriptor() ([]byte, []int) {
+	return file_x402_payment_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PaymentRequirements) GetScheme() string {
+	if x != nil {
+		return x.Scheme
+	}
+	return ""
+}
+
+func (x *PaymentRequirements) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *PaymentRequirements) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *PaymentRequirements) GetAsset() string {
+	if x != nil {
+		return x.Asset
+	}
+	return ""
+}
+
+func (x *PaymentRequirements) GetPayTo() string {
+	if x != nil {
+		return x.PayTo
+	}
+	return ""
+}
+
+func (x *PaymentRequirements) GetMaxTimeoutSeconds() int32 {
+	if x != nil {
+		return x.MaxTimeoutSeconds
+	}
+	return 0
+}
+
+func (x *PaymentRequirements) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+// PaymentRequired is attached as a status detail to the RESOURCE_EXHAUSTED
+// status returned for calls that require payment.
+type PaymentRequired struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// X402Version is the protocol version.
+	X402Version int32 `protobuf:"varint,1,opt,name=x402_version,json=x402Version,proto3" json:"x402_version,omitempty"`
+	// Error explains why payment is required or why a payment was rejected.
+	Error string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	// Accepts lists the payment options for the call.
+	Accepts       []*PaymentRequirements `protobuf:"bytes,3,rep,name=accepts,proto3" json:"accepts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaymentRequired) Reset() {
+	*x = PaymentRequired{}
+	mi := &file_x402_payment_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaymentRequired) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaymentRequired) ProtoMessage() {}
+
+func (x *PaymentRequired) ProtoReflect() protoreflect.Message {
+	mi := &file_x402_payment_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaymentRequired.ProtoReflect.Descriptor instead.
+func (*PaymentRequired) Descriptor() ([]byte, []int) {
+	return file_x402_payment_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PaymentRequired) GetX402Version() int32 {
+	if x != nil {
+		return x.X402Version
+	}
+	return 0
+}
+
+func (x *PaymentRequired) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *PaymentRequired) GetAccepts() []*PaymentRequirements {
+	if x != nil {
+		return x.Accepts
+	}
+	return nil
+}
+
+var File_x402_payment_proto protoreflect.FileDescriptor
+
+var file_x402_payment_proto_rawDesc = string([]byte{
+	0x0a, 0x12, 0x78, 0x34, 0x30, 0x32, 0x2f, 0x70, 0x61, 0x79, 0x6d, 0x65, 0x6e, 0x74, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x78, 0x34, 0x30, 0x32, 0x1a, 0x1c, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x73, 0x74, 0x72, 0x75,
+	0x63, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xeb, 0x01, 0x0a, 0x13, 0x50, 0x61, 0x79,
+	0x6d, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x73,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x77,
+	0x6f, 0x72, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f,
+	0x72, 0x6b, 0x12, 0x16, 0x0a, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x73,
+	0x73, 0x65, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x61, 0x73, 0x73, 0x65, 0x74,
+	0x12, 0x15, 0x0a, 0x06, 0x70, 0x61, 0x79, 0x5f, 0x74, 0x6f, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x70, 0x61, 0x79, 0x54, 0x6f, 0x12, 0x2e, 0x0a, 0x13, 0x6d, 0x61, 0x78, 0x5f, 0x74,
+	0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x11, 0x6d, 0x61, 0x78, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x2d, 0x0a, 0x05, 0x65, 0x78, 0x74, 0x72, 0x61,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x75, 0x63, 0x74, 0x52,
+	0x05, 0x65, 0x78, 0x74, 0x72, 0x61, 0x22, 0x7f, 0x0a, 0x0f, 0x50, 0x61, 0x79, 0x6d, 0x65, 0x6e,
+	0x74, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x78, 0x34, 0x30,
+	0x32, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x0b, 0x78, 0x34, 0x30, 0x32, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05,
+	0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x12, 0x33, 0x0a, 0x07, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x73, 0x18, 0x03, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x78, 0x34, 0x30, 0x32, 0x2e, 0x50, 0x61, 0x79, 0x6d, 0x65,
+	0x6e, 0x74, 0x52, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x07,
+	0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x73, 0x42, 0x41, 0x5a, 0x3f, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x65, 0x63, 0x6f, 0x6d, 0x65, 0x6c, 0x69, 0x6d, 0x69,
+	0x6e, 0x61, 0x6c, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79,
+	0x2d, 0x78, 0x34, 0x30, 0x32, 0x2f, 0x76, 0x32, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x78,
+	0x34, 0x30, 0x32, 0x3b, 0x78, 0x34, 0x30, 0x32, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+})
+
+var (
+	file_x402_payment_proto_rawDescOnce sync.Once
+	file_x402_payment_proto_rawDescData []byte
+)
+
+func file_x402_payment_proto_rawDescGZIP() []byte {
+	file_x402_payment_proto_rawDescOnce.Do(func() {
+		file_x402_payment_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_x402_payment_proto_rawDesc), len(file_x402_payment_proto_rawDesc)))
+	})
+	return file_x402_payment_proto_rawDescData
+}
+
+var file_x402_payment_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_x402_payment_proto_goTypes = []any{
+	(*PaymentRequirements)(nil), // 0: x402.PaymentRequirements
+	(*PaymentRequired)(nil),     // 1: x402.PaymentRequired
+	(*structpb.Struct)(nil),     // 2: google.protobuf.Struct
+}
+var file_x402_payment_proto_depIdxs = []int32{
+	2, // 0: x402.PaymentRequirements.extra:type_name -> google.protobuf.Struct
+	0, // 1: x402.PaymentRequired.accepts:type_name -> x402.PaymentRequirements
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_x402_payment_proto_init() }
+func file_x402_payment_proto_init() {
+	if File_x402_payment_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_x402_payment_proto_rawDesc), len(file_x402_payment_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_x402_payment_proto_goTypes,
+		DependencyIndexes: file_x402_payment_proto_depIdxs,
+		MessageInfos:      file_x402_payment_proto_msgTypes,
+	}.Build()
+	File_x402_payment_proto = out.File
+	file_x402_payment_proto_goTypes = nil
+	file_x402_payment_proto_depIdxs = nil
+}
@@ -0,0 +1,236 @@
+package x402
+
+import (
+	"fmt"
+	"net/http"
+
+	x402pb "github.com/becomeliminal/grpc-gateway-x402/v2/proto/x402"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ProtoPricing holds pricing tables built from x402 proto options.
+type ProtoPricing struct {
+	// MethodPricing maps full gRPC method names ("/package.Service/Method") to pricing rules.
+	MethodPricing map[string]PricingRule
+
+	// EndpointPricing maps grpc-gateway routes from google.api.http annotations to pricing rules.
+	EndpointPricing map[string]PricingRule
+
+	// endpoints maps normalized routes to the EndpointPricing key that declared them,
+	// so templates differing only in variable names are detected as conflicts.
+	endpoints map[string]string
+}
+
+// LoadProtoPricing walks the services registered in files (protoregistry.GlobalFiles
+// if nil) and builds pricing tables from the (x402.pricing) method option and the
+// (x402.default_pricing) service option. Methods with a google.api.http annotation
+// are also priced under their gateway routes ("POST /v1/items/{id}:buy"), so HTTP
+// and gRPC share one price.
+func LoadProtoPricing(files *protoregistry.Files) (*ProtoPricing, error) {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	pricing := &ProtoPricing{
+		MethodPricing:   make(map[string]PricingRule),
+		EndpointPricing: make(map[string]PricingRule),
+		endpoints:       make(map[string]string),
+	}
+
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			if err = pricing.addService(services.Get(i)); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pricing, nil
+}
+
+func (p *ProtoPricing) addService(sd protoreflect.ServiceDescriptor) error {
+	serviceDefault, _ := proto.GetExtension(sd.Options(), x402pb.E_DefaultPricing).(*x402pb.Pricing)
+
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		fullMethod := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+
+		pb, _ := proto.GetExtension(md.Options(), x402pb.E_Pricing).(*x402pb.Pricing)
+		if pb == nil {
+			pb = serviceDefault
+		}
+		if pb == nil {
+			continue
+		}
+
+		rule := pricingRuleFromProto(pb)
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid x402 pricing for method %q: %w", fullMethod, err)
+		}
+		p.MethodPricing[fullMethod] = rule
+
+		httpRule, _ := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		for _, pattern := range httpPatterns(httpRule) {
+			key := gatewayRouteKey(pattern)
+			if existing, ok := p.endpoints[key]; ok {
+				if !samePricing(p.EndpointPricing[existing], rule) {
+					return fmt.Errorf("conflicting x402 pricing for endpoint %q (method %q)", pattern, fullMethod)
+				}
+				continue
+			}
+			p.endpoints[key] = pattern
+			p.EndpointPricing[pattern] = rule
+		}
+	}
+
+	return nil
+}
+
+// LoadGatewayRoutes maps the google.api.http bindings of every method registered
+// in files (protoregistry.GlobalFiles if nil) to the method's full gRPC name,
+// for use as Config.GatewayRoutes.
+func LoadGatewayRoutes(files *protoregistry.Files) (map[string]string, error) {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	routes := make(map[string]string)
+	seen := make(map[string]string)
+
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			sd := services.Get(i)
+			methods := sd.Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				fullMethod := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+
+				httpRule, _ := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+				for _, pattern := range httpPatterns(httpRule) {
+					key := gatewayRouteKey(pattern)
+					if existing, ok := seen[key]; ok && existing != fullMethod {
+						err = fmt.Errorf("gateway route %q is bound to both %q and %q", pattern, existing, fullMethod)
+						return false
+					}
+					seen[key] = fullMethod
+					routes[pattern] = fullMethod
+				}
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// Apply merges the proto pricing into cfg. Entries already present in cfg win,
+// so Go configuration can override prices declared in protos.
+func (p *ProtoPricing) Apply(cfg *Config) {
+	if cfg.MethodPricing == nil {
+		cfg.MethodPricing = make(map[string]PricingRule)
+	}
+	for method, rule := range p.MethodPricing {
+		if _, ok := cfg.MethodPricing[method]; !ok {
+			cfg.MethodPricing[method] = rule
+		}
+	}
+
+	if cfg.EndpointPricing == nil {
+		cfg.EndpointPricing = make(map[string]PricingRule)
+	}
+	for pattern, rule := range p.EndpointPricing {
+		if _, ok := cfg.EndpointPricing[pattern]; !ok {
+			cfg.EndpointPricing[pattern] = rule
+		}
+	}
+}
+
+func pricingRuleFromProto(pb *x402pb.Pricing) PricingRule {
+	rule := PricingRule{
+		AcceptedTokens: make([]TokenRequirement, 0, len(pb.GetAcceptedTokens())),
+		Description:    pb.GetDescription(),
+		MimeType:       pb.GetMimeType(),
+	}
+
+	for _, token := range pb.GetAcceptedTokens() {
+		amount := token.GetAmount()
+		if amount == "" {
+			amount = pb.GetAmount()
+		}
+		rule.AcceptedTokens = append(rule.AcceptedTokens, TokenRequirement{
+			Network:       token.GetNetwork(),
+			AssetContract: token.GetAssetContract(),
+			Symbol:        token.GetSymbol(),
+			Recipient:     token.GetRecipient(),
+			Amount:        amount,
+			TokenName:     token.GetTokenName(),
+			TokenDecimals: int(token.GetTokenDecimals()),
+		})
+	}
+
+	return rule
+}
+
+func samePricing(a, b PricingRule) bool {
+	if a.Description != b.Description || a.MimeType != b.MimeType || len(a.AcceptedTokens) != len(b.AcceptedTokens) {
+		return false
+	}
+	for i := range a.AcceptedTokens {
+		if a.AcceptedTokens[i] != b.AcceptedTokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// httpPatterns returns "METHOD /path/{template}" endpoint patterns for a
+// google.api.http rule and its additional bindings.
+func httpPatterns(rule *annotations.HttpRule) []string {
+	if rule == nil {
+		return nil
+	}
+
+	var patterns []string
+	var method, template string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, template = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		method, template = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		method, template = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		method, template = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		method, template = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		method, template = p.Custom.GetKind(), p.Custom.GetPath()
+	}
+	if template != "" {
+		if method != "" && method != "*" {
+			template = method + " " + template
+		}
+		patterns = append(patterns, template)
+	}
+
+	for _, binding := range rule.GetAdditionalBindings() {
+		patterns = append(patterns, httpPatterns(binding)...)
+	}
+
+	return patterns
+}
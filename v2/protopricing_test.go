@@ -0,0 +1,258 @@
+package x402
+
+import (
+	"strings"
+	"testing"
+
+	x402pb "github.com/becomeliminal/grpc-gateway-x402/v2/proto/x402"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testProtoToken(amount string) *x402pb.Token {
+	return &x402pb.Token{
+		Network:       "eip155:84532",
+		AssetContract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+		Symbol:        "USDC",
+		Recipient:     "0xRecipient",
+		Amount:        amount,
+	}
+}
+
+// testProtoFiles registers a service with x402 options:
+//
+//	service Items {
+//	  option (x402.default_pricing) = {amount: "100", accepted_tokens: [...]};
+//	  rpc GetItem   -> GET  /v1/items/{id}       (service default)
+//	  rpc ListItems -> GET  /v1/shelves/{shelf=shelves/*}/items, plus /v1/all/{path=**}
+//	  rpc BuyItem   -> POST /v1/items/{id}:buy   (x402.pricing: 5000)
+//	}
+func testProtoFiles(t *testing.T, mutate func(*descriptorpb.FileDescriptorProto)) *protoregistry.Files {
+	t.Helper()
+
+	methodOpts := func(rule *annotations.HttpRule, pricing *x402pb.Pricing) *descriptorpb.MethodOptions {
+		opts := &descriptorpb.MethodOptions{}
+		if rule != nil {
+			proto.SetExtension(opts, annotations.E_Http, rule)
+		}
+		if pricing != nil {
+			proto.SetExtension(opts, x402pb.E_Pricing, pricing)
+		}
+		return opts
+	}
+
+	serviceOpts := &descriptorpb.ServiceOptions{}
+	proto.SetExtension(serviceOpts, x402pb.E_DefaultPricing, &x402pb.Pricing{
+		Amount:         "100",
+		Description:    "items",
+		AcceptedTokens: []*x402pb.Token{testProtoToken("")},
+	})
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/items.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Req")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:    proto.String("Items"),
+			Options: serviceOpts,
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("GetItem"),
+					InputType:  proto.String(".test.v1.Req"),
+					OutputType: proto.String(".test.v1.Req"),
+					Options:    methodOpts(&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/items/{id}"}}, nil),
+				},
+				{
+					Name:       proto.String("ListItems"),
+					InputType:  proto.String(".test.v1.Req"),
+					OutputType: proto.String(".test.v1.Req"),
+					Options: methodOpts(&annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Get{Get: "/v1/shelves/{shelf=shelves/*}/items"},
+						AdditionalBindings: []*annotations.HttpRule{
+							{Pattern: &annotations.HttpRule_Get{Get: "/v1/all/{path=**}"}},
+						},
+					}, nil),
+				},
+				{
+					Name:       proto.String("BuyItem"),
+					InputType:  proto.String(".test.v1.Req"),
+					OutputType: proto.String(".test.v1.Req"),
+					Options: methodOpts(
+						&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/items/{id}:buy"}},
+						&x402pb.Pricing{Description: "buy", AcceptedTokens: []*x402pb.Token{testProtoToken("5000")}},
+					),
+				},
+			},
+		}},
+	}
+	if mutate != nil {
+		mutate(file)
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("failed to build file descriptor: %v", err)
+	}
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+	return files
+}
+
+func TestLoadProtoPricing(t *testing.T) {
+	pricing, err := LoadProtoPricing(testProtoFiles(t, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	methods := map[string]string{
+		"/test.v1.Items/GetItem":   "100",
+		"/test.v1.Items/ListItems": "100",
+		"/test.v1.Items/BuyItem":   "5000",
+	}
+	for method, amount := range methods {
+		rule, ok := pricing.MethodPricing[method]
+		if !ok {
+			t.Errorf("missing method pricing for %s", method)
+			continue
+		}
+		if got := rule.AcceptedTokens[0].Amount; got != amount {
+			t.Errorf("%s: expected amount %s, got %s", method, amount, got)
+		}
+	}
+
+	endpoints := map[string]string{
+		"GET /v1/items/{id}":                      "100",
+		"GET /v1/shelves/{shelf=shelves/*}/items": "100",
+		"GET /v1/all/{path=**}":                   "100",
+		"POST /v1/items/{id}:buy":                 "5000",
+	}
+	if len(pricing.EndpointPricing) != len(endpoints) {
+		t.Errorf("expected %d endpoints, got %v", len(endpoints), pricing.EndpointPricing)
+	}
+	for pattern, amount := range endpoints {
+		rule, ok := pricing.EndpointPricing[pattern]
+		if !ok {
+			t.Errorf("missing endpoint pricing for %s", pattern)
+			continue
+		}
+		if got := rule.AcceptedTokens[0].Amount; got != amount {
+			t.Errorf("%s: expected amount %s, got %s", pattern, amount, got)
+		}
+	}
+}
+
+func TestLoadProtoPricing_InvalidRule(t *testing.T) {
+	files := testProtoFiles(t, func(file *descriptorpb.FileDescriptorProto) {
+		opts := file.Service[0].Method[0].Options
+		proto.SetExtension(opts, x402pb.E_Pricing, &x402pb.Pricing{
+			AcceptedTokens: []*x402pb.Token{{Network: "eip155:84532"}},
+		})
+	})
+
+	_, err := LoadProtoPricing(files)
+	if err == nil || !strings.Contains(err.Error(), "/test.v1.Items/GetItem") {
+		t.Errorf("expected validation error naming the method, got %v", err)
+	}
+}
+
+func TestLoadProtoPricing_ConflictingEndpoint(t *testing.T) {
+	files := testProtoFiles(t, func(file *descriptorpb.FileDescriptorProto) {
+		opts := file.Service[0].Method[1].Options
+		proto.SetExtension(opts, annotations.E_Http, &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Post{Post: "/v1/items/{name}:buy"},
+		})
+	})
+
+	_, err := LoadProtoPricing(files)
+	if err == nil || !strings.Contains(err.Error(), "conflicting") {
+		t.Errorf("expected conflicting pricing error, got %v", err)
+	}
+}
+
+func TestProtoPricing_Apply(t *testing.T) {
+	pricing, err := LoadProtoPricing(testProtoFiles(t, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := testConfig()
+	override := cfg.EndpointPricing["/v1/paid"]
+	cfg.MethodPricing = map[string]PricingRule{"/test.v1.Items/BuyItem": override}
+	pricing.Apply(&cfg)
+
+	if got := cfg.MethodPricing["/test.v1.Items/BuyItem"].AcceptedTokens[0].Amount; got != "1000000" {
+		t.Errorf("expected Go config to win over proto pricing, got %s", got)
+	}
+	if _, ok := cfg.MethodPricing["/test.v1.Items/GetItem"]; !ok {
+		t.Error("expected proto method pricing to be merged")
+	}
+	if _, ok := cfg.EndpointPricing["GET /v1/items/{id}"]; !ok {
+		t.Error("expected proto endpoint pricing to be merged")
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("merged config should be valid: %v", err)
+	}
+}
+
+func TestPathTemplateToPattern(t *testing.T) {
+	tests := map[string]string{
+		"/v1/items":                       "/v1/items",
+		"/v1/items/{id}":                  "/v1/items/*",
+		"/v1/{name=shelves/*}/books/{id}": "/v1/shelves/*/books/*",
+		"/v1/files/{path=**}":             "/v1/files/**",
+		"/v1/items/{id}:cancel":           "/v1/items/*:cancel",
+	}
+	for template, want := range tests {
+		if got := pathTemplateToPattern(template); got != want {
+			t.Errorf("pathTemplateToPattern(%q) = %q, want %q", template, got, want)
+		}
+	}
+}
+
+func TestLoadGatewayRoutes(t *testing.T) {
+	routes, err := LoadGatewayRoutes(testProtoFiles(t, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"GET /v1/items/{id}":                      "/test.v1.Items/GetItem",
+		"GET /v1/shelves/{shelf=shelves/*}/items": "/test.v1.Items/ListItems",
+		"GET /v1/all/{path=**}":                   "/test.v1.Items/ListItems",
+		"POST /v1/items/{id}:buy":                 "/test.v1.Items/BuyItem",
+	}
+	if len(routes) != len(want) {
+		t.Errorf("expected %d routes, got %v", len(want), routes)
+	}
+	for route, method := range want {
+		if routes[route] != method {
+			t.Errorf("%s: expected %s, got %q", route, method, routes[route])
+		}
+	}
+
+	cfg := testConfig()
+	cfg.GatewayRoutes = routes
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("loaded routes should validate: %v", err)
+	}
+}
+
+func TestLoadGatewayRoutes_DuplicateBinding(t *testing.T) {
+	files := testProtoFiles(t, func(file *descriptorpb.FileDescriptorProto) {
+		proto.SetExtension(file.Service[0].Method[1].Options, annotations.E_Http, &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Get{Get: "/v1/items/{name}"},
+		})
+	})
+
+	if _, err := LoadGatewayRoutes(files); err == nil {
+		t.Error("expected error for a route bound to two methods")
+	}
+}
@@ -0,0 +1,350 @@
+package x402
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// EndpointRoute prices the requests matching Pattern. Routes are an ordered
+// alternative to EndpointPricing: when two patterns are equally specific,
+// the one declared first wins.
+type EndpointRoute struct {
+	// Pattern is an endpoint pattern (see Config.EndpointPricing).
+	Pattern string `yaml:"pattern"`
+
+	PricingRule `yaml:",inline"`
+}
+
+// segmentKind orders pattern segments by specificity.
+type segmentKind int
+
+const (
+	segmentCatchAll segmentKind = iota // "**", "{name=**}" or a trailing "*"
+	segmentParam                       // "*" or "{name}"
+	segmentGlob                        // path.Match pattern, e.g. "*.json" or "{id}:buy"
+	segmentLiteral
+)
+
+type routeSegment struct {
+	kind  segmentKind
+	value string
+}
+
+// route is a compiled endpoint or method pattern.
+type route struct {
+	pattern  string
+	method   string
+	segments []routeSegment
+	rule     *PricingRule
+	order    int
+}
+
+// routeMatcher matches requests against routes sorted by precedence, so the
+// first match is the best one.
+type routeMatcher struct {
+	routes []*route
+}
+
+// compileRoute parses a pattern of the form "[METHOD ]/path". Path segments
+// may be literals, "*" or "{name}" (one segment), globs understood by
+// path.Match (e.g. "*.json" or "{id}:buy"), or a final "**" / "{name=**}"
+// matching any remaining segments. A trailing "/*" keeps its historical
+// meaning of a prefix match.
+func compileRoute(pattern string, allowMethod bool) (*route, error) {
+	r := &route{pattern: pattern}
+
+	p := pattern
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		if !allowMethod {
+			return nil, fmt.Errorf("pattern %q: HTTP methods are not allowed here", pattern)
+		}
+		if !isHTTPMethod(method) {
+			return nil, fmt.Errorf("pattern %q: invalid HTTP method %q", pattern, method)
+		}
+		r.method = method
+		p = strings.TrimSpace(rest)
+	}
+
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("pattern %q: path must start with /", pattern)
+	}
+
+	// A literal trailing "/*" is a prefix match; "{name}" in the same place
+	// is a single segment.
+	prefixMatch := strings.HasSuffix(p, "/*")
+
+	parts := strings.Split(strings.TrimPrefix(pathTemplateToPattern(p), "/"), "/")
+	for i, part := range parts {
+		last := i == len(parts)-1
+
+		seg, err := compileSegment(part)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		if seg.kind == segmentParam && last && prefixMatch {
+			seg.kind = segmentCatchAll
+		}
+		if seg.kind == segmentCatchAll && !last {
+			return nil, fmt.Errorf("pattern %q: %q must be the last segment", pattern, part)
+		}
+		r.segments = append(r.segments, seg)
+	}
+
+	return r, nil
+}
+
+func compileSegment(part string) (routeSegment, error) {
+	switch {
+	case part == "**":
+		return routeSegment{kind: segmentCatchAll}, nil
+	case part == "*":
+		return routeSegment{kind: segmentParam}, nil
+	case strings.ContainsAny(part, "{}"):
+		return routeSegment{}, fmt.Errorf("unbalanced braces in segment %q", part)
+	case !strings.ContainsAny(part, `*?[\`):
+		return routeSegment{kind: segmentLiteral, value: part}, nil
+	}
+
+	if _, err := path.Match(part, ""); err != nil {
+		return routeSegment{}, fmt.Errorf("invalid segment %q: %w", part, err)
+	}
+	return routeSegment{kind: segmentGlob, value: part}, nil
+}
+
+func isHTTPMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the route matches a request. An empty method only
+// matches routes without one.
+func (r *route) match(method string, segments []string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+
+	for i, seg := range r.segments {
+		if seg.kind == segmentCatchAll {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if segments[i] != seg.value {
+				return false
+			}
+		case segmentGlob:
+			if ok, _ := path.Match(seg.value, segments[i]); !ok {
+				return false
+			}
+		}
+	}
+	return len(segments) == len(r.segments)
+}
+
+// overlaps reports whether some request could match both r and other. Two
+// globs are assumed to overlap.
+func (r *route) overlaps(other *route) bool {
+	if r.method != "" && other.method != "" && r.method != other.method {
+		return false
+	}
+
+	n := min(len(r.segments), len(other.segments))
+	for i := 0; i < n; i++ {
+		a, b := r.segments[i], other.segments[i]
+		if a.kind == segmentCatchAll || b.kind == segmentCatchAll {
+			return true
+		}
+		if !a.overlaps(b) {
+			return false
+		}
+	}
+
+	// One route has ended; the other must end too or continue with a catch-all.
+	switch {
+	case len(r.segments) > n:
+		return r.segments[n].kind == segmentCatchAll
+	case len(other.segments) > n:
+		return other.segments[n].kind == segmentCatchAll
+	}
+	return true
+}
+
+// overlaps reports whether some path segment could match both s and other.
+func (s routeSegment) overlaps(other routeSegment) bool {
+	switch {
+	case s.kind == segmentParam || other.kind == segmentParam:
+		return true
+	case s.kind == segmentLiteral && other.kind == segmentLiteral:
+		return s.value == other.value
+	case s.kind == segmentLiteral:
+		ok, _ := path.Match(other.value, s.value)
+		return ok
+	case other.kind == segmentLiteral:
+		ok, _ := path.Match(s.value, other.value)
+		return ok
+	}
+	return true
+}
+
+// before reports whether r takes precedence over other: routes with an explicit
+// method first, then the more specific route (compared segment by segment),
+// then the route declared first.
+func (r *route) before(other *route) bool {
+	if (r.method != "") != (other.method != "") {
+		return r.method != ""
+	}
+
+	// Compare segment kinds left to right. A route that ends is more specific
+	// than one continuing with a catch-all, and less specific than one
+	// continuing with any other segment.
+	for i := 0; i < len(r.segments) || i < len(other.segments); i++ {
+		if a, b := r.rank(i), other.rank(i); a != b {
+			return a > b
+		}
+	}
+
+	return r.order < other.order
+}
+
+func (r *route) rank(i int) int {
+	if i >= len(r.segments) {
+		return 1
+	}
+	if kind := r.segments[i].kind; kind != segmentCatchAll {
+		return 2 * int(kind)
+	}
+	return 0
+}
+
+func newRouteMatcher(routes []*route) *routeMatcher {
+	for i, r := range routes {
+		r.order = i
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].before(routes[j])
+	})
+	return &routeMatcher{routes: routes}
+}
+
+// match returns the highest-precedence route matching the request, or nil.
+func (m *routeMatcher) match(method, requestPath string) *route {
+	if m == nil || len(m.routes) == 0 {
+		return nil
+	}
+
+	segments := strings.Split(strings.TrimPrefix(requestPath, "/"), "/")
+	for _, r := range m.routes {
+		if r.match(method, segments) {
+			return r
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether some request could match both r and a route of m.
+func (m *routeMatcher) overlaps(r *route) bool {
+	if m == nil {
+		return false
+	}
+	for _, other := range m.routes {
+		if other.overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePricing compiles ordered routes followed by a pricing map (in
+// lexical order, so ties between map entries are deterministic).
+func compilePricing(routes []EndpointRoute, pricing map[string]PricingRule, allowMethod, strict bool) (*routeMatcher, error) {
+	compiled := make([]*route, 0, len(routes)+len(pricing))
+	add := func(pattern string, rule PricingRule) error {
+		r, err := compileRoute(pattern, allowMethod)
+		if err != nil {
+			if strict {
+				return err
+			}
+			return nil
+		}
+		rule.pattern = pattern
+		r.rule = &rule
+		compiled = append(compiled, r)
+		return nil
+	}
+
+	for _, er := range routes {
+		if err := add(er.Pattern, er.PricingRule); err != nil {
+			return nil, err
+		}
+	}
+
+	patterns := make([]string, 0, len(pricing))
+	for pattern := range pricing {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if err := add(pattern, pricing[pattern]); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRouteMatcher(compiled), nil
+}
+
+// compileSkips compiles a skip list. Skip patterns have no precedence.
+func compileSkips(patterns []string, allowMethod, strict bool) (*routeMatcher, error) {
+	compiled := make([]*route, 0, len(patterns))
+	for _, pattern := range patterns {
+		r, err := compileRoute(pattern, allowMethod)
+		if err != nil {
+			if strict {
+				return nil, err
+			}
+			continue
+		}
+		compiled = append(compiled, r)
+	}
+	return &routeMatcher{routes: compiled}, nil
+}
+
+// pathTemplateToPattern expands google.api.http style path variables into
+// their segment patterns: "{id}" becomes "*" and "{name=shelves/*}" becomes
+// "shelves/*".
+func pathTemplateToPattern(template string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			b.WriteByte(template[i])
+			continue
+		}
+
+		end := strings.IndexByte(template[i:], '}')
+		if end < 0 {
+			b.WriteString(template[i:])
+			break
+		}
+		variable := template[i+1 : i+end]
+		i += end
+
+		segments := "*"
+		if _, sub, ok := strings.Cut(variable, "="); ok {
+			segments = sub
+		}
+		b.WriteString(segments)
+	}
+
+	return b.String()
+}
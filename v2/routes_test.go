@@ -0,0 +1,170 @@
+package x402
+
+import (
+	"strings"
+	"testing"
+)
+
+func priced(amount string) PricingRule {
+	return PricingRule{
+		AcceptedTokens: []TokenRequirement{
+			{Network: "eip155:84532", Symbol: "USDC", AssetContract: "0x123", Recipient: "0xabc", Amount: amount},
+		},
+	}
+}
+
+func TestMatchRequest(t *testing.T) {
+	cfg := Config{
+		Verifier: &MockVerifier{},
+		EndpointPricing: map[string]PricingRule{
+			"/v1/items/{id}":          priced("100"),
+			"DELETE /v1/items/{id}":   priced("200"),
+			"POST /v1/items/{id}:buy": priced("300"),
+			"/v1/items/featured":      priced("400"),
+			"/v1/files/**":            priced("500"),
+			"/v1/files/{name}.json":   priced("600"),
+			"/v1/legacy/*":            priced("700"),
+			"/v1/*/reports":           priced("800"),
+			"/v1/teams/*":             priced("900"),
+		},
+		SkipPaths: []string{"GET /v1/files/public/**"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/v1/items/42", "100"},
+		{"DELETE", "/v1/items/42", "200"},
+		{"POST", "/v1/items/42:buy", "300"},
+		{"GET", "/v1/items/42:buy", "100"},
+		{"GET", "/v1/items/featured", "400"},
+		{"DELETE", "/v1/items/featured", "200"}, // explicit method beats specificity
+		{"GET", "/v1/items/42/extra", ""},
+		{"GET", "/v1/files/a/b/c", "500"},
+		{"GET", "/v1/files", "500"},
+		{"GET", "/v1/files/data.json", "600"},
+		{"GET", "/v1/files/public/logo.png", ""},
+		{"HEAD", "/v1/files/public/logo.png", "500"},
+		{"GET", "/v1/legacy", "700"},
+		{"GET", "/v1/legacy/a/b", "700"},
+		{"GET", "/v1/teams/reports", "900"}, // literal second segment beats "*"
+		{"GET", "/v1/users/reports", "800"},
+		{"GET", "/v2/items/42", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rule, ok := cfg.MatchRequest(tt.method, tt.path)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("expected no match, got amount %s", rule.AcceptedTokens[0].Amount)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected match")
+			}
+			if got := rule.AcceptedTokens[0].Amount; got != tt.want {
+				t.Errorf("expected amount %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMatchRequest_DeclarationOrder(t *testing.T) {
+	cfg := Config{
+		Verifier: &MockVerifier{},
+		EndpointRoutes: []EndpointRoute{
+			{Pattern: "/v1/{a}/items", PricingRule: priced("1")},
+			{Pattern: "/v1/{b}/items", PricingRule: priced("2")},
+		},
+		EndpointPricing: map[string]PricingRule{
+			"/v1/*/items": priced("3"),
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		rule, ok := cfg.MatchRequest("GET", "/v1/x/items")
+		if !ok || rule.AcceptedTokens[0].Amount != "1" {
+			t.Fatalf("expected first declared route to win, got %+v", rule)
+		}
+	}
+}
+
+func TestMatchRequest_MapTiesAreDeterministic(t *testing.T) {
+	cfg := Config{
+		Verifier: &MockVerifier{},
+		EndpointPricing: map[string]PricingRule{
+			"/v1/b*/items": priced("2"),
+			"/v1/*x/items": priced("1"),
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		rule, _ := cfg.MatchRequest("GET", "/v1/box/items")
+		if got := rule.AcceptedTokens[0].Amount; got != "1" {
+			t.Fatalf("expected lexically first pattern to win, got %s", got)
+		}
+	}
+}
+
+func TestValidate_InvalidPatterns(t *testing.T) {
+	tests := map[string]Config{
+		"lowercase method":   {EndpointPricing: map[string]PricingRule{"get /v1/items": priced("1")}},
+		"relative path":      {EndpointPricing: map[string]PricingRule{"v1/items": priced("1")}},
+		"catch-all not last": {EndpointPricing: map[string]PricingRule{"/v1/**/items": priced("1")}},
+		"unbalanced brace":   {EndpointPricing: map[string]PricingRule{"/v1/items/{id": priced("1")}},
+		"bad glob":           {EndpointPricing: map[string]PricingRule{"/v1/[a": priced("1")}},
+		"method on gRPC":     {MethodPricing: map[string]PricingRule{"POST /pkg.Service/Method": priced("1")}},
+		"bad skip path":      {SkipPaths: []string{"health"}},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg.Verifier = &MockVerifier{}
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), "pattern") {
+				t.Errorf("expected pattern error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRoute_Overlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/v1/items", "/v1/items", true},
+		{"/v1/items", "/v1/other", false},
+		{"/v1/*", "/v1/items/{id}", true},
+		{"/v1/**", "/v1", true},
+		{"/v1/{id}", "/v1/items/{id}", false},
+		{"/v1/*.json", "/v1/items.json", true},
+		{"/v1/*.json", "/v1/items.xml", false},
+		{"GET /v1/items", "/v1/items", true},
+		{"GET /v1/items", "POST /v1/items", false},
+	}
+
+	for _, tc := range tests {
+		a, _ := compileRoute(tc.a, true)
+		b, _ := compileRoute(tc.b, true)
+		if got := a.overlaps(b); got != tc.want {
+			t.Errorf("%q overlaps %q = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := b.overlaps(a); got != tc.want {
+			t.Errorf("%q overlaps %q = %v, want %v", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SettlementStatus reports whether a payment has settled.
+type SettlementStatus string
+
+const (
+	// SettlementConfirmed means the payment has settled.
+	SettlementConfirmed SettlementStatus = "confirmed"
+
+	// SettlementPending means the payment was verified and is queued on a
+	// Settler (see SettleAsync).
+	SettlementPending SettlementStatus = "pending"
+)
+
+// defaultSettlerQueueSize is used when NewSettler is given no queue size.
+const defaultSettlerQueueSize = 1024
+
+// defaultBatchWindow is used when Settler.BatchWindow is unset.
+const defaultBatchWindow = 100 * time.Millisecond
+
+// BatchSettler is implemented by verifiers that can settle several payments
+// on the same network together.
+type BatchSettler interface {
+	// SettleBatch settles payments[i] against requirements[i] and returns
+	// one result per payment, in order, or an error if the batch failed.
+	SettleBatch(ctx context.Context, payments []*PaymentPayload, requirements []*PaymentRequirements) ([]*SettlementResult, error)
+}
+
+// PendingSettlement is a verified payment queued on a Settler.
+type PendingSettlement struct {
+	Payload      *PaymentPayload
+	Requirements *PaymentRequirements
+
+	// Payment is a copy of the context the request was admitted with. It
+	// still reports SettlementPending after the payment settles.
+	Payment *PaymentContext
+
+	cfg *Config
+}
+
+// Settler settles payments in the background for SettleAsync. Payments wait
+// in a bounded queue; when it is full, or once the Settler has stopped,
+// payments are settled on the request path as in SettleBeforeHandler.
+//
+// Settled payments are recorded in the Ledger and reported to OnSettled.
+// Payments that fail to settle are recorded with their error and reported to
+// OnError; the request they paid for has already been served.
+type Settler struct {
+	// BatchSize is the most payments on one network settled together.
+	// Above 1, the Settler waits up to BatchWindow to fill a batch and
+	// settles it with the verifier's SettleBatch if it implements
+	// BatchSettler, or one payment at a time otherwise. Defaults to 1.
+	BatchSize int
+
+	// BatchWindow is how long to wait for a batch to fill. Defaults to 100ms.
+	BatchWindow time.Duration
+
+	// OnError is called when a queued payment fails to settle (optional).
+	OnError func(*PendingSettlement, error)
+
+	// OnSettled is called when a queued payment settles (optional).
+	OnSettled func(*PendingSettlement, *SettlementResult)
+
+	queue chan *PendingSettlement
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// NewSettler creates a Settler whose queue holds up to queueSize payments
+// (1024 if queueSize is not positive). Call Run to start settling.
+func NewSettler(queueSize int) *Settler {
+	if queueSize <= 0 {
+		queueSize = defaultSettlerQueueSize
+	}
+	return &Settler{queue: make(chan *PendingSettlement, queueSize)}
+}
+
+// Run settles queued payments until ctx is done. It then stops accepting
+// payments, settles everything already queued and returns. Run may be called
+// from several goroutines to settle in parallel.
+func (s *Settler) Run(ctx context.Context) {
+	settleCtx := context.WithoutCancel(ctx)
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case job := <-s.queue:
+			s.settle(settleCtx, s.collect(ctx, job))
+		}
+	}
+
+	s.stop()
+	s.drain(settleCtx)
+}
+
+// enqueue queues a payment, reporting false if the queue is full or the
+// Settler has stopped.
+func (s *Settler) enqueue(job *PendingSettlement) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	select {
+	case s.queue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Settler) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+// drain settles the payments left in the queue after stop.
+func (s *Settler) drain(ctx context.Context) {
+	var jobs []*PendingSettlement
+	for {
+		select {
+		case job := <-s.queue:
+			jobs = append(jobs, job)
+		default:
+			s.settle(ctx, jobs)
+			return
+		}
+	}
+}
+
+// collect waits up to BatchWindow for more payments to batch with first.
+func (s *Settler) collect(ctx context.Context, first *PendingSettlement) []*PendingSettlement {
+	jobs := []*PendingSettlement{first}
+	if s.batchSize() <= 1 {
+		return jobs
+	}
+
+	window := s.BatchWindow
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	for len(jobs) < s.batchSize() {
+		select {
+		case job := <-s.queue:
+			jobs = append(jobs, job)
+		case <-timer.C:
+			return jobs
+		case <-ctx.Done():
+			return jobs
+		}
+	}
+	return jobs
+}
+
+func (s *Settler) batchSize() int {
+	if s.BatchSize < 1 {
+		return 1
+	}
+	return s.BatchSize
+}
+
+// settle groups jobs by configuration and network and settles each group in
+// batches of at most BatchSize.
+func (s *Settler) settle(ctx context.Context, jobs []*PendingSettlement) {
+	type groupKey struct {
+		cfg     *Config
+		network string
+	}
+	var keys []groupKey
+	groups := make(map[groupKey][]*PendingSettlement)
+	for _, job := range jobs {
+		key := groupKey{job.cfg, job.Requirements.Network}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], job)
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		for len(group) > 0 {
+			n := min(len(group), s.batchSize())
+			s.settleBatch(ctx, key.cfg, group[:n])
+			group = group[n:]
+		}
+	}
+}
+
+func (s *Settler) settleBatch(ctx context.Context, cfg *Config, jobs []*PendingSettlement) {
+	batcher, ok := cfg.Verifier.(BatchSettler)
+	if !ok || len(jobs) == 1 {
+		for _, job := range jobs {
+			result, err := cfg.Verifier.Settle(ctx, job.Payload, job.Requirements)
+			s.finish(ctx, job, result, err)
+		}
+		return
+	}
+
+	payments := make([]*PaymentPayload, len(jobs))
+	requirements := make([]*PaymentRequirements, len(jobs))
+	for i, job := range jobs {
+		payments[i] = job.Payload
+		requirements[i] = job.Requirements
+	}
+
+	results, err := batcher.SettleBatch(ctx, payments, requirements)
+	if err == nil && len(results) != len(jobs) {
+		err = fmt.Errorf("batch settlement returned %d results for %d payments", len(results), len(jobs))
+	}
+	for i, job := range jobs {
+		if err != nil {
+			s.finish(ctx, job, nil, err)
+		} else {
+			s.finish(ctx, job, results[i], nil)
+		}
+	}
+}
+
+func (s *Settler) finish(ctx context.Context, job *PendingSettlement, result *SettlementResult, err error) {
+	if err == nil && result == nil {
+		err = fmt.Errorf("verifier returned no settlement result")
+	}
+	if err != nil {
+		job.cfg.recordSettlementFailure(ctx, job.Requirements, job.Payment, err)
+		if s.OnError != nil {
+			s.OnError(job, err)
+		}
+		return
+	}
+	job.cfg.RecordSettlement(ctx, job.Requirements, job.Payment, result)
+	if s.OnSettled != nil {
+		s.OnSettled(job, result)
+	}
+}
+
+// enqueueSettlement queues a verified payment on the Settler in SettleAsync
+// mode. Payments whose settlement the request depends on ("upto" payments and
+// rules with Credits or Access) are not queued. It returns nil if the payment
+// must be settled now.
+func (c *Config) enqueueSettlement(rule *PricingRule, payload *PaymentPayload, requirements *PaymentRequirements, payment *PaymentContext) *SettlementResult {
+	if c.SettlementMode != SettleAsync || c.Settler == nil ||
+		requirements.Scheme == SchemeUpto || rule.Credits || rule.Access != nil {
+		return nil
+	}
+
+	payment.Settlement = SettlementPending
+	// The request keeps using payment, so the Settler gets its own copy.
+	snapshot := *payment
+	job := &PendingSettlement{Payload: payload, Requirements: requirements, Payment: &snapshot, cfg: c}
+	if !c.Settler.enqueue(job) {
+		return nil
+	}
+	return &SettlementResult{
+		PayerAddress:     payment.PayerAddress,
+		RecipientAddress: requirements.PayTo,
+		Network:          requirements.Network,
+		Pending:          true,
+	}
+}
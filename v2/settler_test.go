@@ -0,0 +1,235 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stopSettler stops settler, settling everything it has queued.
+func stopSettler(settler *Settler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	settler.Run(ctx)
+}
+
+func TestPaymentMiddleware_SettleAsync(t *testing.T) {
+	settled := make(chan struct{}, 10)
+	ledger := NewMemoryLedger()
+	cfg := testConfig()
+	cfg.SettlementMode = SettleAsync
+	cfg.Settler = NewSettler(10)
+	cfg.NonceStore = NewMemoryNonceStore()
+	cfg.Ledger = ledger
+	cfg.Verifier = &MockVerifier{
+		SettleFunc: func(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements) (*SettlementResult, error) {
+			return &SettlementResult{TransactionHash: "0xtxhash", Network: requirements.Network}, nil
+		},
+	}
+	var confirmed *SettlementResult
+	var queued *PaymentContext
+	cfg.Settler.OnSettled = func(job *PendingSettlement, result *SettlementResult) {
+		confirmed, queued = result, job.Payment
+		settled <- struct{}{}
+	}
+
+	var payment *PaymentContext
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment, _ = GetPaymentFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if payment.Settlement != SettlementPending || payment.TransactionHash != "" {
+		t.Errorf("expected pending settlement without transaction, got %q %q", payment.Settlement, payment.TransactionHash)
+	}
+	response, err := DecodePaymentResponse(w.Header().Get(HeaderPaymentResponse))
+	if err != nil || !response.Success || !response.Pending {
+		t.Errorf("expected pending PAYMENT-RESPONSE, got %+v (%v)", response, err)
+	}
+	if len(settled) != 0 {
+		t.Fatal("expected no settlement on the request path")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cfg.Settler.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-settled:
+	case <-time.After(time.Second):
+		t.Fatal("payment was not settled in the background")
+	}
+	cancel()
+	<-done
+
+	if confirmed == nil || confirmed.TransactionHash != "0xtxhash" {
+		t.Errorf("expected OnSettled with the transaction, got %+v", confirmed)
+	}
+	if payment.Settlement != SettlementPending {
+		t.Errorf("expected the admitted context to stay pending, got %q", payment.Settlement)
+	}
+	if queued == payment || queued.PayerAddress != payment.PayerAddress {
+		t.Error("expected the settler to get a copy of the payment context")
+	}
+
+	records, _ := ledger.Query(context.Background(), LedgerQuery{})
+	if len(records) != 1 || records[0].Transaction != "0xtxhash" || records[0].Error != "" {
+		t.Errorf("unexpected ledger records: %+v", records)
+	}
+
+	// Once stopped, payments settle on the request path.
+	req = httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, resignedPaymentHeader(t, "0xnonce456", "0xsig456"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if payment.Settlement != SettlementConfirmed || payment.TransactionHash != "0xtxhash" {
+		t.Errorf("expected synchronous settlement, got %q", payment.Settlement)
+	}
+}
+
+// batchVerifier records the batches passed to SettleBatch.
+type batchVerifier struct {
+	MockVerifier
+	batches []int
+	err     error
+}
+
+func (v *batchVerifier) SettleBatch(ctx context.Context, payments []*PaymentPayload, requirements []*PaymentRequirements) ([]*SettlementResult, error) {
+	v.batches = append(v.batches, len(payments))
+	if v.err != nil {
+		return nil, v.err
+	}
+	results := make([]*SettlementResult, len(payments))
+	for i := range payments {
+		results[i] = &SettlementResult{TransactionHash: "0xbatch", Network: requirements[i].Network}
+	}
+	return results, nil
+}
+
+func enqueueTestPayments(t *testing.T, cfg *Config, networks ...string) {
+	t.Helper()
+	rule := cfg.EndpointPricing["/v1/paid"]
+	for _, network := range networks {
+		requirements := &PaymentRequirements{Scheme: SchemeExact, Network: network, Amount: "1000000", PayTo: "0xRecipient"}
+		payment := &PaymentContext{PayerAddress: "0xPayer", Network: network, Resource: "/v1/paid"}
+		result, err := cfg.SettlePayment(context.Background(), &rule, &PaymentPayload{X402Version: 2}, requirements, payment)
+		if err != nil || !result.Pending {
+			t.Fatalf("expected payment to be queued, got %+v (%v)", result, err)
+		}
+	}
+}
+
+func TestSettler_BatchesPerNetwork(t *testing.T) {
+	verifier := &batchVerifier{}
+	ledger := NewMemoryLedger()
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.SettlementMode = SettleAsync
+	cfg.Settler = NewSettler(10)
+	cfg.Settler.BatchSize = 2
+	cfg.Ledger = ledger
+
+	enqueueTestPayments(t, &cfg, "eip155:8453", "eip155:84532", "eip155:8453", "eip155:8453")
+	stopSettler(cfg.Settler)
+
+	// Three payments on one network make a batch of two and a single
+	// settlement; the other network's payment settles on its own.
+	if len(verifier.batches) != 1 || verifier.batches[0] != 2 {
+		t.Errorf("expected one batch of 2, got %v", verifier.batches)
+	}
+	records, _ := ledger.Query(context.Background(), LedgerQuery{})
+	if len(records) != 4 {
+		t.Fatalf("expected 4 ledger records, got %d", len(records))
+	}
+	batched := 0
+	for _, record := range records {
+		if record.Transaction == "0xbatch" {
+			batched++
+		}
+	}
+	if batched != 2 {
+		t.Errorf("expected 2 batched settlements, got %d", batched)
+	}
+}
+
+func TestSettler_Failure(t *testing.T) {
+	verifier := &batchVerifier{err: errors.New("chain unavailable")}
+	ledger := NewMemoryLedger()
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	cfg.SettlementMode = SettleAsync
+	cfg.Settler = NewSettler(10)
+	cfg.Settler.BatchSize = 2
+	cfg.Ledger = ledger
+
+	var failed []*PendingSettlement
+	cfg.Settler.OnError = func(job *PendingSettlement, err error) {
+		failed = append(failed, job)
+	}
+
+	enqueueTestPayments(t, &cfg, "eip155:8453", "eip155:8453")
+	stopSettler(cfg.Settler)
+
+	if len(failed) != 2 || failed[0].Payment.PayerAddress != "0xPayer" {
+		t.Errorf("expected 2 failures reported, got %d", len(failed))
+	}
+	records, _ := ledger.Query(context.Background(), LedgerQuery{})
+	if len(records) != 2 || records[0].Error != "chain unavailable" || records[0].Transaction != "" {
+		t.Errorf("expected failed ledger records, got %+v", records)
+	}
+}
+
+func TestSettler_FullQueueSettlesNow(t *testing.T) {
+	cfg := testConfig()
+	cfg.SettlementMode = SettleAsync
+	cfg.Settler = NewSettler(1)
+
+	enqueueTestPayments(t, &cfg, "eip155:84532")
+
+	rule := cfg.EndpointPricing["/v1/paid"]
+	payment := &PaymentContext{PayerAddress: "0xPayer"}
+	result, err := cfg.SettlePayment(context.Background(), &rule, &PaymentPayload{X402Version: 2},
+		&PaymentRequirements{Scheme: SchemeExact, Network: "eip155:84532"}, payment)
+	if err != nil || result.Pending || result.TransactionHash != "0xtxhash" {
+		t.Errorf("expected synchronous settlement, got %+v (%v)", result, err)
+	}
+	if payment.Settlement != SettlementConfirmed {
+		t.Errorf("expected confirmed settlement, got %q", payment.Settlement)
+	}
+}
+
+func TestConfig_SettleAsyncRequiresSettler(t *testing.T) {
+	cfg := testConfig()
+	cfg.SettlementMode = SettleAsync
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error without a Settler")
+	}
+
+	cfg.Settler = NewSettler(0)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "nonce store") {
+		t.Errorf("expected missing nonce store error, got %v", err)
+	}
+
+	cfg.NonceStore = NewMemoryNonceStore()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var mode SettlementMode
+	if err := mode.UnmarshalText([]byte("settle-async")); err != nil || mode != SettleAsync {
+		t.Errorf("expected settle-async to parse, got %v (%v)", mode, err)
+	}
+}
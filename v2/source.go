@@ -0,0 +1,45 @@
+package x402
+
+import "sync/atomic"
+
+// ConfigSource provides the configuration used to handle a request. The HTTP
+// middleware and the gRPC interceptors call Config once per request, so a
+// source can swap pricing without restarting the server. The returned Config
+// must already be validated and must not be modified.
+type ConfigSource interface {
+	Config() *Config
+}
+
+// AtomicConfig is a ConfigSource whose configuration can be replaced at runtime.
+// It is safe for concurrent use.
+type AtomicConfig struct {
+	current atomic.Pointer[Config]
+}
+
+// NewAtomicConfig validates cfg and returns a source serving it.
+func NewAtomicConfig(cfg Config) (*AtomicConfig, error) {
+	a := &AtomicConfig{}
+	if err := a.Store(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Config returns the current configuration.
+func (a *AtomicConfig) Config() *Config {
+	return a.current.Load()
+}
+
+// Store validates cfg and makes it the current configuration. If validation
+// fails the previous configuration stays active and the error is returned.
+// Fiat quotes issued under the previous configuration stay valid.
+func (a *AtomicConfig) Store(cfg Config) error {
+	if previous := a.current.Load(); previous != nil && cfg.quotes == nil {
+		cfg.quotes = previous.quotes
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	a.current.Store(&cfg)
+	return nil
+}
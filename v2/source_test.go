@@ -0,0 +1,54 @@
+package x402
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAtomicConfig_StoreKeepsPreviousOnInvalid(t *testing.T) {
+	source, err := NewAtomicConfig(testConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invalid := testConfig()
+	invalid.Verifier = nil
+	if err := source.Store(invalid); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if source.Config().Verifier == nil {
+		t.Error("expected previous config to stay active")
+	}
+}
+
+func TestPaymentMiddlewareFromSource_PicksUpNewPricing(t *testing.T) {
+	source, err := NewAtomicConfig(testConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := PaymentMiddlewareFromSource(source)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := serve("/v1/free"); code != http.StatusOK {
+		t.Fatalf("expected /v1/free to be free, got %d", code)
+	}
+
+	updated := testConfig()
+	updated.EndpointPricing["/v1/free"] = updated.EndpointPricing["/v1/paid"]
+	if err := source.Store(updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if code := serve("/v1/free"); code != http.StatusPaymentRequired {
+		t.Errorf("expected new pricing to apply without rebuilding the middleware, got %d", code)
+	}
+}
@@ -0,0 +1,188 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	x402pb "github.com/becomeliminal/grpc-gateway-x402/v2/proto/x402"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ErrorDomain is the ErrorInfo domain of gRPC statuses built from PaymentErrors.
+const ErrorDomain = "x402.org"
+
+// GRPCCode maps a PaymentError code to the gRPC status code returned for it.
+// Malformed payments are INVALID_ARGUMENT; payments the client can fix by
+// signing again are RESOURCE_EXHAUSTED and come with a payment challenge;
+// replays and reused idempotency keys are ALREADY_EXISTS; verifier and
+// settlement failures are UNAVAILABLE; anything else is INTERNAL.
+func GRPCCode(code string) codes.Code {
+	switch code {
+	case ErrCodeInvalidPayment:
+		return codes.InvalidArgument
+	case ErrCodePaymentRequired, ErrCodePaymentRejected, ErrCodeNetworkNotSupported,
+		ErrCodeTokenNotAccepted, ErrCodeInsufficientAmount, ErrCodeAmountMismatch,
+		ErrCodeRecipientMismatch, ErrCodeSchemeMismatch, ErrCodeExpiredPayment:
+		return codes.ResourceExhausted
+	case ErrCodeDuplicatePayment, ErrCodeIdempotencyConflict:
+		return codes.AlreadyExists
+	case ErrCodeVerificationFailed, ErrCodeSettlementFailed:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// PaymentErrorInfo returns the ErrorInfo detail identifying a PaymentError code.
+func PaymentErrorInfo(code string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{Reason: code, Domain: ErrorDomain}
+}
+
+// PaymentErrorStatus converts a PaymentError to a gRPC status with the code
+// from GRPCCode and an ErrorInfo detail whose reason is the PaymentError code.
+// Other errors become INTERNAL without details.
+func PaymentErrorStatus(err error) *status.Status {
+	var pe *PaymentError
+	if !errors.As(err, &pe) {
+		return status.New(codes.Internal, err.Error())
+	}
+
+	message := pe.Message
+	if pe.Cause != nil {
+		message = fmt.Sprintf("%s: %v", pe.Message, pe.Cause)
+	}
+
+	st := status.New(GRPCCode(pe.Code), message)
+	if withInfo, err := st.WithDetails(PaymentErrorInfo(pe.Code)); err == nil {
+		st = withInfo
+	}
+	return st
+}
+
+// PaymentErrorCodeFromStatus returns the PaymentError code carried in a
+// status's ErrorInfo detail.
+func PaymentErrorCodeFromStatus(st *status.Status) (string, bool) {
+	if st == nil {
+		return "", false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == ErrorDomain {
+			return info.GetReason(), true
+		}
+	}
+	return "", false
+}
+
+// PaymentRequiredStatus builds the RESOURCE_EXHAUSTED status returned by the gRPC
+// interceptors for calls that require payment. The requirements are attached as an
+// x402pb.PaymentRequired status detail and the message is human-readable. If
+// legacyMessage is set, the message instead carries the base64 JSON requirements
+// for clients that predate status details.
+func PaymentRequiredStatus(response *PaymentRequiredResponse, legacyMessage bool) (*status.Status, error) {
+	message := "payment required"
+	if response.Error != "" && response.Error != message {
+		message = fmt.Sprintf("payment required: %s", response.Error)
+	}
+
+	if legacyMessage {
+		jsonBytes, err := json.Marshal(response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payment requirements: %w", err)
+		}
+		message = base64.StdEncoding.EncodeToString(jsonBytes)
+	}
+
+	detail, err := paymentRequiredToProto(response)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := status.New(codes.ResourceExhausted, message).WithDetails(detail)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach payment requirements: %w", err)
+	}
+	return st, nil
+}
+
+// PaymentRequiredFromStatus extracts the payment requirements from a payment
+// challenge status. It reads the x402pb.PaymentRequired detail and falls back to
+// a base64 JSON message from servers that predate status details.
+func PaymentRequiredFromStatus(st *status.Status) (*PaymentRequiredResponse, bool) {
+	if st == nil || st.Code() != codes.ResourceExhausted {
+		return nil, false
+	}
+
+	for _, detail := range st.Details() {
+		if pb, ok := detail.(*x402pb.PaymentRequired); ok && len(pb.GetAccepts()) > 0 {
+			return paymentRequiredFromProto(pb), true
+		}
+	}
+
+	response, err := DecodePaymentRequirements(st.Message())
+	if err != nil || len(response.Accepts) == 0 {
+		return nil, false
+	}
+	return response, true
+}
+
+func paymentRequiredToProto(response *PaymentRequiredResponse) (*x402pb.PaymentRequired, error) {
+	pb := &x402pb.PaymentRequired{
+		X402Version: int32(response.X402Version),
+		Error:       response.Error,
+		Accepts:     make([]*x402pb.PaymentRequirements, 0, len(response.Accepts)),
+	}
+
+	for _, req := range response.Accepts {
+		var extra *structpb.Struct
+		if len(req.Extra) > 0 {
+			var err error
+			if extra, err = structpb.NewStruct(req.Extra); err != nil {
+				return nil, fmt.Errorf("failed to convert extra for %s: %w", req.Network, err)
+			}
+		}
+
+		pb.Accepts = append(pb.Accepts, &x402pb.PaymentRequirements{
+			Scheme:            req.Scheme,
+			Network:           req.Network,
+			Amount:            req.Amount,
+			Asset:             req.Asset,
+			PayTo:             req.PayTo,
+			MaxTimeoutSeconds: int32(req.MaxTimeoutSeconds),
+			Extra:             extra,
+		})
+	}
+
+	return pb, nil
+}
+
+func paymentRequiredFromProto(pb *x402pb.PaymentRequired) *PaymentRequiredResponse {
+	response := &PaymentRequiredResponse{
+		X402Version: int(pb.GetX402Version()),
+		Error:       pb.GetError(),
+		Accepts:     make([]PaymentRequirements, 0, len(pb.GetAccepts())),
+	}
+
+	for _, req := range pb.GetAccepts() {
+		var extra map[string]interface{}
+		if req.GetExtra() != nil {
+			extra = req.GetExtra().AsMap()
+		}
+
+		response.Accepts = append(response.Accepts, PaymentRequirements{
+			Scheme:            req.GetScheme(),
+			Network:           req.GetNetwork(),
+			Amount:            req.GetAmount(),
+			Asset:             req.GetAsset(),
+			PayTo:             req.GetPayTo(),
+			MaxTimeoutSeconds: int(req.GetMaxTimeoutSeconds()),
+			Extra:             extra,
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,167 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testPaymentRequiredResponse() *PaymentRequiredResponse {
+	return &PaymentRequiredResponse{
+		X402Version: 2,
+		Error:       "payment required",
+		Accepts: []PaymentRequirements{{
+			Scheme:            "exact",
+			Network:           "eip155:84532",
+			Amount:            "1000000",
+			Asset:             "0x036CbD53842c5426634e7929541eC2318f3dCF7e",
+			PayTo:             "0xRecipient",
+			MaxTimeoutSeconds: 300,
+			Extra:             map[string]interface{}{"name": "USDC", "version": "2"},
+		}},
+	}
+}
+
+func TestPaymentRequiredStatus_Details(t *testing.T) {
+	st, err := PaymentRequiredStatus(testPaymentRequiredResponse(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if st.Code() != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", st.Code())
+	}
+	if st.Message() != "payment required" {
+		t.Errorf("expected human-readable message, got %q", st.Message())
+	}
+
+	// Round-trip through the wire representation.
+	decoded, ok := PaymentRequiredFromStatus(status.FromProto(st.Proto()))
+	if !ok {
+		t.Fatal("expected payment requirements in status details")
+	}
+	if len(decoded.Accepts) != 1 {
+		t.Fatalf("expected 1 requirement, got %d", len(decoded.Accepts))
+	}
+	req := decoded.Accepts[0]
+	if req.Amount != "1000000" || req.PayTo != "0xRecipient" || req.MaxTimeoutSeconds != 300 {
+		t.Errorf("unexpected requirement: %+v", req)
+	}
+	if req.Extra["name"] != "USDC" {
+		t.Errorf("expected extra to round-trip, got %v", req.Extra)
+	}
+}
+
+func TestPaymentRequiredStatus_RejectionMessage(t *testing.T) {
+	response := testPaymentRequiredResponse()
+	response.Error = "payment amount too low"
+
+	st, err := PaymentRequiredStatus(response, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.Message() != "payment required: payment amount too low" {
+		t.Errorf("unexpected message: %q", st.Message())
+	}
+}
+
+func TestPaymentRequiredStatus_LegacyMessage(t *testing.T) {
+	st, err := PaymentRequiredStatus(testPaymentRequiredResponse(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Old clients decode the message directly.
+	decoded, err := DecodePaymentRequirements(st.Message())
+	if err != nil {
+		t.Fatalf("expected base64 JSON message: %v", err)
+	}
+	if len(decoded.Accepts) != 1 {
+		t.Errorf("expected 1 requirement, got %d", len(decoded.Accepts))
+	}
+	if len(st.Details()) != 1 {
+		t.Errorf("expected the status detail as well, got %d details", len(st.Details()))
+	}
+}
+
+func TestPaymentRequiredFromStatus_LegacyServer(t *testing.T) {
+	jsonBytes, _ := json.Marshal(testPaymentRequiredResponse())
+	st := status.New(codes.ResourceExhausted, base64.StdEncoding.EncodeToString(jsonBytes))
+
+	decoded, ok := PaymentRequiredFromStatus(st)
+	if !ok {
+		t.Fatal("expected requirements from legacy message")
+	}
+	if decoded.Accepts[0].Network != "eip155:84532" {
+		t.Errorf("unexpected network: %s", decoded.Accepts[0].Network)
+	}
+}
+
+func TestPaymentRequiredFromStatus_NotAChallenge(t *testing.T) {
+	for _, st := range []*status.Status{
+		nil,
+		status.New(codes.ResourceExhausted, "rate limited"),
+		status.New(codes.Internal, "payment required"),
+	} {
+		if _, ok := PaymentRequiredFromStatus(st); ok {
+			t.Errorf("expected no payment requirements from %v", st)
+		}
+	}
+}
+
+func TestGRPCCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want codes.Code
+	}{
+		{ErrCodeInvalidPayment, codes.InvalidArgument},
+		{ErrCodePaymentRequired, codes.ResourceExhausted},
+		{ErrCodePaymentRejected, codes.ResourceExhausted},
+		{ErrCodeInsufficientAmount, codes.ResourceExhausted},
+		{ErrCodeExpiredPayment, codes.ResourceExhausted},
+		{ErrCodeNetworkNotSupported, codes.ResourceExhausted},
+		{ErrCodeDuplicatePayment, codes.AlreadyExists},
+		{ErrCodeVerificationFailed, codes.Unavailable},
+		{ErrCodeSettlementFailed, codes.Unavailable},
+		{ErrCodeInvalidConfig, codes.Internal},
+		{"SOMETHING_ELSE", codes.Internal},
+	}
+
+	for _, tt := range tests {
+		if got := GRPCCode(tt.code); got != tt.want {
+			t.Errorf("GRPCCode(%s) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentErrorStatus(t *testing.T) {
+	err := fmt.Errorf("verify: %w", NewPaymentError(ErrCodeVerificationFailed, "payment verification error", errors.New("timeout")))
+
+	st := PaymentErrorStatus(err)
+	if st.Code() != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", st.Code())
+	}
+	if st.Message() != "payment verification error: timeout" {
+		t.Errorf("unexpected message: %q", st.Message())
+	}
+
+	code, ok := PaymentErrorCodeFromStatus(status.FromProto(st.Proto()))
+	if !ok || code != ErrCodeVerificationFailed {
+		t.Errorf("expected ErrorInfo reason %s, got %q", ErrCodeVerificationFailed, code)
+	}
+}
+
+func TestPaymentErrorStatus_OtherError(t *testing.T) {
+	st := PaymentErrorStatus(errors.New("boom"))
+	if st.Code() != codes.Internal {
+		t.Errorf("expected Internal, got %v", st.Code())
+	}
+	if _, ok := PaymentErrorCodeFromStatus(st); ok {
+		t.Error("expected no ErrorInfo for a plain error")
+	}
+}
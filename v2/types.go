@@ -56,6 +56,18 @@ type SettlementResult struct {
 	PayerAddress     string
 	RecipientAddress string
 	Network          string // CAIP-2
+
+	// Balance is the payer's remaining credit after a payment for a rule
+	// with Credits, in atomic units.
+	Balance string
+
+	// AccessToken is the access token bought by a payment for a rule with
+	// Access.
+	AccessToken string
+
+	// Pending is set when the payment was queued on a Settler instead of
+	// settled (see SettleAsync). TransactionHash is then empty.
+	Pending bool
 }
 
 // PaymentResponse is sent in the PAYMENT-RESPONSE header.
@@ -65,6 +77,9 @@ type PaymentResponse struct {
 	Network     string `json:"network,omitempty"` // CAIP-2
 	Payer       string `json:"payer,omitempty"`
 	ErrorReason string `json:"errorReason,omitempty"`
+	Balance     string `json:"balance,omitempty"` // Remaining credit, in atomic units
+	AccessToken string `json:"accessToken,omitempty"`
+	Pending     bool   `json:"pending,omitempty"` // Settlement is queued; no transaction yet
 }
 
 // PaymentRequiredResponse is the 402 response body.
@@ -94,6 +109,14 @@ type ChainVerifier interface {
 	SupportedKinds() []SupportedKind
 }
 
+// AmountSettler is implemented by verifiers that can settle less than the
+// authorized amount, as the "upto" scheme requires.
+type AmountSettler interface {
+	// SettleAmount executes the payment on-chain for amount, in atomic units,
+	// which is at most requirements.Amount.
+	SettleAmount(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements, amount string) (*SettlementResult, error)
+}
+
 // PaymentContext contains payment information that can be extracted in handlers.
 type PaymentContext struct {
 	Verified        bool
@@ -103,6 +126,36 @@ type PaymentContext struct {
 	Network         string // CAIP-2
 	TransactionHash string
 	SettledAt       time.Time
+
+	// Scheme is the payment scheme, e.g. "exact" or "upto".
+	Scheme string
+
+	// MaxAmount is the most an "upto" payment can settle, in atomic units.
+	MaxAmount string
+
+	// Balance is the payer's remaining credit once the payment is settled,
+	// for rules with Credits.
+	Balance string
+
+	// Resource is the HTTP path or full gRPC method paid for.
+	Resource string
+
+	// RequestID is the request's X-Request-Id header or x-request-id
+	// metadata, if any.
+	RequestID string
+
+	// AccessExpiresAt is when the access bought by the payment ends, for
+	// rules with Access. Requests admitted with an access token carry the
+	// context of the payment that bought it.
+	AccessExpiresAt time.Time
+
+	// Settlement is SettlementConfirmed if the payment settled before the
+	// request was admitted, or SettlementPending if it was queued on a
+	// Settler (see SettleAsync). It is not updated once a queued payment
+	// settles; use Settler.OnSettled or the Ledger to observe that.
+	Settlement SettlementStatus
+
+	usage *usageReport
 }
 
 type contextKey string
@@ -0,0 +1,141 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// usageReport holds the usage reported for an "upto" payment. PaymentContext
+// keeps it behind a pointer so the context can be copied.
+type usageReport struct {
+	mu       sync.Mutex
+	amount   string
+	reported bool
+}
+
+// NewPaymentContext returns the context of a payment verified against
+// requirements. It can record the usage of an "upto" payment (see
+// ReportUsage); the middleware and interceptors fill in the other fields.
+func NewPaymentContext(result *VerificationResult, requirements *PaymentRequirements) *PaymentContext {
+	return &PaymentContext{
+		Verified:     true,
+		PayerAddress: result.PayerAddress,
+		Amount:       result.Amount,
+		Network:      requirements.Network,
+		Scheme:       requirements.Scheme,
+		MaxAmount:    requirements.Amount,
+		usage:        &usageReport{},
+	}
+}
+
+// ReportUsage records the amount, in atomic units of the paid token, that the
+// request used under an "upto" payment. The middleware or interceptor settles
+// it once the handler returns. Calling it again replaces the earlier report.
+func ReportUsage(ctx context.Context, amount string) error {
+	payment, ok := ctx.Value(PaymentContextKey).(*PaymentContext)
+	if !ok {
+		return fmt.Errorf("payment context not found")
+	}
+	return payment.ReportUsage(amount)
+}
+
+// ReportUsage records the amount used under an "upto" payment. It fails for
+// other schemes, for amounts above MaxAmount, and for contexts not built with
+// NewPaymentContext.
+func (p *PaymentContext) ReportUsage(amount string) error {
+	if p.Scheme != SchemeUpto {
+		return fmt.Errorf("usage can only be reported for %s payments, not %q", SchemeUpto, p.Scheme)
+	}
+	if p.usage == nil {
+		return fmt.Errorf("usage can only be reported for payments admitted by the middleware or interceptors")
+	}
+
+	used, ok := new(big.Int).SetString(amount, 10)
+	if !ok || used.Sign() < 0 {
+		return fmt.Errorf("invalid usage amount %q", amount)
+	}
+	if max, ok := new(big.Int).SetString(p.MaxAmount, 10); ok && used.Cmp(max) > 0 {
+		return fmt.Errorf("usage %s exceeds the authorized maximum %s", amount, p.MaxAmount)
+	}
+
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+	p.usage.amount = used.String()
+	p.usage.reported = true
+	return nil
+}
+
+// UsedAmount returns the amount to settle for an "upto" payment: the reported
+// usage, or MaxAmount if the handler reported none.
+func (p *PaymentContext) UsedAmount() string {
+	if p.usage == nil {
+		return p.MaxAmount
+	}
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+	if p.usage.reported {
+		return p.usage.amount
+	}
+	return p.MaxAmount
+}
+
+// SettlePayment settles a verified payment for rule. "upto" payments are
+// settled for the amount reported through payment (see ReportUsage) with the
+// Verifier's SettleAmount. Rules with Credits draw on the payer's prepaid
+// balance first (see BalanceStore). Other payments use Settle. For rules with
+// Access, the result carries the access token bought by the payment. Every
+// settlement is recorded in the Ledger.
+//
+// An "upto" payment whose handler reported no usage is not settled or
+// recorded, and the result is nil; the payment's nonce should be released so
+// the authorization can be used again.
+//
+// In SettleAsync mode the payment is queued on the Settler when possible,
+// and the result is Pending.
+func (c *Config) SettlePayment(ctx context.Context, rule *PricingRule, payload *PaymentPayload, requirements *PaymentRequirements, payment *PaymentContext) (*SettlementResult, error) {
+	if requirements.Scheme == SchemeUpto && payment.UsedAmount() == "0" {
+		return nil, nil
+	}
+	if result := c.enqueueSettlement(rule, payload, requirements, payment); result != nil {
+		return result, nil
+	}
+
+	result, err := c.settlePayment(ctx, rule, payload, requirements, payment)
+	if err != nil {
+		return nil, err
+	}
+	payment.Settlement = SettlementConfirmed
+	c.RecordSettlement(ctx, requirements, payment, result)
+	if rule.Access == nil {
+		return result, nil
+	}
+
+	// The payment has settled; failing to issue a token must not fail the request.
+	token, err := c.issueAccessToken(rule, payment, result)
+	if err != nil {
+		if c.OnAccessTokenError != nil {
+			c.OnAccessTokenError(payment, err)
+		}
+		return result, nil
+	}
+	result.AccessToken = token
+	return result, nil
+}
+
+func (c *Config) settlePayment(ctx context.Context, rule *PricingRule, payload *PaymentPayload, requirements *PaymentRequirements, payment *PaymentContext) (*SettlementResult, error) {
+	if rule.Credits && c.BalanceStore != nil && requirements.Scheme != SchemeUpto {
+		return c.settleWithCredits(ctx, payload, requirements, payment)
+	}
+
+	if requirements.Scheme != SchemeUpto {
+		return c.Verifier.Settle(ctx, payload, requirements)
+	}
+
+	settler, ok := c.Verifier.(AmountSettler)
+	if !ok {
+		return nil, NewPaymentError(ErrCodeInvalidConfig, fmt.Sprintf("verifier cannot settle %s payments", SchemeUpto), nil)
+	}
+	return settler.SettleAmount(ctx, payload, requirements, payment.UsedAmount())
+}
@@ -0,0 +1,184 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// uptoVerifier is a MockVerifier that records SettleAmount calls.
+type uptoVerifier struct {
+	MockVerifier
+	settledAmount string
+	settleCalls   int
+}
+
+func (v *uptoVerifier) SettleAmount(ctx context.Context, payload *PaymentPayload, requirements *PaymentRequirements, amount string) (*SettlementResult, error) {
+	v.settleCalls++
+	v.settledAmount = amount
+	return &SettlementResult{TransactionHash: "0xtx", Amount: amount, Network: requirements.Network}, nil
+}
+
+func uptoConfig(verifier ChainVerifier) Config {
+	cfg := testConfig()
+	cfg.Verifier = verifier
+	rule := cfg.EndpointPricing["/v1/paid"]
+	rule.Scheme = SchemeUpto
+	cfg.EndpointPricing["/v1/paid"] = rule
+	return cfg
+}
+
+func TestPaymentMiddleware_UptoAdvertisesScheme(t *testing.T) {
+	handler := PaymentMiddleware(uptoConfig(&uptoVerifier{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/paid", nil))
+
+	var body PaymentRequiredResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode 402 body: %v", err)
+	}
+	if len(body.Accepts) != 1 || body.Accepts[0].Scheme != SchemeUpto {
+		t.Errorf("expected upto requirements, got %+v", body.Accepts)
+	}
+}
+
+func TestPaymentMiddleware_UptoSettlesReportedUsage(t *testing.T) {
+	verifier := &uptoVerifier{}
+	handler := PaymentMiddleware(uptoConfig(verifier))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verifier.settleCalls != 0 {
+			t.Error("upto payments must settle after the handler")
+		}
+		if err := ReportUsage(r.Context(), "250000"); err != nil {
+			t.Errorf("unexpected error reporting usage: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if verifier.settleCalls != 1 || verifier.settledAmount != "250000" {
+		t.Errorf("expected one settlement of 250000, got %d of %q", verifier.settleCalls, verifier.settledAmount)
+	}
+	if w.Header().Get(HeaderPaymentResponse) == "" {
+		t.Error("expected PAYMENT-RESPONSE header")
+	}
+}
+
+func TestPaymentMiddleware_UptoHandlerFailureSkipsSettlement(t *testing.T) {
+	verifier := &uptoVerifier{}
+	handler := PaymentMiddleware(uptoConfig(verifier))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/paid", nil)
+	req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if verifier.settleCalls != 0 {
+		t.Errorf("expected no settlement after a failed handler, got %d", verifier.settleCalls)
+	}
+}
+
+func TestPaymentMiddleware_UptoUnusedSkipsSettlement(t *testing.T) {
+	verifier := &uptoVerifier{}
+	cfg := uptoConfig(verifier)
+	cfg.NonceStore = NewMemoryNonceStore()
+	ledger := NewMemoryLedger()
+	cfg.Ledger = ledger
+	handler := PaymentMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ReportUsage(r.Context(), "0"); err != nil {
+			t.Errorf("unexpected error reporting usage: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The authorization is not used up, so it can be presented again.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/v1/paid", nil)
+		req.Header.Set(HeaderPaymentSignature, makeV2PaymentHeader(t))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, w.Code)
+		}
+		if w.Header().Get(HeaderPaymentResponse) != "" {
+			t.Errorf("request %d: expected no PAYMENT-RESPONSE header", i)
+		}
+	}
+	if verifier.settleCalls != 0 {
+		t.Errorf("expected no settlement of unused payments, got %d", verifier.settleCalls)
+	}
+	if records, _ := ledger.Query(context.Background(), LedgerQuery{}); len(records) != 0 {
+		t.Errorf("expected nothing recorded, got %d records", len(records))
+	}
+}
+
+func TestPaymentContext_ReportUsage(t *testing.T) {
+	payment := NewPaymentContext(&VerificationResult{Valid: true}, &PaymentRequirements{Scheme: SchemeUpto, Amount: "1000"})
+
+	if got := payment.UsedAmount(); got != "1000" {
+		t.Errorf("expected unreported usage to settle the maximum, got %s", got)
+	}
+	if err := payment.ReportUsage("1001"); err == nil {
+		t.Error("expected error for usage above the maximum")
+	}
+	if err := payment.ReportUsage("-1"); err == nil {
+		t.Error("expected error for negative usage")
+	}
+	if err := payment.ReportUsage("0"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := payment.UsedAmount(); got != "0" {
+		t.Errorf("expected reported usage 0, got %s", got)
+	}
+
+	exact := &PaymentContext{Scheme: SchemeExact, MaxAmount: "1000"}
+	if err := exact.ReportUsage("10"); err == nil {
+		t.Error("expected error reporting usage for an exact payment")
+	}
+	if err := ReportUsage(context.Background(), "10"); err == nil {
+		t.Error("expected error without a payment context")
+	}
+	unbuilt := &PaymentContext{Scheme: SchemeUpto, MaxAmount: "1000"}
+	if err := unbuilt.ReportUsage("10"); err == nil {
+		t.Error("expected error reporting usage for a context not built by NewPaymentContext")
+	}
+	if got := unbuilt.UsedAmount(); got != "1000" {
+		t.Errorf("expected unreported usage to settle the maximum, got %s", got)
+	}
+}
+
+func TestPaymentContext_CopySharesUsage(t *testing.T) {
+	payment := NewPaymentContext(&VerificationResult{Valid: true}, &PaymentRequirements{Scheme: SchemeUpto, Amount: "1000"})
+	copied := *payment
+
+	if err := payment.ReportUsage("250"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := copied.UsedAmount(); got != "250" {
+		t.Errorf("expected the copy to see reported usage 250, got %s", got)
+	}
+}
+
+func TestConfigValidation_UptoRequiresAmountSettler(t *testing.T) {
+	cfg := uptoConfig(&MockVerifier{})
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for upto pricing with a verifier that can't settle amounts")
+	}
+
+	cfg = uptoConfig(&uptoVerifier{})
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConfigWatcher is a ConfigSource backed by a config file (see LoadConfig).
+// Run polls the file and, when it changes, re-parses and re-validates it and
+// swaps the configuration atomically. A file that fails to load or validate
+// is reported through OnError and the previous configuration stays active.
+type ConfigWatcher struct {
+	// Interval between checks for file changes. Defaults to 2 seconds.
+	Interval time.Duration
+
+	// OnError is called when a reload fails (optional).
+	OnError func(error)
+
+	// OnReload is called after a new configuration has been swapped in (optional).
+	OnReload func(*Config)
+
+	path    string
+	prepare func(*Config)
+	config  AtomicConfig
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// NewConfigWatcher loads the config file at path and returns a watcher serving it.
+// prepare is called on every loaded Config before validation to fill in fields
+// a file can't express, such as Verifier and NonceStore. Reuse the same
+// NonceStore across reloads so replay protection survives a price change.
+func NewConfigWatcher(path string, prepare func(*Config)) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{
+		path:    path,
+		prepare: prepare,
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Config returns the current configuration.
+func (w *ConfigWatcher) Config() *Config {
+	return w.config.Config()
+}
+
+// Reload reads and validates the config file and makes it current. On error
+// the previous configuration stays active.
+func (w *ConfigWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat config: %w", err)
+	}
+	return w.reload(info)
+}
+
+func (w *ConfigWatcher) reload(info os.FileInfo) error {
+	// Record the file state first so a bad file is reported once, not on every poll.
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	cfg, err := LoadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	if w.prepare != nil {
+		w.prepare(&cfg)
+	}
+	if err := w.config.Store(cfg); err != nil {
+		return fmt.Errorf("%s: invalid config: %w", w.path, err)
+	}
+
+	if w.OnReload != nil {
+		w.OnReload(w.config.Config())
+	}
+	return nil
+}
+
+// Run polls the config file until ctx is done, reloading it when its
+// modification time or size changes.
+func (w *ConfigWatcher) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.poll(); err != nil && w.OnError != nil {
+				w.OnError(err)
+			}
+		}
+	}
+}
+
+func (w *ConfigWatcher) poll() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat config: %w", err)
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return nil
+	}
+	return w.reload(info)
+}
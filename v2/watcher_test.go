@@ -0,0 +1,122 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const watcherConfigYAML = `endpoint_pricing:
+  /v1/paid:
+    accepted_tokens:
+      - network: eip155:84532
+        asset_contract: "0x036CbD53842c5426634e7929541eC2318f3dCF7e"
+        symbol: USDC
+        recipient: "0xRecipient"
+        amount: "%s"
+`
+
+func writeWatcherConfig(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func watchedAmount(w *ConfigWatcher) string {
+	return w.Config().EndpointPricing["/v1/paid"].AcceptedTokens[0].Amount
+}
+
+func newTestWatcher(t *testing.T) (*ConfigWatcher, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "x402.yaml")
+	writeWatcherConfig(t, path, fmt.Sprintf(watcherConfigYAML, "1000"), time.Unix(1000, 0))
+
+	w, err := NewConfigWatcher(path, func(cfg *Config) {
+		cfg.Verifier = &MockVerifier{}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return w, path
+}
+
+func TestConfigWatcher_Reloads(t *testing.T) {
+	w, path := newTestWatcher(t)
+	if got := watchedAmount(w); got != "1000" {
+		t.Fatalf("expected initial amount 1000, got %s", got)
+	}
+
+	if err := w.poll(); err != nil {
+		t.Fatalf("unchanged file should not reload: %v", err)
+	}
+
+	writeWatcherConfig(t, path, fmt.Sprintf(watcherConfigYAML, "2000"), time.Unix(2000, 0))
+	if err := w.poll(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := watchedAmount(w); got != "2000" {
+		t.Errorf("expected reloaded amount 2000, got %s", got)
+	}
+	if w.Config().Verifier == nil {
+		t.Error("expected prepare to run on reload")
+	}
+}
+
+func TestConfigWatcher_KeepsConfigOnInvalidFile(t *testing.T) {
+	w, path := newTestWatcher(t)
+
+	writeWatcherConfig(t, path, fmt.Sprintf(watcherConfigYAML, ""), time.Unix(2000, 0))
+	err := w.poll()
+	if err == nil || !strings.Contains(err.Error(), "amount is required") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if got := watchedAmount(w); got != "1000" {
+		t.Errorf("expected previous amount 1000 to stay active, got %s", got)
+	}
+
+	if err := w.poll(); err != nil {
+		t.Errorf("expected a bad file to be reported once, got %v", err)
+	}
+}
+
+func TestConfigWatcher_Run(t *testing.T) {
+	w, path := newTestWatcher(t)
+	w.Interval = 10 * time.Millisecond
+
+	reloaded := make(chan string, 1)
+	w.OnReload = func(cfg *Config) {
+		reloaded <- cfg.EndpointPricing["/v1/paid"].AcceptedTokens[0].Amount
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	writeWatcherConfig(t, path, fmt.Sprintf(watcherConfigYAML, "3000"), time.Unix(3000, 0))
+
+	select {
+	case amount := <-reloaded:
+		if amount != "3000" {
+			t.Errorf("expected reloaded amount 3000, got %s", amount)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+}
+
+func TestNewConfigWatcher_InvalidInitialConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x402.yaml")
+	writeWatcherConfig(t, path, fmt.Sprintf(watcherConfigYAML, "1000"), time.Unix(1000, 0))
+
+	if _, err := NewConfigWatcher(path, nil); err == nil {
+		t.Error("expected error when prepare doesn't set a verifier")
+	}
+}